@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"log"
+	"pipe/internal/config"
+	"pipe/internal/repository"
+	"pipe/internal/repository/cassandra"
+	"pipe/pkg/sealed"
+)
+
+// SealSenders replaces the sender IDs of messages stored before sealed
+// sender with sender tokens and sealed references. It's safe to run more
+// than once.
+func SealSenders() {
+	config.LoadConfig()
+
+	sealer := newSealer()
+
+	cassandraSession, err := cassandra.NewCassandraSession(config.AppConfig.CassandraHost, config.AppConfig.CassandraKeyspace)
+	if err != nil {
+		log.Fatalf("failed connect to cassandra: %v", err)
+	}
+	defer cassandraSession.Close()
+
+	messageRepository := repository.NewMessageCassandraRepository(cassandraSession)
+
+	count, err := messageRepository.SealSenders(func(recipient, sender int64) (string, string, error) {
+		senderRef, err := sealer.SealSender(recipient, sender)
+		return sealer.SenderToken(recipient, sender), senderRef, err
+	})
+	if err != nil {
+		log.Fatalf("failed to seal senders after %d messages: %v", count, err)
+	}
+
+	log.Printf("sealed the sender of %d messages\n", count)
+}
+
+func newSealer() *sealed.Sealer {
+	keys, err := sealed.ParseKeys(config.AppConfig.SenderTokenKeys)
+	if err != nil {
+		log.Fatalf("failed to parse SENDER_TOKEN_KEYS: %v", err)
+	}
+	if len(keys) == 0 {
+		log.Fatal("SENDER_TOKEN_KEYS is empty. Generate a key with `openssl rand -hex 32` and set " +
+			"SENDER_TOKEN_KEYS=1:<key> on every instance; keep it, since messages sealed with a lost key can't be replied to or matched to their sender")
+	}
+
+	sealer, err := sealed.NewSealer(keys)
+	if err != nil {
+		log.Fatalf("failed to configure sealed sender: %v", err)
+	}
+	return sealer
+}
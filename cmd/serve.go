@@ -6,7 +6,9 @@ import (
 	"os"
 	"os/signal"
 	"pipe/internal/api"
+	"pipe/internal/blob"
 	"pipe/internal/bot"
+	"pipe/internal/bus"
 	"pipe/internal/config"
 	"pipe/internal/repository"
 	"pipe/internal/repository/cassandra"
@@ -15,9 +17,27 @@ import (
 	"time"
 )
 
+// Server roles. The bot role runs only the Telegram bot, the api role only
+// the web API, so API instances never need the bot token.
+const (
+	roleAll = "all"
+	roleAPI = "api"
+	roleBot = "bot"
+)
+
 func Serve() {
 	config.LoadConfig()
 
+	role := config.AppConfig.ServerRole
+	switch role {
+	case roleAll, roleAPI, roleBot:
+	default:
+		log.Fatalf("unknown SERVER_ROLE %q, use %q, %q or %q", role, roleAll, roleAPI, roleBot)
+	}
+	if role != roleAll && config.AppConfig.EventBus == "local" {
+		log.Fatalf("SERVER_ROLE=%s needs EVENT_BUS=redis to reach the other role", role)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
@@ -31,34 +51,145 @@ func Serve() {
 		log.Fatalf("failed connect to redis: %v", err)
 	}
 
+	eventBus, err := bus.New(ctx, config.AppConfig.EventBus, redisClient)
+	if err != nil {
+		log.Fatalf("failed to configure event bus: %v", err)
+	}
+
+	sealer := newSealer()
+
+	blobStore, err := blob.New(config.AppConfig.BlobStore, config.AppConfig.BlobDir)
+	if err != nil {
+		log.Fatalf("failed to configure blob store: %v", err)
+	}
+
+	if config.AppConfig.PowEnabled && config.AppConfig.PowSecret == "" {
+		log.Fatal("POW_SECRET is required when POW_ENABLED is set")
+	}
+
 	accountRepository := repository.NewAccountCassandraRepository(cassandraSession)
 	messageRepository := repository.NewMessageCassandraRepository(cassandraSession)
-	redisRepository := repository.NewRedisRepository(redisClient)
+	redisRepository := repository.NewRedisRepository(
+		redisClient,
+		config.AppConfig.EventStreamMaxLen,
+		config.AppConfig.EventStreamTTL,
+		config.AppConfig.EventStreamDevices,
+		config.AppConfig.EventStreamIdle,
+	)
+	authRepository := repository.NewAuthRedisRepository(redisClient)
+
+	attachmentService := services.NewAttachmentService(services.AttachmentRepositories{
+		Attachments: messageRepository,
+		Messages:    messageRepository,
+		Quota:       redisRepository,
+	}, blobStore, sealer, services.AttachmentOptions{
+		MaxSize:    config.AppConfig.MaxAttachmentSize,
+		Quota:      config.AppConfig.AttachmentQuota,
+		UploadTTL:  config.AppConfig.AttachmentTTL,
+		GCInterval: config.AppConfig.AttachmentGC,
+	})
+	go attachmentService.Run(ctx)
 
 	app := services.NewApp(
-		services.NewAccountService(accountRepository),
-		services.NewMessageService(messageRepository, redisRepository),
+		services.NewAccountService(accountRepository, services.AccountOptions{
+			PrivateIDLength:      config.AppConfig.PrivateIDLength,
+			PrivateIDAlphabet:    config.AppConfig.PrivateIDAlphabet,
+			PrivateIDGracePeriod: config.AppConfig.PrivateIDGrace,
+			Vanity: services.VanityOptions{
+				MinLength: config.AppConfig.VanityMinLength,
+				MaxLength: config.AppConfig.VanityMaxLength,
+				Alphabet:  config.AppConfig.VanityAlphabet,
+				Reserved:  config.AppConfig.VanityReserved,
+				Blocked:   config.AppConfig.VanityBlocked,
+				Cooldown:  config.AppConfig.VanityCooldown,
+			},
+		}),
+		services.NewMessageService(
+			services.MessageRepositories{
+				Messages:   messageRepository,
+				Blocks:     messageRepository,
+				Moderation: messageRepository,
+				Events:     redisRepository,
+				Unread:     redisRepository,
+			},
+			eventBus,
+			sealer,
+			config.AppConfig.MessageTTL,
+			config.AppConfig.MaxMessageSize,
+			config.AppConfig.LegacyUntil,
+			attachmentService,
+		),
+		services.NewAuthService(
+			authRepository,
+			config.AppConfig.SessionSecret,
+			config.AppConfig.AccessTokenTTL,
+			config.AppConfig.RefreshTokenTTL,
+			config.AppConfig.PremiumTTL,
+		),
+		services.NewRateLimitService(redisRepository, sealer, services.RateLimitOptions{
+			Window:    config.AppConfig.RateLimitWindow,
+			Sender:    config.AppConfig.RateLimitSender,
+			IP:        config.AppConfig.RateLimitIP,
+			Recipient: config.AppConfig.RateLimitRecipient,
+			Pair:      config.AppConfig.RateLimitPair,
+			Reporter:  config.AppConfig.RateLimitReport,
+			Uploader:  config.AppConfig.RateLimitUpload,
+		}),
+		services.NewChallengeService(redisRepository, services.ChallengeOptions{
+			Enabled:        config.AppConfig.PowEnabled,
+			Secret:         config.AppConfig.PowSecret,
+			BaseDifficulty: config.AppConfig.PowDifficulty,
+			MaxDifficulty:  config.AppConfig.PowMaxDifficulty,
+			TTL:            config.AppConfig.PowChallengeTTL,
+			VolumeWindow:   config.AppConfig.PowVolumeWindow,
+			VolumeStep:     config.AppConfig.PowVolumeStep,
+		}),
+		attachmentService,
 	)
 
-	tg, err := bot.NewTelegram(ctx, app)
-	if err != nil {
-		log.Fatal("couldn't connect to the telegram server")
-	}
+	if role != roleBot {
+		validator, err := api.NewInitDataValidator(
+			config.AppConfig.InitDataValidator,
+			config.AppConfig.Token,
+			config.AppConfig.BotID,
+			config.AppConfig.TelegramPublicKey,
+		)
+		if err != nil {
+			log.Fatalf("failed to configure init data validation: %v", err)
+		}
 
-	go tg.Start()
+		wa := api.NewWebApp(config.AppConfig.ServerAddr, app, validator)
 
-	wa := api.NewWebApp(config.AppConfig.ServerAddr, app, tg.Bot)
+		go func() {
+			log.Fatal(wa.Start())
+		}()
 
-	go func() {
-		log.Fatal(wa.Start())
-	}()
+		// Runs after the web app stops taking requests, so no new
+		// background reads start while they are drained.
+		defer func() {
+			readsCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := app.Message.Shutdown(readsCtx); err != nil {
+				log.Printf("failed to finish marking messages read: %v", err)
+			}
+		}()
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	defer wa.Shutdown(shutdownCtx)
-	defer tg.Shutdown()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		defer wa.Shutdown(shutdownCtx)
+	}
+
+	if role != roleAPI {
+		tg, err := bot.NewTelegram(ctx, app, eventBus)
+		if err != nil {
+			log.Fatal("couldn't connect to the telegram server")
+		}
+
+		go tg.Start()
+		defer tg.Shutdown()
+	}
 
-	log.Println("server is up and running")
+	log.Printf("server is up and running as %s\n", role)
 	<-ctx.Done()
 	log.Println("shutting down the server...")
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"pipe/internal/services"
+	"strconv"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// uploadAttachment stores the request body, an encrypted blob, as an
+// attachment the user can send with their next message.
+func (w *WebApp) uploadAttachment(c echo.Context) error {
+	log.Printf("Handling uploadAttachment request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	attachment, err := w.App.Attachment.Upload(c.Request().Context(), authUser.ID, c.Request().Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAttachmentTooLarge):
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]any{
+				"error": "Attachment is too large",
+			})
+		case errors.Is(err, services.ErrAttachmentEmpty):
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Attachment can't be empty",
+			})
+		case errors.Is(err, services.ErrAttachmentQuota):
+			return c.JSON(http.StatusInsufficientStorage, map[string]any{
+				"error": "Attachment quota is used up",
+			})
+		}
+		log.Printf("Failed to upload attachment, Error: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to upload attachment",
+		})
+	}
+
+	log.Printf("Attachment uploaded (%d bytes)\n", attachment.Size)
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+func (w *WebApp) getAttachment(c echo.Context) error {
+	log.Printf("Handling getAttachment request from URI: %s\n", c.Request().RequestURI)
+
+	attachmentID, err := gocql.ParseUUID(c.Param("id"))
+	if err != nil {
+		log.Printf("Invalid attachment ID '%s'\n", c.Param("id"))
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	attachment, r, err := w.App.Attachment.Open(c.Request().Context(), authUser.ID, attachmentID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "Attachment not found",
+			})
+		}
+		log.Printf("Failed to open attachment %s, Error: %v\n", attachmentID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get attachment",
+		})
+	}
+	defer r.Close()
+
+	c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(attachment.Size, 10))
+	return c.Stream(http.StatusOK, echo.MIMEOctetStream, r)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"pipe/internal/config"
+	"pipe/internal/services"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// defaultReplayTTL is used for the replay cache when no max age is configured.
+const defaultReplayTTL = 24 * time.Hour
+
+// errReplayCacheUnavailable means the init data could not be checked against
+// the replay cache. It is a server failure, not a rejection of the client.
+var errReplayCacheUnavailable = errors.New("replay cache is unavailable")
+
+func initDataErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errInitDataExpired):
+		return "init_data_expired"
+	case errors.Is(err, errInitDataReplayed):
+		return "init_data_replayed"
+	case errors.Is(err, errInitDataInvalid):
+		return "init_data_invalid"
+	default:
+		return "init_data_malformed"
+	}
+}
+
+func sessionErrorCode(err error) string {
+	switch {
+	case errors.Is(err, services.ErrSessionExpired):
+		return "session_expired"
+	case errors.Is(err, services.ErrSessionRevoked):
+		return "session_revoked"
+	case errors.Is(err, services.ErrSessionsDisabled):
+		return "session_disabled"
+	default:
+		return "session_invalid"
+	}
+}
+
+// withAuth accepts either Telegram init data ("tma") or a session access token ("Bearer").
+func (w *WebApp) withAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return w.authenticate(next, true)
+}
+
+// withInitDataAuth accepts only Telegram init data.
+func (w *WebApp) withInitDataAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return w.authenticate(next, false)
+}
+
+func (w *WebApp) authenticate(next echo.HandlerFunc, allowBearer bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authorization := c.Request().Header.Get("Authorization")
+
+		if authorization == "" {
+			log.Println("Authorization header is missing")
+			return c.JSON(http.StatusUnauthorized, map[string]any{
+				"error": "Authorization required",
+			})
+		}
+
+		authScheme := strings.Split(authorization, " ")
+
+		if len(authScheme) != 2 {
+			log.Println("Invalid authorization scheme format")
+			return c.JSON(http.StatusUnauthorized, map[string]any{
+				"error": "Authorization scheme is not valid",
+			})
+		}
+
+		switch {
+		case authScheme[0] == "tma":
+			return w.authenticateInitData(c, next, authScheme[1])
+		case authScheme[0] == "Bearer" && allowBearer:
+			return w.authenticateAccessToken(c, next, authScheme[1])
+		default:
+			log.Println("Invalid authorization scheme")
+			return c.JSON(http.StatusUnauthorized, map[string]any{
+				"error": "Authorization scheme is not valid",
+			})
+		}
+	}
+}
+
+func (w *WebApp) authenticateInitData(c echo.Context, next echo.HandlerFunc, initData string) error {
+	parsed, err := w.validateInitData(initData)
+	if err == nil && config.AppConfig.InitDataSingleUse {
+		err = w.claimInitData(c, replayKey(parsed))
+		if errors.Is(err, errReplayCacheUnavailable) {
+			log.Printf("Authorization failed with error: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to verify authorization",
+			})
+		}
+	}
+	if err != nil {
+		log.Printf("Authorization failed with error: %v\n", err)
+		return c.JSON(http.StatusUnauthorized, map[string]any{
+			"code":  initDataErrorCode(err),
+			"error": "Authorization failed",
+		})
+	}
+
+	var user telebot.User
+	if err := json.Unmarshal([]byte(parsed.Get("user")), &user); err != nil || user.ID == 0 {
+		log.Println("Error unmarshalling user data from init data")
+		return c.JSON(http.StatusUnauthorized, map[string]any{
+			"code":  initDataErrorCode(errInitDataMalformed),
+			"error": "Authorization failed",
+		})
+	}
+
+	c.Set("user", user)
+	log.Println("User authenticated successfully")
+
+	return next(c)
+}
+
+func (w *WebApp) authenticateAccessToken(c echo.Context, next echo.HandlerFunc, accessToken string) error {
+	session, err := w.App.Auth.VerifyAccessToken(c.Request().Context(), accessToken)
+	if err != nil {
+		log.Printf("Authorization failed with error: %v\n", err)
+		return c.JSON(http.StatusUnauthorized, map[string]any{
+			"code":  sessionErrorCode(err),
+			"error": "Authorization failed",
+		})
+	}
+
+	c.Set("session", session)
+	c.Set("user", telebot.User{
+		ID:        session.UserID,
+		Username:  session.Username,
+		FirstName: session.FirstName,
+		IsPremium: session.IsPremium,
+	})
+	log.Println("Session authenticated successfully")
+
+	return next(c)
+}
+
+// replayKey identifies init data in the replay cache by whichever signature it carries.
+func replayKey(initData url.Values) string {
+	if hash := initData.Get("hash"); hash != "" {
+		return hash
+	}
+	return initData.Get("signature")
+}
+
+// claimInitData rejects init data whose hash was already seen by the replay cache.
+func (w *WebApp) claimInitData(c echo.Context, hash string) error {
+	ttl := config.AppConfig.InitDataMaxAge
+	if ttl <= 0 {
+		ttl = defaultReplayTTL
+	}
+
+	fresh, err := w.App.Auth.ClaimInitData(c.Request().Context(), hash, ttl)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errReplayCacheUnavailable, err)
+	}
+	if !fresh {
+		return errInitDataReplayed
+	}
+	return nil
+}
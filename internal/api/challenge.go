@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"pipe/internal/entity"
+	"pipe/internal/services"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// challengeErrorCode returns the code for a rejected proof of work, or an
+// empty string if err isn't a rejection.
+func challengeErrorCode(err error) string {
+	switch {
+	case errors.Is(err, services.ErrChallengeRequired):
+		return "challenge_required"
+	case errors.Is(err, services.ErrChallengeInvalid):
+		return "challenge_invalid"
+	case errors.Is(err, services.ErrChallengeExpired):
+		return "challenge_expired"
+	case errors.Is(err, services.ErrChallengeUsed):
+		return "challenge_used"
+	case errors.Is(err, services.ErrSolutionInvalid):
+		return "solution_invalid"
+	default:
+		return ""
+	}
+}
+
+// getChallenge issues a proof of work challenge for messaging the owner of
+// the "to" private ID, or on the reply routes for replying to the other side
+// of the thread.
+func (w *WebApp) getChallenge(c echo.Context) error {
+	log.Printf("Handling getChallenge request for route: %s\n", c.Path())
+
+	u, ok := c.Get("recipient").(entity.User)
+	if !ok {
+		privateID := c.QueryParam("to")
+		if privateID == "" {
+			log.Println("Private ID is missing in request")
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Private ID can't be empty",
+			})
+		}
+
+		var err error
+		u, err = w.App.Account.GetUserByPrivateID(privateID)
+		if err != nil {
+			if err == gocql.ErrNotFound {
+				log.Printf("User not found for PrivateID: %s\n", privateID)
+				return c.JSON(http.StatusNotFound, map[string]any{
+					"error": "User not found",
+				})
+			}
+			log.Printf("Failed to retrieve user for PrivateID: %s, Error: %v\n", privateID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to retrieve user",
+			})
+		}
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	challenge, err := w.App.Challenge.Issue(c.Request().Context(), authUser.ID, u.ID)
+	if err != nil {
+		log.Printf("Failed to issue challenge, Error: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to issue challenge",
+		})
+	}
+
+	return c.JSON(http.StatusOK, challenge)
+}
+
+// withProofOfWork requires a solved challenge from getChallenge in the
+// X-Pow-Challenge and X-Pow-Solution headers when proof of work is enabled.
+// It runs after withSendLimit, so rate limited senders don't use their
+// challenge up, and counts the message towards the recipient's volume once
+// the handler stored it.
+func (w *WebApp) withProofOfWork(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !w.App.Challenge.Enabled() {
+			return next(c)
+		}
+
+		recipient, err := w.recipient(c)
+		if err != nil {
+			return next(c)
+		}
+
+		authUser := c.Get("user").(telebot.User)
+
+		err = w.App.Challenge.Verify(
+			c.Request().Context(),
+			c.Request().Header.Get("X-Pow-Challenge"),
+			c.Request().Header.Get("X-Pow-Solution"),
+			authUser.ID,
+			recipient.ID,
+		)
+		if err != nil {
+			if code := challengeErrorCode(err); code != "" {
+				log.Printf("Proof of work rejected, Error: %v\n", err)
+				return c.JSON(http.StatusForbidden, map[string]any{
+					"code":  code,
+					"error": "Proof of work failed",
+				})
+			}
+			log.Printf("Failed to verify proof of work, Error: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to verify proof of work",
+			})
+		}
+
+		if err := next(c); err != nil {
+			return err
+		}
+		if c.Response().Status != http.StatusOK {
+			return nil
+		}
+		if err := w.App.Challenge.CountMessage(c.Request().Context(), recipient.ID); err != nil {
+			log.Printf("Failed to count message towards inbox volume, Error: %v\n", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/internal/services"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// countedChallenges is a repository.Challenge that counts hits and lets
+// every challenge be claimed.
+type countedChallenges struct {
+	hits int
+}
+
+func (c *countedChallenges) Hit(context.Context, []repository.RateLimit, time.Duration) (time.Duration, error) {
+	c.hits++
+	return 0, nil
+}
+
+func (c *countedChallenges) Hits(context.Context, string, time.Duration) (int64, error) {
+	return int64(c.hits), nil
+}
+
+func (c *countedChallenges) ClaimChallenge(context.Context, string, time.Time) (bool, error) {
+	return true, nil
+}
+
+func TestProofOfWorkCountsStoredMessages(t *testing.T) {
+	repo := &countedChallenges{}
+	challenges := services.NewChallengeService(repo, services.ChallengeOptions{Enabled: true, Secret: "pow-secret"})
+	w := &WebApp{App: &services.App{Challenge: challenges}}
+
+	for _, tt := range []struct {
+		name   string
+		status int
+		hits   int
+	}{
+		{"stored", http.StatusOK, 1},
+		{"rejected", http.StatusForbidden, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// With no difficulty any solution does.
+			challenge, err := challenges.Issue(context.Background(), 1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("X-Pow-Challenge", challenge.Challenge)
+			req.Header.Set("X-Pow-Solution", "0")
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.Set("user", telebot.User{ID: 1})
+			c.Set("recipient", entity.User{ID: 2})
+
+			handler := w.withProofOfWork(func(c echo.Context) error {
+				return c.NoContent(tt.status)
+			})
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if repo.hits != tt.hits {
+				t.Fatalf("inbox volume = %d, want %d", repo.hits, tt.hits)
+			}
+		})
+	}
+}
@@ -1,20 +1,15 @@
 package api
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	_ "embed"
-	"encoding/hex"
-	"encoding/json"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
-	"pipe/internal/config"
 	"pipe/internal/entity"
-	"pipe/pkg/utils"
-	"sort"
+	"pipe/internal/repository"
+	"pipe/internal/services"
 	"strconv"
 	"strings"
 	"time"
@@ -42,8 +37,13 @@ func (w *WebApp) getMe(c echo.Context) error {
 	if err != nil {
 		if err == gocql.ErrNotFound {
 			log.Printf("User not found for ID: %d. Creating new user.\n", authUser.ID)
-			newUser := entity.User{ID: authUser.ID, PrivateID: utils.GenerateRandomPrivateID(), CreatedAt: time.Now()}
-			err = w.App.Account.CreateUser(newUser)
+			newUser, err := w.App.Account.CreateUser(entity.User{ID: authUser.ID, CreatedAt: time.Now()})
+			if errors.Is(err, repository.ErrUserExists) {
+				log.Printf("User was created concurrently for ID: %d\n", authUser.ID)
+				if u, err = w.App.Account.GetUserByID(authUser.ID); err == nil {
+					return c.JSON(http.StatusOK, u)
+				}
+			}
 			if err != nil {
 				log.Printf("Error creating new user for ID: %d, Error: %v\n", authUser.ID, err)
 				return c.JSON(http.StatusInternalServerError, map[string]any{
@@ -59,12 +59,16 @@ func (w *WebApp) getMe(c echo.Context) error {
 		})
 	}
 
+	if u.Unread, err = w.App.Message.UnreadCount(c.Request().Context(), u.ID); err != nil {
+		log.Printf("Failed to count unread messages for ID: %d, Error: %v\n", u.ID, err)
+	}
+
 	log.Printf("User retrieved successfully for ID: %d\n", authUser.ID)
 	return c.JSON(http.StatusOK, u)
 }
 
 func (w *WebApp) getUser(c echo.Context) error {
-	log.Printf("Handling getUser request from URI: %s\n", c.Request().RequestURI)
+	log.Printf("Handling getUser request for route: %s\n", c.Path())
 
 	privateID := c.Param("privateID")
 
@@ -89,17 +93,69 @@ func (w *WebApp) getUser(c echo.Context) error {
 		})
 	}
 
+	authUser := c.Get("user").(telebot.User)
+
+	reason, err := w.App.Account.InboxClosed(u, authUser.ID, authUser.IsPremium)
+	if err != nil {
+		log.Printf("Failed to check inbox of PrivateID: %s, Error: %v\n", privateID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
 	log.Printf("User retrieved successfully for PrivateID: %s\n", privateID)
-	outUser := entity.User{PrivateID: u.PrivateID, PubKey: u.PubKey}
+	outUser := entity.User{PrivateID: u.PrivateID, PubKey: u.PubKey, InboxStatus: entity.InboxStatusOpen}
+	if reason != "" {
+		outUser.InboxStatus, outUser.InboxReason = entity.InboxStatusClosed, reason
+	}
 	return c.JSON(http.StatusOK, outUser)
 }
 
+// maxMessagesLimit is both the default and the largest page size of getMessages.
+const maxMessagesLimit = 100
+
+// pageParams parses the limit and cursor query parameters of the paged
+// endpoints. limit defaults to maxMessagesLimit and paginated reports whether
+// either parameter was given.
+func pageParams(c echo.Context) (limit int, cursor []byte, paginated bool, err error) {
+	limitStr := c.QueryParam("limit")
+	cursorStr := c.QueryParam("cursor")
+	paginated = limitStr != "" || cursorStr != ""
+
+	limit = maxMessagesLimit
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 || n > maxMessagesLimit {
+			log.Printf("Invalid limit value '%s'\n", limitStr)
+			return 0, nil, false, fmt.Errorf("Limit must be between 1 and %d", maxMessagesLimit)
+		}
+		limit = n
+	}
+
+	cursor, err = base64.RawURLEncoding.DecodeString(cursorStr)
+	if err != nil {
+		log.Printf("Invalid cursor value '%s'\n", cursorStr)
+		return 0, nil, false, errors.New("Invalid cursor")
+	}
+	return limit, cursor, paginated, nil
+}
+
+// getMessages returns the inbox newest first. Without limit and cursor it
+// keeps the original response of a plain array holding the first page;
+// otherwise it returns a MessagePage whose next_cursor fetches the next page.
 func (w *WebApp) getMessages(c echo.Context) error {
 	log.Printf("Handling getMessages request from URI: %s\n", c.Request().RequestURI)
 
+	limit, cursor, paginated, err := pageParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+	}
+
 	authUser := c.Get("user").(telebot.User)
 
-	messages, err := w.App.Message.GetUserMessages(authUser.ID)
+	messages, next, err := w.App.Message.GetUserMessages(authUser.ID, limit, cursor)
 	if err != nil {
 		log.Printf("Failed to retrieve messages for UserID: %d, Error: %v\n", authUser.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
@@ -108,11 +164,18 @@ func (w *WebApp) getMessages(c echo.Context) error {
 	}
 
 	log.Printf("Messages retrieved successfully for UserID: %d\n", authUser.ID)
-	return c.JSON(http.StatusOK, messages)
+	if !paginated {
+		return c.JSON(http.StatusOK, messages)
+	}
+
+	return c.JSON(http.StatusOK, entity.MessagePage{
+		Messages:   messages,
+		NextCursor: base64.RawURLEncoding.EncodeToString(next),
+	})
 }
 
 func (w *WebApp) sendMessage(c echo.Context) error {
-	log.Printf("Handling sendMessage request from URI: %s\n", c.Request().RequestURI)
+	log.Printf("Handling sendMessage request for route: %s\n", c.Path())
 
 	privateID := c.Param("privateID")
 	if privateID == "" {
@@ -154,319 +217,1186 @@ func (w *WebApp) sendMessage(c echo.Context) error {
 		})
 	}
 
-	message := entity.Message{
-		ID:       gocql.TimeUUID(),
-		FromUser: authUser.ID,
-		ToUser:   u.ID,
-		Text:     messageContent,
-		Date:     time.Now().Unix(),
-	}
-
-	if err := w.App.Message.Send(message); err != nil {
-		log.Printf("Failed to send message from UserID: %d to UserID: %d, Error: %v\n", authUser.ID, u.ID, err)
+	reason, err := w.App.Account.InboxClosed(u, authUser.ID, authUser.IsPremium)
+	if err != nil {
+		log.Printf("Failed to check inbox of UserID: %d, Error: %v\n", u.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
 			"error": "Failed to send message",
 		})
 	}
+	if reason != "" {
+		log.Printf("Inbox of UserID: %d is closed to the sender (%s)\n", u.ID, reason)
+		return c.JSON(http.StatusForbidden, map[string]any{
+			"code":   "inbox_closed",
+			"reason": reason,
+			"error":  "This inbox isn't accepting your messages",
+		})
+	}
 
-	outMessage := entity.Message{
-		ID:   message.ID,
-		Text: message.Text,
-		Date: message.Date,
+	message := entity.Message{
+		ID:          gocql.TimeUUID(),
+		ToUser:      u.ID,
+		Text:        messageContent,
+		Attachments: text.Attachments,
+		Date:        time.Now().Unix(),
+		Alias:       u.AliasLabel,
 	}
 
-	messageJSON, err := json.Marshal(outMessage)
+	message, err = w.App.Message.Send(c.Request().Context(), message, authUser.ID, u)
 	if err != nil {
-		log.Printf("Failed to serialize message to JSON, Error: %v\n", err)
+		if errors.Is(err, services.ErrAttachmentInvalid) || errors.Is(err, services.ErrTooManyAttachments) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, services.ErrMessageTooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]any{
+				"error": "Message is too large",
+			})
+		}
+		if errors.Is(err, services.ErrEnvelopeInvalid) {
+			log.Println("Rejected message that isn't a ciphertext envelope")
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"code":  "envelope_invalid",
+				"error": "Message must be an encrypted envelope",
+			})
+		}
+		if errors.Is(err, services.ErrSenderBanned) {
+			log.Println("Banned sender tried to send a message")
+			return c.JSON(http.StatusForbidden, map[string]any{
+				"error": "You are banned from sending messages",
+			})
+		}
+		if errors.Is(err, services.ErrSenderBlocked) {
+			log.Printf("Rejected message from a sender blocked by UserID: %d\n", u.ID)
+			return c.JSON(http.StatusForbidden, map[string]any{
+				"error": "You can't message this user",
+			})
+		}
+		log.Printf("Failed to send message to UserID: %d, Error: %v\n", u.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
-			"error": "Failed to serialize message",
+			"error": "Failed to send message",
 		})
 	}
 
-	log.Printf("Serialized message JSON: %s\n", string(messageJSON))
+	outMessage := entity.Message{
+		ID:              message.ID,
+		Text:            message.Text,
+		EnvelopeVersion: message.EnvelopeVersion,
+		Attachments:     message.Attachments,
+		Date:            message.Date,
+		Alias:           message.Alias,
+		ExpiresAt:       message.ExpiresAt,
+		Muted:           message.Muted,
+	}
 
-	if err := w.App.Message.AddToRedis(c.Request().Context(), u.ID, string(messageJSON)); err != nil {
+	if err := w.App.Message.DeliverMessage(c.Request().Context(), u.ID, outMessage); err != nil {
 		log.Printf("Failed to add message to Redis, Error: %v\n", err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
 			"error": "Failed to send message",
 		})
 	}
 
-	log.Printf("Message sent successfully from UserID: %d to UserID: %d\n", authUser.ID, u.ID)
-
-	_, err = w.bot.Send(&telebot.Chat{ID: u.ID}, "یه پیام جدید داری 🍕", &telebot.ReplyMarkup{
-		InlineKeyboard: [][]telebot.InlineButton{
-			{
-				{
-					Text:   "Open",
-					WebApp: &telebot.WebApp{URL: config.AppConfig.ClientURL},
-				},
-			},
-		},
-	})
-
-	if err != nil {
-		log.Printf("Failed to send notification to UserID: %d, Error: %v\n", u.ID, err)
-	}
+	log.Printf("Message sent successfully to UserID: %d\n", u.ID)
 
+	// The sender keeps message_id to match replies, which refer to it through in_reply_to.
 	return c.JSON(http.StatusOK, map[string]any{
-		"status": "Message sent",
+		"status":     "Message sent",
+		"message_id": message.ID,
 	})
 }
 
-func (w *WebApp) deleteAccount(c echo.Context) error {
-	log.Printf("Handling deleteAccount request from URI: %s\n", c.Request().RequestURI)
+func (w *WebApp) deleteMessage(c echo.Context) error {
+	log.Printf("Handling deleteMessage request from URI: %s\n", c.Request().RequestURI)
+
+	messageID, err := gocql.ParseUUID(c.Param("id"))
+	if err != nil {
+		log.Printf("Invalid message ID '%s'\n", c.Param("id"))
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid message ID",
+		})
+	}
 
 	authUser := c.Get("user").(telebot.User)
 
-	u, err := w.App.Account.GetUserByID(authUser.ID)
-	if err != nil {
+	if err := w.App.Message.Delete(c.Request().Context(), authUser.ID, messageID); err != nil {
 		if err == gocql.ErrNotFound {
-			log.Printf("User not found for ID: %d\n", authUser.ID)
+			log.Printf("Message %s not found for UserID: %d\n", messageID, authUser.ID)
 			return c.JSON(http.StatusNotFound, map[string]any{
-				"error": "User not found",
+				"error": "Message not found",
 			})
 		}
-		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		log.Printf("Failed to delete message %s for UserID: %d, Error: %v\n", messageID, authUser.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
-			"error": "Failed to get user",
+			"error": "Failed to delete message",
 		})
 	}
 
-	if err := w.App.Account.DeleteUser(u); err != nil {
-		log.Printf("Failed to delete user for ID: %d, Error: %v\n", u.ID, err)
+	log.Printf("Message %s deleted successfully for UserID: %d\n", messageID, authUser.ID)
+	return c.JSON(http.StatusOK, map[string]any{
+		"status": "ok",
+	})
+}
+
+func (w *WebApp) deleteMessages(c echo.Context) error {
+	log.Printf("Handling deleteMessages request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	if err := w.App.Message.DeleteAll(c.Request().Context(), authUser.ID); err != nil {
+		log.Printf("Failed to delete messages for UserID: %d, Error: %v\n", authUser.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
-			"error": "Failed to delete user",
+			"error": "Failed to delete messages",
 		})
 	}
 
-	log.Printf("User deleted successfully for ID: %d\n", authUser.ID)
-	_, err = w.bot.Send(&telebot.Chat{ID: authUser.ID}, "حساب کاربری شما با موفقیت حذف شد. توجه داشته باشید که اگر دوباره وارد مینی اپ شوید حساب کاربری جدیدی برای شما ساخته می شود.")
-	if err != nil {
-		log.Printf("Failed to send account deletion notification to UserID: %d, Error: %v\n", authUser.ID, err)
-	}
-
+	log.Printf("Messages deleted successfully for UserID: %d\n", authUser.ID)
 	return c.JSON(http.StatusOK, map[string]any{
 		"status": "ok",
 	})
 }
 
-func (w *WebApp) setPubKey(c echo.Context) error {
-	log.Printf("Handling setPupKey request from URI: %s\n", c.Request().RequestURI)
+func (w *WebApp) readMessage(c echo.Context) error {
+	log.Printf("Handling readMessage request from URI: %s\n", c.Request().RequestURI)
 
-	var pubkey entity.PubKey
-	err := c.Bind(&pubkey)
+	messageID, err := gocql.ParseUUID(c.Param("id"))
 	if err != nil {
-		log.Println("Failed to bind request body to PubKey entity")
-		return c.JSON(http.StatusBadRequest, map[string]any{
-			"error": "PubKey can't be empty",
-		})
-	}
-
-	if len(strings.TrimSpace(pubkey.Value)) == 0 {
+		log.Printf("Invalid message ID '%s'\n", c.Param("id"))
 		return c.JSON(http.StatusBadRequest, map[string]any{
-			"error": "PubKey can't be empty",
+			"error": "Invalid message ID",
 		})
 	}
 
 	authUser := c.Get("user").(telebot.User)
 
-	u, err := w.App.Account.GetUserByID(authUser.ID)
-	if err != nil {
+	if err := w.App.Message.MarkRead(c.Request().Context(), authUser.ID, messageID); err != nil {
 		if err == gocql.ErrNotFound {
-			log.Printf("User not found for ID: %d\n", authUser.ID)
+			log.Printf("Message %s not found for UserID: %d\n", messageID, authUser.ID)
 			return c.JSON(http.StatusNotFound, map[string]any{
-				"error": "User not found",
+				"error": "Message not found",
 			})
 		}
-		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		log.Printf("Failed to mark message %s read for UserID: %d, Error: %v\n", messageID, authUser.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
-			"error": "Failed to get user",
+			"error": "Failed to mark message read",
 		})
 	}
 
-	u.PubKey = pubkey.Value
+	return w.unreadResponse(c, authUser.ID)
+}
 
-	if err := w.App.Account.SetPubKey(u); err != nil {
-		log.Printf("Failed to update PubKey for UserID: %d, Error: %v\n", u.ID, err)
+func (w *WebApp) readMessages(c echo.Context) error {
+	log.Printf("Handling readMessages request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	marked, err := w.App.Message.MarkAllRead(c.Request().Context(), authUser.ID)
+	if err != nil {
+		log.Printf("Failed to mark messages read for UserID: %d, Error: %v\n", authUser.ID, err)
 		return c.JSON(http.StatusInternalServerError, map[string]any{
-			"error": "Failed to update PubKey",
+			"error": "Failed to mark messages read",
 		})
 	}
 
-	log.Printf("PubKey updated successfully for UserID: %d\n", authUser.ID)
+	log.Printf("%d messages marked read for UserID: %d\n", marked, authUser.ID)
+	return w.unreadResponse(c, authUser.ID)
+}
+
+// unreadResponse reports the user's unread count after a change to it.
+func (w *WebApp) unreadResponse(c echo.Context, userID int64) error {
+	unread, err := w.App.Message.UnreadCount(c.Request().Context(), userID)
+	if err != nil {
+		log.Printf("Failed to count unread messages for UserID: %d, Error: %v\n", userID, err)
+	}
+
 	return c.JSON(http.StatusOK, map[string]any{
 		"status": "ok",
+		"unread": unread,
 	})
 }
 
-func (w *WebApp) getUpdates(c echo.Context) error {
-	log.Printf("Handling getUpdates request from URI: %s\n", c.Request().RequestURI)
-
-	timeoutStr := c.QueryParam("timeout")
-	if timeoutStr == "" {
-		timeoutStr = "0.0"
-	}
+// getOutbox returns the messages the user sent, newest first.
+func (w *WebApp) getOutbox(c echo.Context) error {
+	log.Printf("Handling getOutbox request from URI: %s\n", c.Request().RequestURI)
 
-	timeout, err := strconv.ParseFloat(timeoutStr, 64)
+	limit, cursor, _, err := pageParams(c)
 	if err != nil {
-		log.Printf("Error parsing timeout value '%s': %v\n", timeoutStr, err)
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid timeout value")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
 	}
 
-	log.Printf("Timeout set to %.2f seconds\n", timeout)
-
 	authUser := c.Get("user").(telebot.User)
 
-	messagesJSON, err := w.App.Message.GetRedisMessages(c.Request().Context(), authUser.ID, 0, -1)
+	sent, next, err := w.App.Message.GetSentMessages(authUser.ID, limit, cursor)
 	if err != nil {
-		log.Printf("Error retrieving messages for user ID %d: %v\n", authUser.ID, err)
-		if errors.Is(err, rueidis.Nil) {
-			return c.JSON(http.StatusNoContent, map[string]any{"error": "No messages available"})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to retrieve messages"})
+		log.Printf("Failed to retrieve sent messages for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get sent messages",
+		})
 	}
 
-	if len(messagesJSON) > 0 {
-		messages, err := deserializeMessages(messagesJSON[1:])
-		if err != nil {
-			log.Printf("Error deserializing messages: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to deserialize messages"})
-		}
-		log.Printf("Retrieved %d messages for user ID %d\n", len(messages), authUser.ID)
-		return c.JSON(http.StatusOK, messages)
-	}
+	log.Printf("Sent messages retrieved successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, entity.SentMessagePage{
+		Messages:   sent,
+		NextCursor: base64.RawURLEncoding.EncodeToString(next),
+	})
+}
 
-	log.Println("No messages in Redis. Waiting for new messages...")
-	newMessagesJSON, err := w.App.Message.ListenForNewMessage(c.Request().Context(), authUser.ID, timeout)
+// getReplies returns the replies the user received to messages they sent,
+// newest first.
+func (w *WebApp) getReplies(c echo.Context) error {
+	log.Printf("Handling getReplies request from URI: %s\n", c.Request().RequestURI)
+
+	limit, cursor, _, err := pageParams(c)
 	if err != nil {
-		log.Printf("Error retrieving new messages for user ID %d: %v\n", authUser.ID, err)
-		if errors.Is(err, rueidis.Nil) {
-			return c.JSON(http.StatusNoContent, map[string]any{"error": "No new messages"})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to retrieve new messages"})
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
 	}
 
-	if len(newMessagesJSON) > 0 {
-		newMessages, err := deserializeMessages(newMessagesJSON[1:])
-		if err != nil {
-			log.Printf("Error deserializing new messages: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to deserialize messages"})
-		}
-		log.Printf("Retrieved %d new messages for user ID %d\n", len(newMessages), authUser.ID)
-		return c.JSON(http.StatusOK, newMessages)
+	authUser := c.Get("user").(telebot.User)
+
+	replies, next, err := w.App.Message.GetUserReplies(authUser.ID, limit, cursor)
+	if err != nil {
+		log.Printf("Failed to retrieve replies for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get replies",
+		})
 	}
 
-	return c.JSON(http.StatusNoContent, map[string]any{"error": "No new messages"})
+	log.Printf("Replies retrieved successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, entity.MessagePage{
+		Messages:   replies,
+		NextCursor: base64.RawURLEncoding.EncodeToString(next),
+	})
 }
 
-func deserializeMessages(messagesJSON []string) ([]entity.Message, error) {
-	var messages []entity.Message
-	for _, msgJSON := range messagesJSON {
-		var msg entity.Message
-		if err := json.Unmarshal([]byte(msgJSON), &msg); err != nil {
-			return nil, err
+// withCounterpart resolves who sent the message (or, with fromSent, the
+// reply) in the "id" path parameter and stores them as the request's
+// recipient, so the middlewares guarding sendMessage also guard replies.
+func (w *WebApp) withCounterpart(fromSent bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			messageID, err := gocql.ParseUUID(c.Param("id"))
+			if err != nil {
+				log.Printf("Invalid message ID '%s'\n", c.Param("id"))
+				return c.JSON(http.StatusBadRequest, map[string]any{
+					"error": "Invalid message ID",
+				})
+			}
+
+			authUser := c.Get("user").(telebot.User)
+
+			var senderID int64
+			if fromSent {
+				senderID, err = w.App.Message.ReplySender(authUser.ID, messageID)
+			} else {
+				senderID, err = w.App.Message.MessageSender(authUser.ID, messageID)
+			}
+			if err != nil {
+				if err == gocql.ErrNotFound {
+					log.Printf("Message %s not found\n", messageID)
+					return c.JSON(http.StatusNotFound, map[string]any{
+						"error": "Message not found",
+					})
+				}
+				if errors.Is(err, services.ErrNotReplyable) {
+					return c.JSON(http.StatusConflict, map[string]any{
+						"error": "Message can't be replied to",
+					})
+				}
+				log.Printf("Failed to resolve sender of message %s, Error: %v\n", messageID, err)
+				return c.JSON(http.StatusInternalServerError, map[string]any{
+					"error": "Failed to resolve sender",
+				})
+			}
+
+			sender, err := w.App.Account.GetUserByID(senderID)
+			if err != nil {
+				if err == gocql.ErrNotFound {
+					return c.JSON(http.StatusGone, map[string]any{
+						"error": "Sender no longer exists",
+					})
+				}
+				log.Printf("Failed to retrieve sender of message %s, Error: %v\n", messageID, err)
+				return c.JSON(http.StatusInternalServerError, map[string]any{
+					"error": "Failed to resolve sender",
+				})
+			}
+
+			c.Set("messageID", messageID)
+			c.Set("recipient", sender)
+			return next(c)
 		}
-		messages = append(messages, msg)
 	}
-	return messages, nil
 }
 
-func (w *WebApp) withAuth(next echo.HandlerFunc) echo.HandlerFunc {
+// reply answers a message in the inbox, or with fromSent continues the
+// thread by answering a reply. Replies are encrypted to the reply key the
+// other side put inside the envelope it sent, never to their account key,
+// which would let the replier match them against getUser.
+func (w *WebApp) reply(fromSent bool) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		initData := c.Request().Header.Get("Authorization")
+		log.Printf("Handling reply request from URI: %s\n", c.Request().RequestURI)
 
-		if initData == "" {
-			log.Println("Authorization header is missing")
-			return c.JSON(http.StatusUnauthorized, map[string]any{
-				"error": "Authorization required",
+		var text entity.Text
+		if err := c.Bind(&text); err != nil {
+			log.Println("Failed to bind request body to Text entity")
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Message can't be empty",
 			})
 		}
 
-		authScheme := strings.Split(initData, " ")
-
-		if len(authScheme) != 2 {
-			log.Println("Invalid authorization scheme format")
-			return c.JSON(http.StatusUnauthorized, map[string]any{
-				"error": "Authorization scheme is not valid",
+		replyContent := strings.TrimSpace(text.Message)
+		if replyContent == "" {
+			log.Println("Received empty reply content")
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Message can't be empty",
 			})
 		}
 
-		if authScheme[0] != "tma" {
-			log.Println("Invalid authorization scheme")
-			return c.JSON(http.StatusUnauthorized, map[string]any{
-				"error": "Authorization scheme is not valid",
+		messageID := c.Get("messageID").(gocql.UUID)
+		sender := c.Get("recipient").(entity.User)
+		authUser := c.Get("user").(telebot.User)
+
+		var (
+			reply  entity.Message
+			reason string
+			err    error
+		)
+		if fromSent {
+			// Answering a reply lands in the other side's inbox, so their
+			// inbox settings apply as they do to sendMessage.
+			reason, err = w.App.Account.InboxClosed(sender, authUser.ID, authUser.IsPremium)
+			if err != nil {
+				log.Printf("Failed to check inbox of the counterpart of message %s, Error: %v\n", messageID, err)
+				return c.JSON(http.StatusInternalServerError, map[string]any{
+					"error": "Failed to send reply",
+				})
+			}
+			if reason != "" {
+				log.Printf("Inbox of the counterpart of message %s is closed to the replier (%s)\n", messageID, reason)
+				return c.JSON(http.StatusForbidden, map[string]any{
+					"code":   "inbox_closed",
+					"reason": reason,
+					"error":  "This inbox isn't accepting your messages",
+				})
+			}
+			reply, err = w.App.Message.ReplyToReply(c.Request().Context(), authUser.ID, messageID, replyContent, sender)
+		} else {
+			reply, err = w.App.Message.ReplyToMessage(c.Request().Context(), authUser.ID, messageID, replyContent, sender)
+		}
+		if err != nil {
+			if errors.Is(err, services.ErrMessageTooLarge) {
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]any{
+					"error": "Message is too large",
+				})
+			}
+			if errors.Is(err, services.ErrEnvelopeInvalid) {
+				return c.JSON(http.StatusBadRequest, map[string]any{
+					"code":  "envelope_invalid",
+					"error": "Message must be an encrypted envelope",
+				})
+			}
+			if errors.Is(err, services.ErrSenderBanned) {
+				return c.JSON(http.StatusForbidden, map[string]any{
+					"error": "You are banned from sending messages",
+				})
+			}
+			if errors.Is(err, services.ErrSenderBlocked) {
+				return c.JSON(http.StatusForbidden, map[string]any{
+					"error": "You can't message this user",
+				})
+			}
+			log.Printf("Failed to send reply to message %s, Error: %v\n", messageID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to send reply",
 			})
 		}
 
-		isValid, err := w.validateInitData(authScheme[1], config.AppConfig.Token)
+		log.Printf("Reply sent successfully to message %s\n", messageID)
+		return c.JSON(http.StatusOK, map[string]any{
+			"status":     "Reply sent",
+			"message_id": reply.ID,
+		})
+	}
+}
+
+// blockSender blocks or mutes the sender of a message in the inbox, or with
+// fromSent the sender of a reply.
+func (w *WebApp) blockSender(fromSent bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		log.Printf("Handling blockSender request from URI: %s\n", c.Request().RequestURI)
+
+		messageID, err := gocql.ParseUUID(c.Param("id"))
 		if err != nil {
-			log.Printf("Authorization failed with error: %v\n", err)
-			return c.JSON(http.StatusUnauthorized, map[string]any{
-				"status": isValid,
-				"error":  "Authorization failed",
+			log.Printf("Invalid message ID '%s'\n", c.Param("id"))
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Invalid message ID",
 			})
 		}
 
-		if !isValid {
-			log.Println("Authorization failed due to invalid data")
-			return c.JSON(http.StatusUnauthorized, map[string]any{
-				"status": isValid,
-				"error":  "Authorization failed",
+		var req entity.BlockRequest
+		if err := c.Bind(&req); err != nil {
+			log.Println("Failed to bind request body to BlockRequest entity")
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Invalid request body",
 			})
 		}
 
-		parsed, err := url.ParseQuery(initData)
+		authUser := c.Get("user").(telebot.User)
+
+		var block entity.Block
+		if fromSent {
+			block, err = w.App.Message.BlockReply(authUser.ID, messageID, req.Mode)
+		} else {
+			block, err = w.App.Message.Block(authUser.ID, messageID, req.Mode)
+		}
 		if err != nil {
-			log.Println("Failed to parse init data from authorization header")
-			return err
+			switch {
+			case err == gocql.ErrNotFound:
+				log.Printf("Message %s not found for UserID: %d\n", messageID, authUser.ID)
+				return c.JSON(http.StatusNotFound, map[string]any{
+					"error": "Message not found",
+				})
+			case errors.Is(err, services.ErrBlockModeInvalid):
+				return c.JSON(http.StatusBadRequest, map[string]any{
+					"error": "Mode must be block or mute",
+				})
+			case errors.Is(err, services.ErrNotBlockable):
+				return c.JSON(http.StatusConflict, map[string]any{
+					"error": "The sender of this message can't be blocked",
+				})
+			}
+			log.Printf("Failed to block sender of message %s for UserID: %d, Error: %v\n", messageID, authUser.ID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to block sender",
+			})
 		}
 
-		var user telebot.User
-		if err := json.Unmarshal([]byte(parsed.Get("user")), &user); err != nil {
-			log.Println("Error unmarshalling user data from init data")
-			return err
-		}
+		log.Printf("Sender of message %s blocked (%s) for UserID: %d\n", messageID, block.Mode, authUser.ID)
+		return c.JSON(http.StatusCreated, block)
+	}
+}
+
+func (w *WebApp) getBlocks(c echo.Context) error {
+	log.Printf("Handling getBlocks request from URI: %s\n", c.Request().RequestURI)
 
-		c.Set("user", user)
-		log.Printf("User authenticated successfully: %+v\n", user)
+	authUser := c.Get("user").(telebot.User)
 
-		return next(c)
+	blocks, err := w.App.Message.GetBlocks(authUser.ID)
+	if err != nil {
+		log.Printf("Failed to retrieve blocks for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get blocks",
+		})
 	}
+
+	return c.JSON(http.StatusOK, blocks)
 }
 
-func (w *WebApp) validateInitData(inputData, botToken string) (bool, error) {
-	initData, err := url.ParseQuery(inputData)
+func (w *WebApp) deleteBlock(c echo.Context) error {
+	log.Printf("Handling deleteBlock request from URI: %s\n", c.Request().RequestURI)
+
+	blockID, err := gocql.ParseUUID(c.Param("id"))
 	if err != nil {
-		log.Printf("Failed to parse web app input data: %v\n", err)
-		return false, err
+		log.Printf("Invalid block ID '%s'\n", c.Param("id"))
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid block ID",
+		})
 	}
 
-	dataCheckString := make([]string, 0, len(initData))
-	for k, v := range initData {
-		if k == "hash" {
-			continue
-		}
-		if len(v) > 0 {
-			dataCheckString = append(dataCheckString, fmt.Sprintf("%s=%s", k, v[0]))
+	authUser := c.Get("user").(telebot.User)
+
+	if err := w.App.Message.Unblock(authUser.ID, blockID); err != nil {
+		if err == gocql.ErrNotFound {
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "Block not found",
+			})
 		}
+		log.Printf("Failed to delete block %s for UserID: %d, Error: %v\n", blockID, authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to delete block",
+		})
 	}
 
-	sort.Strings(dataCheckString)
+	log.Printf("Block %s deleted for UserID: %d\n", blockID, authUser.ID)
+	return c.JSON(http.StatusOK, map[string]any{
+		"status": "ok",
+	})
+}
+
+// reportMessage reports a message in the inbox, or with fromSent a reply.
+func (w *WebApp) reportMessage(fromSent bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		log.Printf("Handling reportMessage request from URI: %s\n", c.Request().RequestURI)
+
+		messageID, err := gocql.ParseUUID(c.Param("id"))
+		if err != nil {
+			log.Printf("Invalid message ID '%s'\n", c.Param("id"))
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Invalid message ID",
+			})
+		}
 
-	secret := hmac.New(sha256.New, []byte("WebAppData"))
-	secret.Write([]byte(botToken))
+		var req entity.ReportRequest
+		if err := c.Bind(&req); err != nil {
+			log.Println("Failed to bind request body to ReportRequest entity")
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Invalid request body",
+			})
+		}
 
-	hHash := hmac.New(sha256.New, secret.Sum(nil))
-	hHash.Write([]byte(strings.Join(dataCheckString, "\n")))
+		authUser := c.Get("user").(telebot.User)
 
-	hash := hex.EncodeToString(hHash.Sum(nil))
+		var report entity.Report
+		if fromSent {
+			report, err = w.App.Message.ReportReply(c.Request().Context(), authUser.ID, messageID, strings.TrimSpace(req.Reason), req.Evidence)
+		} else {
+			report, err = w.App.Message.Report(c.Request().Context(), authUser.ID, messageID, strings.TrimSpace(req.Reason), req.Evidence)
+		}
+		if err != nil {
+			switch {
+			case err == gocql.ErrNotFound:
+				log.Printf("Message %s not found for UserID: %d\n", messageID, authUser.ID)
+				return c.JSON(http.StatusNotFound, map[string]any{
+					"error": "Message not found",
+				})
+			case errors.Is(err, services.ErrReportInvalid):
+				return c.JSON(http.StatusBadRequest, map[string]any{
+					"error": "Reason is required and reason or evidence is too long",
+				})
+			case errors.Is(err, services.ErrNotReportable):
+				return c.JSON(http.StatusConflict, map[string]any{
+					"error": "This message can't be reported",
+				})
+			case errors.Is(err, services.ErrAlreadyReported):
+				return c.JSON(http.StatusConflict, map[string]any{
+					"code":  "already_reported",
+					"error": "You already reported this message",
+				})
+			}
+			log.Printf("Failed to report message %s for UserID: %d, Error: %v\n", messageID, authUser.ID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to report message",
+			})
+		}
 
-	if initData.Get("hash") != hash {
-		log.Printf("Hash mismatch: %s\n", initData.Get("hash"))
-		return false, nil
+		log.Printf("Message %s reported by UserID: %d as report %s\n", messageID, authUser.ID, report.ID)
+		return c.JSON(http.StatusCreated, report)
 	}
+}
 
-	log.Println("Init data validated successfully")
-	return true, nil
+func (w *WebApp) deleteAccount(c echo.Context) error {
+	log.Printf("Handling deleteAccount request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	if err := w.App.Attachment.DeleteForUser(c.Request().Context(), u.ID); err != nil {
+		log.Printf("Failed to delete attachments for UserID: %d, Error: %v\n", u.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to delete user",
+		})
+	}
+
+	if err := w.App.Message.DeleteOutbox(u.ID); err != nil {
+		log.Printf("Failed to delete outbox for UserID: %d, Error: %v\n", u.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to delete user",
+		})
+	}
+
+	if err := w.App.Account.DeleteUser(u); err != nil {
+		log.Printf("Failed to delete user for ID: %d, Error: %v\n", u.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to delete user",
+		})
+	}
+
+	if err := w.App.Auth.RevokeUserSessions(c.Request().Context(), u.ID); err != nil {
+		log.Printf("Failed to revoke sessions for UserID: %d, Error: %v\n", u.ID, err)
+	}
+
+	log.Printf("User deleted successfully for ID: %d\n", authUser.ID)
+	if err := w.App.Message.AccountDeleted(c.Request().Context(), authUser.ID); err != nil {
+		log.Printf("Failed to send account deletion notification to UserID: %d, Error: %v\n", authUser.ID, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"status": "ok",
+	})
+}
+
+func (w *WebApp) setPubKey(c echo.Context) error {
+	log.Printf("Handling setPupKey request from URI: %s\n", c.Request().RequestURI)
+
+	var pubkey entity.PubKey
+	err := c.Bind(&pubkey)
+	if err != nil {
+		log.Println("Failed to bind request body to PubKey entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "PubKey can't be empty",
+		})
+	}
+
+	if len(strings.TrimSpace(pubkey.Value)) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "PubKey can't be empty",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	u.PubKey = pubkey.Value
+
+	if err := w.App.Account.SetPubKey(u); err != nil {
+		log.Printf("Failed to update PubKey for UserID: %d, Error: %v\n", u.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to update PubKey",
+		})
+	}
+
+	if err := w.App.Message.PublishEvent(c.Request().Context(), u.ID, entity.Event{
+		Type:   entity.EventKeyChanged,
+		PubKey: u.PubKey,
+	}); err != nil {
+		log.Printf("Failed to publish key change for UserID: %d, Error: %v\n", u.ID, err)
+	}
+
+	log.Printf("PubKey updated successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, map[string]any{
+		"status": "ok",
+	})
+}
+
+func (w *WebApp) rotatePrivateID(c echo.Context) error {
+	log.Printf("Handling rotatePrivateID request from URI: %s\n", c.Request().RequestURI)
+
+	var rotate entity.RotatePrivateID
+	if err := c.Bind(&rotate); err != nil {
+		log.Println("Failed to bind request body to RotatePrivateID entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid request body",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	u, err = w.App.Account.RotatePrivateID(u, rotate.KeepOld)
+	if err != nil {
+		log.Printf("Failed to rotate private ID for UserID: %d, Error: %v\n", authUser.ID, err)
+		if errors.Is(err, services.ErrPrivateIDExhausted) {
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{
+				"error": "No free private ID is available right now, try again later",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to rotate private ID",
+		})
+	}
+
+	log.Printf("Private ID rotated successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, u)
+}
+
+func (w *WebApp) setPrivateID(c echo.Context) error {
+	log.Printf("Handling setPrivateID request from URI: %s\n", c.Request().RequestURI)
+
+	var vanity entity.VanityID
+	if err := c.Bind(&vanity); err != nil || strings.TrimSpace(vanity.Value) == "" {
+		log.Println("Failed to bind request body to VanityID entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Private ID can't be empty",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	u, err = w.App.Account.ClaimVanityID(u, vanity.Value, vanity.KeepOld)
+	if err != nil {
+		log.Printf("Failed to set private ID for UserID: %d, Error: %v\n", authUser.ID, err)
+
+		var cooldown *services.VanityCooldownError
+		switch {
+		case errors.As(err, &cooldown):
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(cooldown.RetryAfter.Seconds())+1))
+			return c.JSON(http.StatusTooManyRequests, map[string]any{
+				"error": cooldown.Error(),
+			})
+		case errors.Is(err, repository.ErrPrivateIDTaken):
+			return c.JSON(http.StatusConflict, map[string]any{
+				"error": "Private ID is already taken",
+			})
+		case errors.Is(err, services.ErrVanityIDInvalid),
+			errors.Is(err, services.ErrVanityIDReserved),
+			errors.Is(err, services.ErrVanityIDUnchanged):
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to set private ID",
+		})
+	}
+
+	log.Printf("Private ID set successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, u)
+}
+
+func (w *WebApp) getAliases(c echo.Context) error {
+	log.Printf("Handling getAliases request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	aliases, err := w.App.Account.GetAliases(entity.User{ID: authUser.ID})
+	if err != nil {
+		log.Printf("Failed to retrieve aliases for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get aliases",
+		})
+	}
+
+	return c.JSON(http.StatusOK, aliases)
+}
+
+func (w *WebApp) createAlias(c echo.Context) error {
+	log.Printf("Handling createAlias request from URI: %s\n", c.Request().RequestURI)
+
+	var req entity.AliasRequest
+	if err := c.Bind(&req); err != nil {
+		log.Println("Failed to bind request body to AliasRequest entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid request body",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	alias, err := w.App.Account.CreateAlias(u, req.Label)
+	if err != nil {
+		log.Printf("Failed to create alias for UserID: %d, Error: %v\n", authUser.ID, err)
+		if errors.Is(err, services.ErrAliasLabelInvalid) || errors.Is(err, services.ErrAliasLimit) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, services.ErrPrivateIDExhausted) {
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{
+				"error": "No free private ID is available right now, try again later",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to create alias",
+		})
+	}
+
+	log.Printf("Alias created successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusCreated, alias)
+}
+
+func (w *WebApp) updateAlias(c echo.Context) error {
+	log.Printf("Handling updateAlias request from URI: %s\n", c.Request().RequestURI)
+
+	var req entity.AliasRequest
+	if err := c.Bind(&req); err != nil {
+		log.Println("Failed to bind request body to AliasRequest entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid request body",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+	u := entity.User{ID: authUser.ID}
+
+	alias, err := w.App.Account.GetAlias(u, c.Param("privateID"))
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "Alias not found",
+			})
+		}
+		log.Printf("Failed to retrieve alias for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get alias",
+		})
+	}
+
+	alias, err = w.App.Account.UpdateAlias(u, alias, req.Label, req.Enabled)
+	if err != nil {
+		log.Printf("Failed to update alias for UserID: %d, Error: %v\n", authUser.ID, err)
+		if errors.Is(err, services.ErrAliasLabelInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to update alias",
+		})
+	}
+
+	return c.JSON(http.StatusOK, alias)
+}
+
+func (w *WebApp) deleteAlias(c echo.Context) error {
+	log.Printf("Handling deleteAlias request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+	u := entity.User{ID: authUser.ID}
+
+	alias, err := w.App.Account.GetAlias(u, c.Param("privateID"))
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "Alias not found",
+			})
+		}
+		log.Printf("Failed to retrieve alias for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get alias",
+		})
+	}
+
+	if err := w.App.Account.DeleteAlias(u, alias); err != nil {
+		log.Printf("Failed to delete alias for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to delete alias",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"status": "ok",
+	})
+}
+
+func (w *WebApp) setRetention(c echo.Context) error {
+	log.Printf("Handling setRetention request from URI: %s\n", c.Request().RequestURI)
+
+	var retention entity.Retention
+	if err := c.Bind(&retention); err != nil {
+		log.Println("Failed to bind request body to Retention entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid request body",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	u, err = w.App.Account.SetRetention(u, retention.Value)
+	if err != nil {
+		log.Printf("Failed to update retention for UserID: %d, Error: %v\n", authUser.ID, err)
+		if errors.Is(err, services.ErrRetentionInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to update retention",
+		})
+	}
+
+	log.Printf("Retention updated successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, u)
+}
+
+func (w *WebApp) setInbox(c echo.Context) error {
+	log.Printf("Handling setInbox request from URI: %s\n", c.Request().RequestURI)
+
+	var req entity.InboxRequest
+	if err := c.Bind(&req); err != nil {
+		log.Println("Failed to bind request body to InboxRequest entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid request body",
+		})
+	}
+
+	authUser := c.Get("user").(telebot.User)
+
+	u, err := w.App.Account.GetUserByID(authUser.ID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			log.Printf("User not found for ID: %d\n", authUser.ID)
+			return c.JSON(http.StatusNotFound, map[string]any{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to retrieve user for ID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to get user",
+		})
+	}
+
+	u, err = w.App.Account.SetInbox(u, req)
+	if err != nil {
+		log.Printf("Failed to update inbox for UserID: %d, Error: %v\n", authUser.ID, err)
+		if errors.Is(err, services.ErrInboxInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to update inbox",
+		})
+	}
+
+	log.Printf("Inbox updated successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusOK, u)
+}
+
+func (w *WebApp) getUpdates(c echo.Context) error {
+	log.Printf("Handling getUpdates request from URI: %s\n", c.Request().RequestURI)
+
+	timeoutStr := c.QueryParam("timeout")
+	if timeoutStr == "" {
+		timeoutStr = "0.0"
+	}
+
+	timeout, err := strconv.ParseFloat(timeoutStr, 64)
+	if err != nil {
+		log.Printf("Error parsing timeout value '%s': %v\n", timeoutStr, err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid timeout value")
+	}
+
+	log.Printf("Timeout set to %.2f seconds\n", timeout)
+
+	authUser := c.Get("user").(telebot.User)
+
+	// Clients that name their device acknowledge explicitly through "ack";
+	// for everyone else whatever is returned counts as delivered.
+	device, explicitAck, err := deviceID(c)
+	if err != nil {
+		log.Printf("Invalid device ID: %v\n", err)
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "Invalid device ID"})
+	}
+
+	if ack := c.QueryParam("ack"); ack != "" {
+		if err := w.App.Message.Ack(c.Request().Context(), authUser.ID, device, ack); err != nil {
+			log.Printf("Error acknowledging events for user ID %d: %v\n", authUser.ID, err)
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": "Invalid ack value"})
+		}
+	}
+
+	events, err := w.App.Message.PendingEvents(c.Request().Context(), authUser.ID, device, "")
+	if err != nil {
+		log.Printf("Error retrieving messages for user ID %d: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to retrieve messages"})
+	}
+
+	if len(eventMessages(events)) == 0 {
+		log.Println("No pending messages. Waiting for new messages...")
+		newEvents, err := w.App.Message.WaitForEvents(c.Request().Context(), authUser.ID, device, timeout)
+		if err != nil && !rueidis.IsRedisNil(err) {
+			log.Printf("Error retrieving new messages for user ID %d: %v\n", authUser.ID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to retrieve new messages"})
+		}
+		events = append(events, newEvents...)
+	}
+
+	// Events getUpdates has no shape for, such as deletions and key changes,
+	// are acknowledged along with the messages; clients that need them use
+	// /ws or /events.
+	if len(events) > 0 {
+		lastStreamID := events[len(events)-1].StreamID
+		if explicitAck {
+			c.Response().Header().Set("X-Stream-ID", lastStreamID)
+		} else if err := w.App.Message.Ack(c.Request().Context(), authUser.ID, device, lastStreamID); err != nil {
+			log.Printf("Error acknowledging events for user ID %d: %v\n", authUser.ID, err)
+		}
+	}
+
+	messages := eventMessages(events)
+	if len(messages) == 0 {
+		return c.JSON(http.StatusNoContent, map[string]any{"error": "No new messages"})
+	}
+
+	if err := w.App.Message.MarkDelivered(authUser.ID, events); err != nil {
+		log.Printf("Failed to mark messages delivered for user ID %d: %v\n", authUser.ID, err)
+	}
+
+	log.Printf("Retrieved %d messages for user ID %d\n", len(messages), authUser.ID)
+	return c.JSON(http.StatusOK, messages)
+}
+
+func (w *WebApp) createSession(c echo.Context) error {
+	log.Printf("Handling createSession request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	tokens, err := w.App.Auth.CreateSession(c.Request().Context(), entity.Session{
+		UserID:    authUser.ID,
+		Username:  authUser.Username,
+		FirstName: authUser.FirstName,
+		IsPremium: authUser.IsPremium,
+	})
+	if err != nil {
+		log.Printf("Failed to create session for UserID: %d, Error: %v\n", authUser.ID, err)
+		if errors.Is(err, services.ErrSessionsDisabled) {
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{
+				"error": "Sessions are not enabled",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to create session",
+		})
+	}
+
+	log.Printf("Session created successfully for UserID: %d\n", authUser.ID)
+	return c.JSON(http.StatusCreated, tokens)
+}
+
+func (w *WebApp) refreshSession(c echo.Context) error {
+	log.Printf("Handling refreshSession request from URI: %s\n", c.Request().RequestURI)
+
+	var refreshToken entity.RefreshToken
+	if err := c.Bind(&refreshToken); err != nil || strings.TrimSpace(refreshToken.Value) == "" {
+		log.Println("Failed to bind request body to RefreshToken entity")
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Refresh token can't be empty",
+		})
+	}
+
+	tokens, err := w.App.Auth.RefreshSession(c.Request().Context(), refreshToken.Value)
+	if err != nil {
+		log.Printf("Failed to refresh session, Error: %v\n", err)
+		switch {
+		case errors.Is(err, services.ErrSessionsDisabled):
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{
+				"error": "Sessions are not enabled",
+			})
+		case errors.Is(err, services.ErrSessionInvalid), errors.Is(err, services.ErrSessionRevoked):
+			return c.JSON(http.StatusUnauthorized, map[string]any{
+				"code":  sessionErrorCode(err),
+				"error": "Refresh token is not valid",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to refresh session",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (w *WebApp) deleteSession(c echo.Context) error {
+	log.Printf("Handling deleteSession request from URI: %s\n", c.Request().RequestURI)
+
+	session, ok := c.Get("session").(entity.Session)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Request is not authenticated with a session",
+		})
+	}
+
+	if err := w.App.Auth.RevokeSession(c.Request().Context(), session); err != nil {
+		log.Printf("Failed to revoke session for UserID: %d, Error: %v\n", session.UserID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	log.Printf("Session revoked successfully for UserID: %d\n", session.UserID)
+	return c.JSON(http.StatusOK, map[string]any{
+		"status": "ok",
+	})
+}
+
+// eventMessages extracts the messages carried by new-message and new-reply
+// events; replies are told apart by InReplyTo. These are exactly the events
+// MarkDelivered records, so getUpdates never marks delivered what it didn't
+// return.
+func eventMessages(events []entity.Event) []entity.Message {
+	var messages []entity.Message
+	for _, event := range events {
+		switch event.Type {
+		case entity.EventNewMessage, entity.EventNewReply:
+			if event.Message != nil {
+				messages = append(messages, *event.Message)
+			}
+		}
+	}
+	return messages
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/internal/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// fullAccounts is a repository.Account where every private ID is taken.
+type fullAccounts struct {
+	repository.Account
+	user entity.User
+}
+
+func (a *fullAccounts) ByID(ID int64) (entity.User, error) {
+	if ID != a.user.ID {
+		return entity.User{}, gocql.ErrNotFound
+	}
+	return a.user, nil
+}
+
+func (a *fullAccounts) RotatePrivateID(entity.User, string, time.Duration) error {
+	return repository.ErrPrivateIDTaken
+}
+
+// handle runs handler for a JSON request made by userID.
+func handle(handler echo.HandlerFunc, userID int64, method, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", telebot.User{ID: userID})
+	_ = handler(c)
+	return rec
+}
+
+func TestRotatePrivateIDExhausted(t *testing.T) {
+	accounts := &fullAccounts{user: entity.User{ID: 42, PrivateID: "abcdef"}}
+	w := &WebApp{App: &services.App{Account: services.NewAccountService(accounts, services.AccountOptions{})}}
+
+	rec := handle(w.rotatePrivateID, 42, http.MethodPost, `{"keep_old":false}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, body %s; want 503", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReplyToReplyInboxClosed(t *testing.T) {
+	w := &WebApp{App: &services.App{Account: services.NewAccountService(&fullAccounts{}, services.AccountOptions{})}}
+
+	// withCounterpart resolved the reply's sender, whose inbox is paused.
+	handler := func(c echo.Context) error {
+		c.Set("messageID", gocql.TimeUUID())
+		c.Set("recipient", entity.User{ID: 7, InboxMode: entity.InboxPaused})
+		return w.reply(true)(c)
+	}
+
+	rec := handle(handler, 42, http.MethodPost, `{"message":"ciphertext"}`)
+	if rec.Code != http.StatusForbidden || responseCode(t, rec) != "inbox_closed" {
+		t.Fatalf("status = %d, body %s; want 403 inbox_closed", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEventMessages(t *testing.T) {
+	message := entity.Message{ID: gocql.TimeUUID()}
+	inReplyTo := gocql.TimeUUID()
+	reply := entity.Message{ID: gocql.TimeUUID(), InReplyTo: &inReplyTo}
+
+	messages := eventMessages([]entity.Event{
+		{Type: entity.EventNewMessage, Message: &message},
+		{Type: entity.EventMessageDeleted, MessageID: message.ID.String()},
+		{Type: entity.EventNewReply, Message: &reply},
+		{Type: entity.EventKeyChanged, PubKey: "key"},
+	})
+	if len(messages) != 2 || messages[0].ID != message.ID || messages[1].ID != reply.ID {
+		t.Fatalf("eventMessages() = %+v, want the message and the reply", messages)
+	}
+}
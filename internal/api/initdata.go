@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"pipe/internal/config"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInitDataMalformed = errors.New("init data is malformed")
+	errInitDataInvalid   = errors.New("init data signature is invalid")
+	errInitDataExpired   = errors.New("init data is expired")
+	errInitDataReplayed  = errors.New("init data has already been used")
+)
+
+// TelegramPublicKey is the Ed25519 key Telegram signs production init data with.
+const TelegramPublicKey = "e7bf03a2fa4602af4580703d88dda5bb59f32ed8b02a56c187fe7d34caed242d"
+
+// InitDataValidator verifies that init data was issued by Telegram for this bot.
+type InitDataValidator interface {
+	Validate(initData url.Values) error
+}
+
+var (
+	_ InitDataValidator = &HMACValidator{}
+	_ InitDataValidator = &Ed25519Validator{}
+)
+
+// HMACValidator checks the "hash" field, which requires the bot token.
+type HMACValidator struct {
+	botToken string
+}
+
+func NewHMACValidator(botToken string) *HMACValidator {
+	return &HMACValidator{botToken: botToken}
+}
+
+func (v *HMACValidator) Validate(initData url.Values) error {
+	receivedHash, err := hex.DecodeString(initData.Get("hash"))
+	if err != nil || len(receivedHash) == 0 {
+		return fmt.Errorf("%w: missing or invalid hash", errInitDataMalformed)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(v.botToken))
+
+	hHash := hmac.New(sha256.New, secret.Sum(nil))
+	hHash.Write([]byte(dataCheckString(initData, "hash")))
+
+	if !hmac.Equal(receivedHash, hHash.Sum(nil)) {
+		log.Printf("Hash mismatch: %s\n", initData.Get("hash"))
+		return errInitDataInvalid
+	}
+	return nil
+}
+
+// Ed25519Validator checks the third-party "signature" field, which only
+// requires the bot ID and Telegram's public key.
+type Ed25519Validator struct {
+	botID     int64
+	publicKey ed25519.PublicKey
+}
+
+func NewEd25519Validator(botID int64, publicKeyHex string) (*Ed25519Validator, error) {
+	if botID == 0 {
+		return nil, errors.New("bot id is required for ed25519 validation")
+	}
+
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid telegram public key %q", publicKeyHex)
+	}
+
+	return &Ed25519Validator{botID: botID, publicKey: publicKey}, nil
+}
+
+func (v *Ed25519Validator) Validate(initData url.Values) error {
+	encoded := initData.Get("signature")
+	if encoded == "" {
+		return fmt.Errorf("%w: missing signature", errInitDataMalformed)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: invalid signature encoding", errInitDataMalformed)
+	}
+
+	message := fmt.Sprintf("%d:WebAppData\n%s", v.botID, dataCheckString(initData, "hash", "signature"))
+	if !ed25519.Verify(v.publicKey, []byte(message), signature) {
+		log.Printf("Signature mismatch: %s\n", encoded)
+		return errInitDataInvalid
+	}
+	return nil
+}
+
+// NewInitDataValidator builds the validator selected by kind ("hmac" or "ed25519").
+func NewInitDataValidator(kind, botToken string, botID int64, publicKeyHex string) (InitDataValidator, error) {
+	switch kind {
+	case "", "hmac":
+		if botToken == "" {
+			return nil, errors.New("bot token is required for hmac validation")
+		}
+		return NewHMACValidator(botToken), nil
+	case "ed25519":
+		if botID == 0 {
+			botID = botIDFromToken(botToken)
+		}
+		if publicKeyHex == "" {
+			publicKeyHex = TelegramPublicKey
+		}
+		return NewEd25519Validator(botID, publicKeyHex)
+	default:
+		return nil, fmt.Errorf("unknown init data validator %q", kind)
+	}
+}
+
+// botIDFromToken extracts the numeric bot ID prefix of a bot token.
+func botIDFromToken(botToken string) int64 {
+	prefix, _, _ := strings.Cut(botToken, ":")
+	id, _ := strconv.ParseInt(prefix, 10, 64)
+	return id
+}
+
+func (w *WebApp) validateInitData(inputData string) (url.Values, error) {
+	initData, err := url.ParseQuery(inputData)
+	if err != nil {
+		log.Printf("Failed to parse web app input data: %v\n", err)
+		return nil, fmt.Errorf("%w: %v", errInitDataMalformed, err)
+	}
+
+	if err := w.validator.Validate(initData); err != nil {
+		return nil, err
+	}
+
+	if err := checkAuthDate(initData.Get("auth_date"), config.AppConfig.InitDataMaxAge); err != nil {
+		return nil, err
+	}
+
+	log.Println("Init data validated successfully")
+	return initData, nil
+}
+
+// dataCheckString joins the sorted "key=value" pairs of initData, skipping
+// the excluded keys.
+func dataCheckString(initData url.Values, exclude ...string) string {
+	pairs := make([]string, 0, len(initData))
+	for k, v := range initData {
+		if slices.Contains(exclude, k) {
+			continue
+		}
+		if len(v) > 0 {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", k, v[0]))
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\n")
+}
+
+// checkAuthDate rejects init data older than maxAge. A zero maxAge disables the check.
+func checkAuthDate(authDate string, maxAge time.Duration) error {
+	unix, err := strconv.ParseInt(authDate, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid auth_date", errInitDataMalformed)
+	}
+
+	if maxAge <= 0 {
+		return nil
+	}
+
+	if age := time.Since(time.Unix(unix, 0)); age > maxAge {
+		return fmt.Errorf("%w: issued %s ago", errInitDataExpired, age.Truncate(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pipe/internal/config"
+	"pipe/internal/repository"
+	"pipe/internal/services"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testBotToken = "123456:test-token"
+
+// signHMAC returns init data for userID signed the way Telegram signs "hash".
+func signHMAC(t *testing.T, botToken string, userID int64, authDate time.Time) string {
+	t.Helper()
+
+	user, err := json.Marshal(map[string]any{"id": userID, "first_name": "Test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := url.Values{}
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("query_id", "AAH"+strconv.FormatInt(authDate.UnixNano(), 10))
+	values.Set("user", string(user))
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(dataCheckString(values)))
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+
+	return values.Encode()
+}
+
+func setTestConfig(t *testing.T, cfg config.Config) {
+	t.Helper()
+	previous := config.AppConfig
+	config.AppConfig = &cfg
+	t.Cleanup(func() { config.AppConfig = previous })
+}
+
+func TestCheckAuthDate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		authDate string
+		maxAge   time.Duration
+		want     error
+	}{
+		{"fresh", strconv.FormatInt(now.Add(-time.Minute).Unix(), 10), time.Hour, nil},
+		{"expired", strconv.FormatInt(now.Add(-2*time.Hour).Unix(), 10), time.Hour, errInitDataExpired},
+		{"check disabled", strconv.FormatInt(now.Add(-48*time.Hour).Unix(), 10), 0, nil},
+		{"missing", "", time.Hour, errInitDataMalformed},
+		{"not a number", "yesterday", 0, errInitDataMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAuthDate(tt.authDate, tt.maxAge)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("checkAuthDate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHMACValidator(t *testing.T) {
+	validator := NewHMACValidator(testBotToken)
+
+	valid, err := url.ParseQuery(signHMAC(t, testBotToken, 42, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.Validate(valid); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+
+	forged, _ := url.ParseQuery(signHMAC(t, "654321:other-token", 42, time.Now()))
+	if err := validator.Validate(forged); !errors.Is(err, errInitDataInvalid) {
+		t.Fatalf("Validate() with foreign token = %v, want %v", err, errInitDataInvalid)
+	}
+
+	tampered, _ := url.ParseQuery(signHMAC(t, testBotToken, 42, time.Now()))
+	tampered.Set("user", `{"id":43,"first_name":"Test"}`)
+	if err := validator.Validate(tampered); !errors.Is(err, errInitDataInvalid) {
+		t.Fatalf("Validate() with tampered user = %v, want %v", err, errInitDataInvalid)
+	}
+
+	unsigned, _ := url.ParseQuery("auth_date=1&user=%7B%7D")
+	if err := validator.Validate(unsigned); !errors.Is(err, errInitDataMalformed) {
+		t.Fatalf("Validate() without hash = %v, want %v", err, errInitDataMalformed)
+	}
+}
+
+// signEd25519 returns init data for botID signed the way Telegram signs
+// "signature" for third parties.
+func signEd25519(t *testing.T, key ed25519.PrivateKey, botID int64) url.Values {
+	t.Helper()
+
+	values := url.Values{}
+	values.Set("auth_date", strconv.FormatInt(time.Now().Unix(), 10))
+	values.Set("user", `{"id":42,"first_name":"Test"}`)
+	values.Set("hash", "ignored-by-ed25519")
+
+	message := fmt.Sprintf("%d:WebAppData\n%s", botID, dataCheckString(values, "hash"))
+	values.Set("signature", base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(message))))
+	return values
+}
+
+func TestEd25519Validator(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := NewEd25519Validator(123456, hex.EncodeToString(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validator.Validate(signEd25519(t, privateKey, 123456)); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+
+	if err := validator.Validate(signEd25519(t, privateKey, 654321)); !errors.Is(err, errInitDataInvalid) {
+		t.Fatalf("Validate() for another bot = %v, want %v", err, errInitDataInvalid)
+	}
+
+	tampered := signEd25519(t, privateKey, 123456)
+	tampered.Set("user", `{"id":43,"first_name":"Test"}`)
+	if err := validator.Validate(tampered); !errors.Is(err, errInitDataInvalid) {
+		t.Fatalf("Validate() with tampered user = %v, want %v", err, errInitDataInvalid)
+	}
+
+	padded := signEd25519(t, privateKey, 123456)
+	padded.Set("signature", padded.Get("signature")+"==")
+	if err := validator.Validate(padded); err != nil {
+		t.Fatalf("Validate() with padded signature = %v, want nil", err)
+	}
+
+	unsigned := signEd25519(t, privateKey, 123456)
+	unsigned.Del("signature")
+	if err := validator.Validate(unsigned); !errors.Is(err, errInitDataMalformed) {
+		t.Fatalf("Validate() without signature = %v, want %v", err, errInitDataMalformed)
+	}
+}
+
+func TestNewInitDataValidator(t *testing.T) {
+	if _, err := NewInitDataValidator("hmac", "", 0, ""); err == nil {
+		t.Fatal("hmac validator without a bot token was accepted")
+	}
+
+	validator, err := NewInitDataValidator("ed25519", "", 123456, "")
+	if err != nil {
+		t.Fatalf("ed25519 validator without a bot token = %v, want nil", err)
+	}
+	if v := validator.(*Ed25519Validator); v.botID != 123456 || hex.EncodeToString(v.publicKey) != TelegramPublicKey {
+		t.Fatalf("ed25519 validator = %+v, want bot 123456 and Telegram's key", v)
+	}
+
+	validator, err = NewInitDataValidator("ed25519", testBotToken, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := validator.(*Ed25519Validator); v.botID != 123456 {
+		t.Fatalf("bot ID from token = %d, want 123456", v.botID)
+	}
+
+	if _, err := NewInitDataValidator("ed25519", "", 0, ""); err == nil {
+		t.Fatal("ed25519 validator without a bot ID was accepted")
+	}
+	if _, err := NewInitDataValidator("rsa", testBotToken, 0, ""); err == nil {
+		t.Fatal("unknown validator kind was accepted")
+	}
+}
+
+// replayCache is an in-memory stand-in for the Redis init data replay cache.
+type replayCache struct {
+	repository.Auth
+	seen map[string]bool
+	err  error
+}
+
+func (r *replayCache) ClaimInitData(_ context.Context, hash string, _ time.Duration) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	if r.seen[hash] {
+		return false, nil
+	}
+	r.seen[hash] = true
+	return true, nil
+}
+
+func authenticateRequest(w *WebApp, initData string) (*httptest.ResponseRecorder, bool) {
+	req := httptest.NewRequest(http.MethodGet, "/getMe", nil)
+	req.Header.Set("Authorization", "tma "+initData)
+	rec := httptest.NewRecorder()
+
+	called := false
+	next := func(c echo.Context) error {
+		called = true
+		return c.NoContent(http.StatusOK)
+	}
+
+	c := echo.New().NewContext(req, rec)
+	_ = w.withAuth(next)(c)
+	return rec, called
+}
+
+func responseCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid response body %q: %v", rec.Body.String(), err)
+	}
+	code, _ := body["code"].(string)
+	return code
+}
+
+func TestAuthenticateInitData(t *testing.T) {
+	setTestConfig(t, config.Config{InitDataMaxAge: time.Hour, InitDataSingleUse: true})
+
+	cache := &replayCache{seen: map[string]bool{}}
+	w := &WebApp{
+		App:       &services.App{Auth: services.NewAuthService(cache, "", time.Minute, time.Hour, 0)},
+		validator: NewHMACValidator(testBotToken),
+	}
+
+	initData := signHMAC(t, testBotToken, 42, time.Now())
+
+	rec, called := authenticateRequest(w, initData)
+	if !called || rec.Code != http.StatusOK {
+		t.Fatalf("first use: status %d, called %v; want 200 and handler called", rec.Code, called)
+	}
+
+	rec, called = authenticateRequest(w, initData)
+	if called || rec.Code != http.StatusUnauthorized || responseCode(t, rec) != "init_data_replayed" {
+		t.Fatalf("replay: status %d, body %s; want 401 init_data_replayed", rec.Code, rec.Body.String())
+	}
+
+	expired := signHMAC(t, testBotToken, 42, time.Now().Add(-2*time.Hour))
+	rec, called = authenticateRequest(w, expired)
+	if called || rec.Code != http.StatusUnauthorized || responseCode(t, rec) != "init_data_expired" {
+		t.Fatalf("expired: status %d, body %s; want 401 init_data_expired", rec.Code, rec.Body.String())
+	}
+
+	cache.err = errors.New("connection refused")
+	rec, called = authenticateRequest(w, signHMAC(t, testBotToken, 42, time.Now()))
+	if called || rec.Code != http.StatusInternalServerError {
+		t.Fatalf("cache down: status %d, body %s; want 500", rec.Code, rec.Body.String())
+	}
+}
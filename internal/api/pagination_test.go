@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/internal/services"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// pagedMessages is a repository.Message whose inbox pages through messages,
+// with the page state holding the offset of the next page.
+type pagedMessages struct {
+	repository.Message
+	messages []entity.Message
+}
+
+func (m *pagedMessages) ByUserID(_ int64, limit int, pageState []byte) ([]entity.Message, []byte, error) {
+	offset := 0
+	if len(pageState) > 0 {
+		offset, _ = strconv.Atoi(string(pageState))
+	}
+	end := min(offset+limit, len(m.messages))
+	var next []byte
+	if end < len(m.messages) {
+		next = []byte(strconv.Itoa(end))
+	}
+	return m.messages[offset:end], next, nil
+}
+
+// get runs handler for a GET of target made by userID.
+func get(handler echo.HandlerFunc, userID int64, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", telebot.User{ID: userID})
+	_ = handler(c)
+	return rec
+}
+
+func newPagingApp(count int) *WebApp {
+	messages := &pagedMessages{}
+	for i := 0; i < count; i++ {
+		messages.messages = append(messages.messages, entity.Message{ID: gocql.TimeUUID()})
+	}
+	service := services.NewMessageService(services.MessageRepositories{Messages: messages}, nil, nil, 0, 0, time.Time{}, nil)
+	return &WebApp{App: &services.App{Message: service}}
+}
+
+func TestGetMessagesPages(t *testing.T) {
+	w := newPagingApp(5)
+
+	var ids []gocql.UUID
+	target := "/getMessages?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination never ended")
+		}
+
+		rec := get(w.getMessages, 1, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s; want 200", rec.Code, rec.Body.String())
+		}
+		var page entity.MessagePage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Messages) > 2 {
+			t.Fatalf("page of %d messages, want at most 2", len(page.Messages))
+		}
+		for _, message := range page.Messages {
+			ids = append(ids, message.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		target = "/getMessages?limit=2&cursor=" + page.NextCursor
+	}
+
+	if len(ids) != 5 {
+		t.Fatalf("read %d messages, want 5", len(ids))
+	}
+}
+
+func TestGetMessagesUnpaginated(t *testing.T) {
+	w := newPagingApp(maxMessagesLimit + 1)
+
+	rec := get(w.getMessages, 1, "/getMessages")
+	var messages []entity.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &messages); err != nil {
+		t.Fatalf("body %s isn't a plain array: %v", rec.Body.String(), err)
+	}
+	if len(messages) != maxMessagesLimit {
+		t.Fatalf("got %d messages, want the first %d", len(messages), maxMessagesLimit)
+	}
+}
+
+func TestPagedEndpointsRejectPageParams(t *testing.T) {
+	w := newPagingApp(1)
+	handlers := map[string]echo.HandlerFunc{
+		"/getMessages": w.getMessages,
+		"/outbox":      w.getOutbox,
+		"/replies":     w.getReplies,
+	}
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=-1",
+		"limit=abc",
+		"limit=" + strconv.Itoa(maxMessagesLimit+1),
+		"cursor=" + base64.StdEncoding.EncodeToString([]byte("page")),
+		"cursor=%25%25",
+	} {
+		for path, handler := range handlers {
+			if rec := get(handler, 1, path+"?"+query); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s?%s: status = %d, want 400", path, query, rec.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"pipe/internal/entity"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/telebot.v3"
+)
+
+// withSendLimit rejects messages once the sender, their IP, the recipient or
+// the pair of them go over their limit. Requests for unknown recipients and
+// limiter failures are let through; the handler deals with the former.
+func (w *WebApp) withSendLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		recipient, err := w.recipient(c)
+		if err != nil {
+			return next(c)
+		}
+
+		authUser := c.Get("user").(telebot.User)
+
+		wait, err := w.App.RateLimit.AllowSend(c.Request().Context(), c.RealIP(), authUser.ID, recipient.ID)
+		if err != nil {
+			log.Printf("Failed to check send rate limit, Error: %v\n", err)
+			return next(c)
+		}
+
+		if wait > 0 {
+			log.Println("Send rate limit exceeded")
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return c.JSON(http.StatusTooManyRequests, map[string]any{
+				"error": "Too many messages, try again later",
+			})
+		}
+
+		return next(c)
+	}
+}
+
+// withReportLimit rejects reports once the reporter goes over their limit,
+// so one user can't flood the moderators. Limiter failures are let through.
+func (w *WebApp) withReportLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authUser := c.Get("user").(telebot.User)
+
+		wait, err := w.App.RateLimit.AllowReport(c.Request().Context(), authUser.ID)
+		if err != nil {
+			log.Printf("Failed to check report rate limit, Error: %v\n", err)
+			return next(c)
+		}
+
+		if wait > 0 {
+			log.Println("Report rate limit exceeded")
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return c.JSON(http.StatusTooManyRequests, map[string]any{
+				"error": "Too many reports, try again later",
+			})
+		}
+
+		return next(c)
+	}
+}
+
+// withUploadLimit rejects attachment uploads once the uploader goes over
+// their limit, so one user can't fill the blob store. Limiter failures are
+// let through.
+func (w *WebApp) withUploadLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authUser := c.Get("user").(telebot.User)
+
+		wait, err := w.App.RateLimit.AllowUpload(c.Request().Context(), authUser.ID)
+		if err != nil {
+			log.Printf("Failed to check upload rate limit, Error: %v\n", err)
+			return next(c)
+		}
+
+		if wait > 0 {
+			log.Println("Upload rate limit exceeded")
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return c.JSON(http.StatusTooManyRequests, map[string]any{
+				"error": "Too many uploads, try again later",
+			})
+		}
+
+		return next(c)
+	}
+}
+
+// recipient resolves the privateID route parameter once per request for the
+// middlewares guarding sendMessage. On the reply routes withCounterpart has
+// already set it.
+func (w *WebApp) recipient(c echo.Context) (entity.User, error) {
+	if u, ok := c.Get("recipient").(entity.User); ok {
+		return u, nil
+	}
+
+	u, err := w.App.Account.GetUserByPrivateID(c.Param("privateID"))
+	if err != nil {
+		return entity.User{}, err
+	}
+	c.Set("recipient", u)
+	return u, nil
+}
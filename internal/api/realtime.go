@@ -0,0 +1,411 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"pipe/internal/entity"
+	"pipe/internal/services"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/rueidis"
+	"golang.org/x/net/websocket"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// realtimePollTimeout bounds in seconds how long one wait cycle relies on
+	// the event bus before checking the stream again.
+	realtimePollTimeout = 25
+	wsPingInterval      = 30 * time.Second
+	wsReadTimeout       = 75 * time.Second
+	wsWriteTimeout      = 10 * time.Second
+	sseHeartbeat        = 15 * time.Second
+	sseRetry            = 3 * time.Second
+)
+
+// wsFrame is a control frame exchanged over /ws. Besides these the server
+// sends entity.Event objects as they happen.
+//
+// Server frames: "ping", "pong", "error".
+// Client frames: "ack" (with stream_id), "ping", "pong".
+type wsFrame struct {
+	Type     string `json:"type"`
+	StreamID string `json:"stream_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// defaultDeviceID is used by clients that don't identify their device.
+const defaultDeviceID = "default"
+
+// deviceID returns the device the request comes from, taken from the
+// X-Device-ID header or the "device_id" query parameter. Each device has its
+// own position in the user's event stream. The second value reports whether
+// the client named its device.
+func deviceID(c echo.Context) (string, bool, error) {
+	device := c.Request().Header.Get("X-Device-ID")
+	if device == "" {
+		device = c.QueryParam("device_id")
+	}
+	if device == "" {
+		return defaultDeviceID, false, nil
+	}
+
+	if len(device) > 64 {
+		return "", false, errors.New("device id is too long")
+	}
+	for _, r := range device {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", false, fmt.Errorf("character %q is not allowed in device id", r)
+		}
+	}
+	return device, true, nil
+}
+
+// withTicket authenticates clients that can't set request headers, such as
+// browser WebSocket and EventSource, by the single-use "ticket" query
+// parameter from POST /realtime/ticket. Credentials never go in the URL,
+// where they would end up in access logs. Requests without a ticket go
+// through withAuth.
+func (w *WebApp) withTicket(next echo.HandlerFunc) echo.HandlerFunc {
+	authenticated := w.withAuth(next)
+	return func(c echo.Context) error {
+		ticket := c.QueryParam("ticket")
+		if ticket == "" {
+			return authenticated(c)
+		}
+
+		session, err := w.App.Auth.RedeemTicket(c.Request().Context(), ticket)
+		if err != nil {
+			log.Printf("Ticket authorization failed with error: %v\n", err)
+			if errors.Is(err, services.ErrTicketInvalid) {
+				return c.JSON(http.StatusUnauthorized, map[string]any{
+					"code":  "ticket_invalid",
+					"error": "Authorization failed",
+				})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]any{
+				"error": "Failed to verify authorization",
+			})
+		}
+
+		c.Set("user", telebot.User{
+			ID:        session.UserID,
+			Username:  session.Username,
+			FirstName: session.FirstName,
+			IsPremium: session.IsPremium,
+		})
+		log.Printf("Ticket redeemed successfully for UserID: %d\n", session.UserID)
+
+		return next(c)
+	}
+}
+
+// createTicket issues a ticket for opening /ws or /events.
+func (w *WebApp) createTicket(c echo.Context) error {
+	log.Printf("Handling createTicket request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	ticket, err := w.App.Auth.IssueTicket(c.Request().Context(), entity.Session{
+		UserID:    authUser.ID,
+		Username:  authUser.Username,
+		FirstName: authUser.FirstName,
+		IsPremium: authUser.IsPremium,
+	})
+	if err != nil {
+		log.Printf("Failed to issue ticket for UserID: %d, Error: %v\n", authUser.ID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]any{
+			"error": "Failed to create ticket",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, ticket)
+}
+
+func (w *WebApp) ws(c echo.Context) error {
+	log.Printf("Handling ws request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	device, _, err := deviceID(c)
+	if err != nil {
+		log.Printf("Invalid device ID: %v\n", err)
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid device ID",
+		})
+	}
+
+	var lastID gocql.UUID
+	resume := c.QueryParam("last_id") != ""
+	if resume {
+		if lastID, err = gocql.ParseUUID(c.QueryParam("last_id")); err != nil {
+			log.Printf("Invalid last_id value '%s'\n", c.QueryParam("last_id"))
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Invalid last_id",
+			})
+		}
+	}
+
+	websocket.Server{
+		Handler: func(conn *websocket.Conn) {
+			w.serveWS(conn, authUser.ID, device, lastID, resume)
+		},
+	}.ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
+
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (s *wsConn) send(frame any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return websocket.JSON.Send(s.conn, frame)
+}
+
+func (w *WebApp) serveWS(conn *websocket.Conn, userID int64, device string, lastID gocql.UUID, resume bool) {
+	ctx, cancel := context.WithCancel(conn.Request().Context())
+	defer cancel()
+
+	s := &wsConn{conn: conn}
+	log.Printf("WebSocket connected for UserID: %d\n", userID)
+
+	go func() {
+		defer cancel()
+		w.pumpEvents(ctx, userID, device, lastID, resume, func(event entity.Event) error {
+			return s.send(event)
+		})
+	}()
+
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.send(wsFrame{Type: "ping"}); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		var frame wsFrame
+		if err := websocket.JSON.Receive(conn, &frame); err != nil {
+			break
+		}
+
+		switch frame.Type {
+		case "ack":
+			if frame.StreamID == "" {
+				s.send(wsFrame{Type: "error", Error: "Missing stream ID"})
+				continue
+			}
+			if err := w.App.Message.Ack(ctx, userID, device, frame.StreamID); err != nil {
+				log.Printf("Failed to store ack for UserID: %d, Error: %v\n", userID, err)
+			}
+		case "ping":
+			s.send(wsFrame{Type: "pong"})
+		case "pong":
+		default:
+			s.send(wsFrame{Type: "error", Error: "Unknown frame type"})
+		}
+	}
+
+	log.Printf("WebSocket disconnected for UserID: %d\n", userID)
+}
+
+// events streams the user's events as Server-Sent Events. Each event carries
+// its ID so a reconnecting client resumes through Last-Event-ID (or the
+// "last_event_id" query parameter on the first connection).
+//
+// Browser clients authenticate with a ticket. Tickets work once, so the
+// automatic EventSource reconnect is refused; clients open a new EventSource
+// with a fresh ticket and the last ID they saw in "last_event_id".
+func (w *WebApp) events(c echo.Context) error {
+	log.Printf("Handling events request from URI: %s\n", c.Request().RequestURI)
+
+	authUser := c.Get("user").(telebot.User)
+
+	device, _, err := deviceID(c)
+	if err != nil {
+		log.Printf("Invalid device ID: %v\n", err)
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"error": "Invalid device ID",
+		})
+	}
+
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("last_event_id")
+	}
+
+	var lastID gocql.UUID
+	resume := lastEventID != ""
+	if resume {
+		if lastID, err = gocql.ParseUUID(lastEventID); err != nil {
+			log.Printf("Invalid last event ID '%s'\n", lastEventID)
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error": "Invalid Last-Event-ID",
+			})
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	var mu sync.Mutex
+	write := func(chunk string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if _, err := io.WriteString(res, chunk); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	if err := write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds())); err != nil {
+		return nil
+	}
+	log.Printf("Event stream connected for UserID: %d\n", authUser.ID)
+
+	go func() {
+		ticker := time.NewTicker(sseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := write(": ping\n\n"); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	// EventSource can't send acks, so an event counts as acknowledged once it
+	// is written; a reconnecting client catches up through Last-Event-ID.
+	w.pumpEvents(ctx, authUser.ID, device, lastID, resume, func(event entity.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := write(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)); err != nil {
+			return err
+		}
+		if event.StreamID != "" {
+			return w.App.Message.Ack(ctx, authUser.ID, device, event.StreamID)
+		}
+		return nil
+	})
+
+	log.Printf("Event stream disconnected for UserID: %d\n", authUser.ID)
+	return nil
+}
+
+// pumpEvents delivers events to deliver until ctx is done or delivery
+// fails: first the messages missed after lastID, then the events device
+// received earlier but never acknowledged, then new ones as they arrive.
+func (w *WebApp) pumpEvents(ctx context.Context, userID int64, device string, lastID gocql.UUID, resume bool, deliver func(entity.Event) error) {
+	replayed := map[string]struct{}{}
+
+	if resume {
+		missed, err := w.App.Message.EventsAfter(userID, lastID)
+		if err != nil {
+			log.Printf("Failed to replay messages for UserID: %d, Error: %v\n", userID, err)
+			return
+		}
+		for _, event := range missed {
+			if err := deliver(event); err != nil {
+				return
+			}
+			replayed[event.ID] = struct{}{}
+		}
+		w.markDelivered(userID, missed)
+	}
+
+	deliverAll := func(events []entity.Event) error {
+		for _, event := range events {
+			if _, ok := replayed[event.ID]; ok {
+				// Already sent from the replay; only its stream entry is left to settle.
+				if err := w.App.Message.Ack(ctx, userID, device, event.StreamID); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := deliver(event); err != nil {
+				return err
+			}
+			w.markDelivered(userID, []entity.Event{event})
+		}
+		return nil
+	}
+
+	after := ""
+	for {
+		pending, err := w.App.Message.PendingEvents(ctx, userID, device, after)
+		if err != nil {
+			log.Printf("Error retrieving events for user ID %d: %v\n", userID, err)
+			return
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if err := deliverAll(pending); err != nil {
+			return
+		}
+		after = pending[len(pending)-1].StreamID
+	}
+
+	for ctx.Err() == nil {
+		newEvents, err := w.App.Message.WaitForEvents(ctx, userID, device, realtimePollTimeout)
+		if err != nil {
+			if rueidis.IsRedisNil(err) {
+				continue
+			}
+			if ctx.Err() == nil {
+				log.Printf("Error retrieving new events for user ID %d: %v\n", userID, err)
+			}
+			return
+		}
+		if err := deliverAll(newEvents); err != nil {
+			return
+		}
+	}
+}
+
+func (w *WebApp) markDelivered(userID int64, events []entity.Event) {
+	if err := w.App.Message.MarkDelivered(userID, events); err != nil {
+		log.Printf("Failed to mark messages delivered for UserID: %d, Error: %v\n", userID, err)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/internal/services"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/rueidis"
+	"gopkg.in/telebot.v3"
+)
+
+// ticketStore keeps realtime tickets in memory.
+type ticketStore struct {
+	repository.Auth
+	tickets map[string]entity.Session
+}
+
+func (s *ticketStore) SaveTicket(_ context.Context, ticketHash string, session entity.Session, _ time.Duration) error {
+	s.tickets[ticketHash] = session
+	return nil
+}
+
+func (s *ticketStore) ClaimTicket(_ context.Context, ticketHash string) (entity.Session, error) {
+	session, ok := s.tickets[ticketHash]
+	if !ok {
+		return entity.Session{}, rueidis.Nil
+	}
+	delete(s.tickets, ticketHash)
+	return session, nil
+}
+
+func TestWithTicket(t *testing.T) {
+	auth := services.NewAuthService(&ticketStore{tickets: map[string]entity.Session{}}, "", time.Minute, time.Hour, 0)
+	w := &WebApp{App: &services.App{Auth: auth}}
+
+	ticket, err := auth.IssueTicket(context.Background(), entity.Session{UserID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func(query string) (*httptest.ResponseRecorder, int64) {
+		req := httptest.NewRequest(http.MethodGet, "/events?"+query, nil)
+		rec := httptest.NewRecorder()
+		var userID int64
+		next := func(c echo.Context) error {
+			userID = c.Get("user").(telebot.User).ID
+			return c.NoContent(http.StatusOK)
+		}
+		_ = w.withTicket(next)(echo.New().NewContext(req, rec))
+		return rec, userID
+	}
+
+	if rec, userID := connect("ticket=" + ticket.Ticket); rec.Code != http.StatusOK || userID != 42 {
+		t.Fatalf("first use: status %d, user %d; want 200 for user 42", rec.Code, userID)
+	}
+	if rec, _ := connect("ticket=" + ticket.Ticket); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("second use: status %d, want 401", rec.Code)
+	}
+	// Credentials in the query string are no longer accepted.
+	if rec, _ := connect("auth=tma+user%3D1"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("auth query parameter: status %d, want 401", rec.Code)
+	}
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"fmt"
 	"pipe/internal/config"
+	"pipe/internal/services"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -15,12 +17,59 @@ func (w *WebApp) routes() {
 		AllowCredentials: true,
 	}))
 
+	messageBody := middleware.BodyLimit(messageBodyLimit())
+
 	w.e.GET("/", w.index)
 	w.e.GET("/getMe", w.getMe, w.withAuth)
 	w.e.GET("/getUser/:privateID", w.getUser, w.withAuth)
 	w.e.GET("/getMessages", w.getMessages, w.withAuth)
-	w.e.POST("/sendMessage/:privateID", w.sendMessage, w.withAuth)
+	w.e.GET("/challenge", w.getChallenge, w.withAuth)
+	w.e.POST("/sendMessage/:privateID", w.sendMessage, messageBody, w.withAuth, w.withSendLimit, w.withProofOfWork)
+	w.e.GET("/outbox", w.getOutbox, w.withAuth)
+	w.e.DELETE("/messages", w.deleteMessages, w.withAuth)
+	w.e.DELETE("/messages/:id", w.deleteMessage, w.withAuth)
+	w.e.POST("/messages/read", w.readMessages, w.withAuth)
+	w.e.POST("/messages/:id/read", w.readMessage, w.withAuth)
+	w.e.GET("/messages/:id/challenge", w.getChallenge, w.withAuth, w.withCounterpart(false))
+	w.e.POST("/messages/:id/reply", w.reply(false), messageBody, w.withAuth, w.withCounterpart(false), w.withSendLimit, w.withProofOfWork)
+	w.e.POST("/messages/:id/block", w.blockSender(false), w.withAuth)
+	w.e.POST("/messages/:id/report", w.reportMessage(false), w.withAuth, w.withReportLimit)
+	w.e.POST("/attachments", w.uploadAttachment, w.withAuth, w.withUploadLimit)
+	w.e.GET("/attachments/:id", w.getAttachment, w.withAuth)
+	w.e.GET("/blocks", w.getBlocks, w.withAuth)
+	w.e.DELETE("/blocks/:id", w.deleteBlock, w.withAuth)
+	w.e.GET("/replies", w.getReplies, w.withAuth)
+	w.e.GET("/replies/:id/challenge", w.getChallenge, w.withAuth, w.withCounterpart(true))
+	w.e.POST("/replies/:id/reply", w.reply(true), messageBody, w.withAuth, w.withCounterpart(true), w.withSendLimit, w.withProofOfWork)
+	w.e.POST("/replies/:id/block", w.blockSender(true), w.withAuth)
+	w.e.POST("/replies/:id/report", w.reportMessage(true), w.withAuth, w.withReportLimit)
 	w.e.DELETE("/deleteAccount", w.deleteAccount, w.withAuth)
 	w.e.PATCH("/setPubKey", w.setPubKey, w.withAuth)
+	w.e.PATCH("/setRetention", w.setRetention, w.withAuth)
+	w.e.PATCH("/setInbox", w.setInbox, w.withAuth)
 	w.e.GET("/getUpdates", w.getUpdates, w.withAuth)
+	w.e.POST("/realtime/ticket", w.createTicket, w.withAuth)
+	w.e.GET("/ws", w.ws, w.withTicket)
+	w.e.GET("/events", w.events, w.withTicket)
+	w.e.POST("/rotatePrivateID", w.rotatePrivateID, w.withAuth)
+	w.e.POST("/setPrivateID", w.setPrivateID, w.withAuth)
+	w.e.GET("/aliases", w.getAliases, w.withAuth)
+	w.e.POST("/aliases", w.createAlias, w.withAuth)
+	w.e.PATCH("/aliases/:privateID", w.updateAlias, w.withAuth)
+	w.e.DELETE("/aliases/:privateID", w.deleteAlias, w.withAuth)
+
+	w.e.POST("/auth/session", w.createSession, w.withInitDataAuth)
+	w.e.POST("/auth/refresh", w.refreshSession)
+	w.e.DELETE("/auth/session", w.deleteSession, w.withAuth)
+}
+
+// messageBodyLimit caps the body of requests carrying a message at the
+// largest envelope plus room for the JSON around it and attachment IDs, so
+// oversized bodies are turned away before they are read.
+func messageBodyLimit() string {
+	size := config.AppConfig.MaxMessageSize
+	if size <= 0 {
+		size = services.DefaultMaxMessageSize
+	}
+	return fmt.Sprintf("%dB", size+4096)
 }
@@ -6,30 +6,31 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"gopkg.in/telebot.v3"
 )
 
 // //go:embed static
 // var embededFiles embed.FS
 
 type WebApp struct {
-	addr string
-	App  *services.App
-	e    *echo.Echo
-	bot  *telebot.Bot
+	addr      string
+	App       *services.App
+	e         *echo.Echo
+	validator InitDataValidator
 }
 
 func NewWebApp(
 	addr string,
 	app *services.App,
-	bot *telebot.Bot,
+	validator InitDataValidator,
 ) *WebApp {
 	e := echo.New()
+	// nginx passes the client address in X-Real-IP.
+	e.IPExtractor = echo.ExtractIPFromRealIPHeader()
 	wa := &WebApp{
-		App:  app,
-		e:    e,
-		addr: addr,
-		bot:  bot,
+		App:       app,
+		e:         e,
+		addr:      addr,
+		validator: validator,
 	}
 	wa.routes()
 	// wa.static()
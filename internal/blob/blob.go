@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("blob key is invalid")
+)
+
+// Store keeps the encrypted attachment blobs. It never sees plaintext; the
+// keys to decrypt a blob travel inside the message envelope.
+type Store interface {
+	// Put stores everything read from r under key and returns its size.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the blob stored under key. It returns ErrNotFound if there
+	// is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. Missing blobs are not an error.
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn with the key and last modification time of every blob
+	// until fn returns an error.
+	Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error
+}
+
+var _ Store = &Local{}
+
+// New builds the blob store selected by kind. Only "local", which keeps
+// blobs under dir, exists for now.
+func New(kind, dir string) (Store, error) {
+	switch kind {
+	case "", "local":
+		return NewLocal(dir)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", kind)
+	}
+}
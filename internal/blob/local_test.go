@@ -0,0 +1,110 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPathRejects(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", ".", "..", ".upload-123", "../outside", "a/b", `a\b`, "/etc/passwd"} {
+		if _, err := l.path(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("path(%q) = %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+
+	path, err := l.path("0e5b8e4a-8d3c-11ef-b864-0242ac120002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != l.dir {
+		t.Fatalf("path() = %q, want a file directly in %q", path, l.dir)
+	}
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(filepath.Join(root, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := l.Put(ctx, "../escaped", strings.NewReader("data")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Put() = %v, want %v", err, ErrInvalidKey)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !os.IsNotExist(err) {
+		t.Fatalf("Put() wrote outside the blob directory: %v", err)
+	}
+
+	secret := filepath.Join(root, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, "../secret"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Get() = %v, want %v", err, ErrInvalidKey)
+	}
+	if err := l.Delete(ctx, "../secret"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Delete() = %v, want %v", err, ErrInvalidKey)
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Fatalf("file outside the blob directory is gone: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if n, err := l.Put(ctx, "blob", strings.NewReader("data")); err != nil || n != 4 {
+		t.Fatalf("Put() = %d, %v; want 4", n, err)
+	}
+
+	r, err := l.Get(ctx, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Get() read %q, %v; want %q", data, err, "data")
+	}
+
+	// Uploads still being written are left out of Walk.
+	if err := os.WriteFile(filepath.Join(l.dir, ".upload-1"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	if err := l.Walk(ctx, func(key string, _ time.Time) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "blob" {
+		t.Fatalf("Walk() saw %v, want [blob]", keys)
+	}
+
+	if err := l.Delete(ctx, "blob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete(ctx, "blob"); err != nil {
+		t.Fatalf("deleting a missing blob = %v, want nil", err)
+	}
+	if _, err := l.Get(ctx, "blob"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() after Delete = %v, want %v", err, ErrNotFound)
+	}
+}
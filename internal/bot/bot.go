@@ -3,11 +3,16 @@ package bot
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"pipe/internal/bus"
 	"pipe/internal/config"
+	"pipe/internal/entity"
 	"pipe/internal/services"
+	"strings"
 	"time"
 
+	"github.com/gocql/gocql"
 	"golang.org/x/net/proxy"
 	"gopkg.in/telebot.v3"
 )
@@ -20,7 +25,7 @@ type Telegram struct {
 	cancel context.CancelFunc
 }
 
-func NewTelegram(ctx context.Context, app *services.App) (*Telegram, error) {
+func NewTelegram(ctx context.Context, app *services.App, eventBus bus.Bus) (*Telegram, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	t := &Telegram{
 		App:    app,
@@ -47,6 +52,7 @@ func NewTelegram(ctx context.Context, app *services.App) (*Telegram, error) {
 	t.Bot = bot
 
 	t.setupHandlers()
+	eventBus.Handle(t.notify)
 	return t, nil
 }
 
@@ -74,18 +80,34 @@ func (t *Telegram) setupHandlers() {
 
 	// handlers
 	t.Bot.Handle("/start", t.start)
+	t.Bot.Handle(&banButton, t.banSender)
+	t.Bot.Handle(&dismissButton, t.dismissReport)
+	t.Bot.Handle(&unbanButton, t.unbanSender)
 }
 
 func (t *Telegram) start(c telebot.Context) error {
-	args := c.Message().Payload
+	args := strings.TrimSpace(c.Message().Payload)
 
 	if args != "" {
-		return c.Send(fmt.Sprintf("الان داری به %s پیام میدی", args), &telebot.ReplyMarkup{
+		u, err := t.App.Account.GetUserByPrivateID(args)
+		if err == gocql.ErrNotFound && strings.ToLower(args) != args {
+			// Vanity IDs are stored lowercase; deep links may not be.
+			u, err = t.App.Account.GetUserByPrivateID(strings.ToLower(args))
+		}
+		if err != nil {
+			if err == gocql.ErrNotFound {
+				return c.Send("کاربری با این لینک پیدا نشد.")
+			}
+			log.Printf("Failed to resolve private ID %s: %v\n", args, err)
+			return c.Send("مشکلی پیش اومد، دوباره امتحان کن.")
+		}
+
+		return c.Send(fmt.Sprintf("الان داری به %s پیام میدی", u.PrivateID), &telebot.ReplyMarkup{
 			InlineKeyboard: [][]telebot.InlineButton{
 				{
 					{
 						Text:   "Open",
-						WebApp: &telebot.WebApp{URL: fmt.Sprintf("%s/sendMessage/%s", config.AppConfig.ClientURL, args)},
+						WebApp: &telebot.WebApp{URL: fmt.Sprintf("%s/sendMessage/%s", config.AppConfig.ClientURL, u.PrivateID)},
 					},
 				},
 			},
@@ -111,6 +133,57 @@ Pipe is a Telegram Mini App with E2EE, Users can send hidden message to each oth
 	})
 }
 
+// notify tells the recipient of a new message or reply about it in the bot
+// chat, and the moderators about new reports.
+func (t *Telegram) notify(ctx context.Context, userID int64, event entity.Event) {
+	switch event.Type {
+	case entity.EventReport:
+		t.notifyModerators(event.ReportID)
+		return
+	case entity.EventAccountDeleted:
+		t.notifyAccountDeleted(userID)
+		return
+	}
+
+	if event.Message != nil && event.Message.Muted {
+		return
+	}
+
+	var text string
+	switch event.Type {
+	case entity.EventNewMessage:
+		text = "یه پیام جدید داری 🍕"
+		if unread, err := t.App.Message.UnreadCount(ctx, userID); err == nil && unread > 1 {
+			text = fmt.Sprintf("%d پیام خونده نشده داری 🍕", unread)
+		}
+	case entity.EventNewReply:
+		text = "یه جواب جدید به پیامت داری 🍕"
+	default:
+		return
+	}
+
+	_, err := t.Bot.Send(&telebot.Chat{ID: userID}, text, &telebot.ReplyMarkup{
+		InlineKeyboard: [][]telebot.InlineButton{
+			{
+				{
+					Text:   "Open",
+					WebApp: &telebot.WebApp{URL: config.AppConfig.ClientURL},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to send notification to UserID: %d, Error: %v\n", userID, err)
+	}
+}
+
+func (t *Telegram) notifyAccountDeleted(userID int64) {
+	_, err := t.Bot.Send(&telebot.Chat{ID: userID}, "حساب کاربری شما با موفقیت حذف شد. توجه داشته باشید که اگر دوباره وارد مینی اپ شوید حساب کاربری جدیدی برای شما ساخته می شود.")
+	if err != nil {
+		log.Printf("Failed to send account deletion notification to UserID: %d, Error: %v\n", userID, err)
+	}
+}
+
 func (t *Telegram) Start() {
 	t.Bot.Start()
 }
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"pipe/internal/config"
+	"pipe/internal/services"
+
+	"github.com/gocql/gocql"
+	"gopkg.in/telebot.v3"
+)
+
+var (
+	banButton     = telebot.InlineButton{Unique: "ban", Text: "Ban sender"}
+	dismissButton = telebot.InlineButton{Unique: "dismiss", Text: "Dismiss"}
+	unbanButton   = telebot.InlineButton{Unique: "unban", Text: "Unban sender"}
+)
+
+// notifyModerators posts a new report to the admin chat with buttons to ban
+// the sender or dismiss it. Reports are only stored when no chat is set.
+func (t *Telegram) notifyModerators(reportID string) {
+	if config.AppConfig.AdminChatID == 0 {
+		return
+	}
+
+	id, err := gocql.ParseUUID(reportID)
+	if err != nil {
+		log.Printf("Invalid report ID '%s' in event\n", reportID)
+		return
+	}
+
+	report, err := t.App.Message.GetReport(id)
+	if err != nil {
+		log.Printf("Failed to retrieve report %s, Error: %v\n", reportID, err)
+		return
+	}
+
+	text := fmt.Sprintf("New report %s\nMessage: %s\nReason: %s", report.ID, report.MessageID, report.Reason)
+	if report.Evidence != "" {
+		// The server only has the ciphertext, so it can't check that the
+		// reporter quoted the message faithfully.
+		text += "\n\nEvidence (typed by the reporter, unverified):\n" + report.Evidence
+	} else {
+		text += "\n\nNo evidence: the message is end-to-end encrypted and the reporter didn't share it."
+	}
+
+	ban, dismiss := banButton, dismissButton
+	ban.Data, dismiss.Data = reportID, reportID
+
+	_, err = t.Bot.Send(&telebot.Chat{ID: config.AppConfig.AdminChatID}, text, &telebot.ReplyMarkup{
+		InlineKeyboard: [][]telebot.InlineButton{{ban, dismiss}},
+	})
+	if err != nil {
+		log.Printf("Failed to send report %s to the admin chat, Error: %v\n", reportID, err)
+	}
+}
+
+func (t *Telegram) banSender(c telebot.Context) error {
+	return t.resolveReport(c, "banned", t.App.Message.BanReportedSender)
+}
+
+func (t *Telegram) dismissReport(c telebot.Context) error {
+	return t.resolveReport(c, "dismissed", t.App.Message.DismissReport)
+}
+
+func (t *Telegram) unbanSender(c telebot.Context) error {
+	return t.resolveReport(c, "unbanned", t.App.Message.UnbanReportedSender)
+}
+
+// resolveReport runs a moderator's decision on the report behind the pressed
+// button and records who made it on the admin chat message.
+func (t *Telegram) resolveReport(c telebot.Context, outcome string, resolve func(reportID gocql.UUID, moderator int64) error) error {
+	if c.Chat() == nil || c.Chat().ID != config.AppConfig.AdminChatID || config.AppConfig.AdminChatID == 0 {
+		return c.Respond(&telebot.CallbackResponse{Text: "Not allowed"})
+	}
+
+	reportID, err := gocql.ParseUUID(c.Callback().Data)
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Invalid report"})
+	}
+
+	if err := resolve(reportID, c.Sender().ID); err != nil {
+		switch {
+		case err == gocql.ErrNotFound:
+			return c.Respond(&telebot.CallbackResponse{Text: "Report not found"})
+		case errors.Is(err, services.ErrReportNotPending):
+			return c.Respond(&telebot.CallbackResponse{Text: "Report is already resolved"})
+		case errors.Is(err, services.ErrReportNotBanned):
+			return c.Respond(&telebot.CallbackResponse{Text: "Sender isn't banned for this report"})
+		}
+		log.Printf("Failed to resolve report %s as %s, Error: %v\n", reportID, outcome, err)
+		return c.Respond(&telebot.CallbackResponse{Text: "Something went wrong, try again"})
+	}
+
+	log.Printf("Report %s %s by moderator %d\n", reportID, outcome, c.Sender().ID)
+
+	// A ban can be taken back from the same message.
+	var markup []interface{}
+	if outcome == "banned" {
+		unban := unbanButton
+		unban.Data = reportID.String()
+		markup = append(markup, &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{unban}}})
+	}
+
+	if err := c.Edit(fmt.Sprintf("%s\n\n%s by %s", c.Message().Text, outcome, moderatorName(c.Sender())), markup...); err != nil {
+		log.Printf("Failed to update report %s in the admin chat, Error: %v\n", reportID, err)
+	}
+	return c.Respond(&telebot.CallbackResponse{Text: "Report " + outcome})
+}
+
+func moderatorName(u *telebot.User) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return u.FirstName
+}
@@ -0,0 +1,118 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"pipe/internal/entity"
+	"sync"
+
+	"github.com/redis/rueidis"
+)
+
+// Handler reacts to an event published for userID.
+type Handler func(ctx context.Context, userID int64, event entity.Event)
+
+// Bus carries inbox events between the services that produce them and the
+// parts of the server that react to them.
+//
+// Subscribers receive every event for a user, whichever instance published
+// it; they are wake-up signals, and a slow subscriber may miss some, so the
+// event stream stays the source of truth. Handlers run exactly once per
+// event, on one of the instances that registered any, which suits side
+// effects such as bot notifications.
+type Bus interface {
+	Publish(ctx context.Context, userID int64, event entity.Event) error
+	Subscribe(userID int64) (<-chan entity.Event, func())
+	Handle(handler Handler)
+}
+
+var (
+	_ Bus = &Local{}
+	_ Bus = &Redis{}
+)
+
+// New builds the bus selected by kind ("local" or "redis"). A Redis bus
+// listens for events from other instances until ctx is done.
+func New(ctx context.Context, kind string, client rueidis.Client) (Bus, error) {
+	switch kind {
+	case "local":
+		return NewLocal(), nil
+	case "", "redis":
+		b := NewRedis(client, DefaultChannel, DefaultQueue)
+		go b.Run(ctx)
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown event bus %q", kind)
+	}
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind before
+// new ones are dropped for it.
+const subscriberBuffer = 16
+
+// hub fans events out to the subscribers and handlers of this instance.
+type hub struct {
+	mu       sync.RWMutex
+	subs     map[int64]map[chan entity.Event]struct{}
+	handlers []Handler
+}
+
+func newHub() *hub {
+	return &hub{subs: map[int64]map[chan entity.Event]struct{}{}}
+}
+
+func (h *hub) Subscribe(userID int64) (<-chan entity.Event, func()) {
+	ch := make(chan entity.Event, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[chan entity.Event]struct{}{}
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			delete(h.subs[userID], ch)
+			if len(h.subs[userID]) == 0 {
+				delete(h.subs, userID)
+			}
+		})
+	}
+}
+
+func (h *hub) Handle(handler Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.handlers = append(h.handlers, handler)
+}
+
+func (h *hub) dispatch(userID int64, event entity.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// runHandlers calls the handlers in the background so a slow one can't hold
+// up the publisher.
+func (h *hub) runHandlers(ctx context.Context, userID int64, event entity.Event) {
+	h.mu.RLock()
+	handlers := h.handlers
+	h.mu.RUnlock()
+
+	ctx = context.WithoutCancel(ctx)
+	for _, handler := range handlers {
+		go handler(ctx, userID, event)
+	}
+}
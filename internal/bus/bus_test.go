@@ -0,0 +1,142 @@
+package bus
+
+import (
+	"context"
+	"os"
+	"pipe/internal/entity"
+	"testing"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+func receive(t *testing.T, ch <-chan entity.Event) entity.Event {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return entity.Event{}
+	}
+}
+
+func expectNone(t *testing.T, ch <-chan entity.Event) {
+	t.Helper()
+	select {
+	case event := <-ch:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLocalDeliversToUser(t *testing.T) {
+	b := NewLocal()
+	ctx := context.Background()
+
+	alice, stopAlice := b.Subscribe(1)
+	bob, stopBob := b.Subscribe(2)
+	defer stopBob()
+
+	if err := b.Publish(ctx, 1, entity.Event{ID: "e1", Type: entity.EventNewMessage}); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(t, alice); event.ID != "e1" {
+		t.Fatalf("alice got %+v, want e1", event)
+	}
+	expectNone(t, bob)
+
+	stopAlice()
+	stopAlice()
+	if err := b.Publish(ctx, 1, entity.Event{ID: "e2"}); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, alice)
+}
+
+func TestLocalRunsHandlers(t *testing.T) {
+	b := NewLocal()
+	handled := make(chan int64, 1)
+	b.Handle(func(_ context.Context, userID int64, event entity.Event) {
+		handled <- userID
+	})
+
+	if err := b.Publish(context.Background(), 7, entity.Event{ID: "e1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case userID := <-handled:
+		if userID != 7 {
+			t.Fatalf("handler got user %d, want 7", userID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler didn't run")
+	}
+}
+
+// TestRedisPerUserChannels needs the Redis server in PIPE_TEST_REDIS.
+func TestRedisPerUserChannels(t *testing.T) {
+	addr := os.Getenv("PIPE_TEST_REDIS")
+	if addr == "" {
+		t.Skip("PIPE_TEST_REDIS is not set")
+	}
+	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	prefix := "pipe:test:" + time.Now().Format("150405.000000")
+	queue := prefix + ":queue"
+	defer client.Do(context.Background(), client.B().Del().Key(queue).Build())
+
+	subscriber := NewRedis(client, prefix, queue)
+	publisher := NewRedis(client, prefix, queue)
+	go subscriber.Run(ctx)
+
+	// Give the listener time to connect before subscribing.
+	time.Sleep(200 * time.Millisecond)
+	alice, stop := subscriber.Subscribe(1)
+	defer stop()
+
+	if err := publisher.Publish(ctx, 2, entity.Event{ID: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Publish(ctx, 1, entity.Event{ID: "mine"}); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(t, alice); event.ID != "mine" {
+		t.Fatalf("alice got %+v, want only her own event", event)
+	}
+	expectNone(t, alice)
+
+	handled := make(chan string, 2)
+	subscriber.Handle(func(_ context.Context, _ int64, event entity.Event) {
+		handled <- event.ID
+	})
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case id := <-handled:
+			got[id] = true
+		case <-time.After(10 * time.Second):
+			t.Fatalf("queued events handled: %v, want both", got)
+		}
+	}
+}
+
+func TestJobLeavesOutMessages(t *testing.T) {
+	message := entity.Message{Text: "ciphertext", Muted: true}
+	event := newJob(7, entity.Event{ID: "e1", Type: entity.EventNewMessage, Message: &message}).event()
+	if event.ID != "e1" || event.Type != entity.EventNewMessage || event.Message == nil || !event.Message.Muted || event.Message.Text != "" {
+		t.Fatalf("queued event = %+v, want its type and muted flag without the message", event)
+	}
+
+	report := newJob(0, entity.Event{Type: entity.EventReport, ReportID: "r1"}).event()
+	if report.ReportID != "r1" || report.Message != nil {
+		t.Fatalf("queued report = %+v, want its report ID and no message", report)
+	}
+}
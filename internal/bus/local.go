@@ -0,0 +1,22 @@
+package bus
+
+import (
+	"context"
+	"pipe/internal/entity"
+)
+
+// Local delivers events within a single process. It's enough when only one
+// server instance is running.
+type Local struct {
+	*hub
+}
+
+func NewLocal() *Local {
+	return &Local{hub: newHub()}
+}
+
+func (b *Local) Publish(ctx context.Context, userID int64, event entity.Event) error {
+	b.runHandlers(ctx, userID, event)
+	b.dispatch(userID, event)
+	return nil
+}
@@ -0,0 +1,290 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"pipe/internal/entity"
+	"sync"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// DefaultChannel prefixes the per-user Redis Pub/Sub channels events are
+// exchanged on.
+const DefaultChannel = "pipe:events"
+
+// DefaultQueue is the Redis list events wait on until an instance with
+// handlers, such as the bot, takes them.
+const DefaultQueue = "pipe:queue:events"
+
+// queueMaxLen caps the queue so events don't pile up while no instance runs
+// handlers.
+const queueMaxLen = 10000
+
+// queueTimeout is how long a handler instance blocks waiting for an event
+// before checking whether it should stop.
+const queueTimeout = 5 * time.Second
+
+// resubscribeDelay is how long Run waits before subscribing again after the
+// subscription breaks.
+const resubscribeDelay = time.Second
+
+// notification is the Pub/Sub payload.
+type notification struct {
+	UserID int64        `json:"user_id"`
+	Event  entity.Event `json:"event"`
+}
+
+// job is the queue payload. It holds only what handlers act on, so message
+// contents aren't copied into the queue.
+type job struct {
+	UserID   int64  `json:"user_id"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	ReportID string `json:"report_id,omitempty"`
+	Muted    bool   `json:"muted,omitempty"`
+}
+
+func newJob(userID int64, event entity.Event) job {
+	return job{
+		UserID:   userID,
+		ID:       event.ID,
+		Type:     event.Type,
+		ReportID: event.ReportID,
+		Muted:    event.Message != nil && event.Message.Muted,
+	}
+}
+
+// event rebuilds the event handlers see. A message is only attached to carry
+// Muted.
+func (j job) event() entity.Event {
+	event := entity.Event{ID: j.ID, Type: j.Type, ReportID: j.ReportID}
+	if j.Muted {
+		event.Message = &entity.Message{Muted: true}
+	}
+	return event
+}
+
+// Redis shares events between server instances over Redis Pub/Sub, and
+// queues what handlers need of them on a Redis list for whichever instance
+// runs the handlers, so instances without the bot can still trigger its
+// notifications.
+//
+// Each user has their own channel, and an instance only subscribes to the
+// channels of users with subscribers on it, so instances don't receive every
+// event of every user.
+type Redis struct {
+	*hub
+	client   rueidis.Client
+	channel  string
+	queue    string
+	handling chan struct{}
+	once     sync.Once
+
+	mu       sync.Mutex
+	conn     rueidis.DedicatedClient
+	watching map[int64]int
+}
+
+func NewRedis(client rueidis.Client, channel, queue string) *Redis {
+	return &Redis{
+		hub:      newHub(),
+		client:   client,
+		channel:  channel,
+		queue:    queue,
+		handling: make(chan struct{}),
+		watching: map[int64]int{},
+	}
+}
+
+// Handle registers handler and makes this instance take events off the queue.
+func (b *Redis) Handle(handler Handler) {
+	b.hub.Handle(handler)
+	b.once.Do(func() { close(b.handling) })
+}
+
+func (b *Redis) userChannel(userID int64) string {
+	return fmt.Sprintf("%s:%d", b.channel, userID)
+}
+
+func (b *Redis) Publish(ctx context.Context, userID int64, event entity.Event) error {
+	payload, err := json.Marshal(notification{UserID: userID, Event: event})
+	if err != nil {
+		return fmt.Errorf("failed to serialize notification: %w", err)
+	}
+	queued, err := json.Marshal(newJob(userID, event))
+	if err != nil {
+		return fmt.Errorf("failed to serialize queued event: %w", err)
+	}
+
+	resps := b.client.DoMulti(ctx,
+		b.client.B().Publish().Channel(b.userChannel(userID)).Message(string(payload)).Build(),
+		b.client.B().Lpush().Key(b.queue).Element(string(queued)).Build(),
+		b.client.B().Ltrim().Key(b.queue).Start(0).Stop(queueMaxLen-1).Build(),
+	)
+	for _, resp := range resps {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+
+	// LPUSH replies with the queue's length before it was trimmed.
+	if length, err := resps[1].AsInt64(); err == nil && length > queueMaxLen {
+		log.Printf("Event queue is full, dropped the oldest %d events\n", length-queueMaxLen)
+	}
+	return nil
+}
+
+// Subscribe also subscribes this instance to userID's channel while the
+// user has subscribers here.
+func (b *Redis) Subscribe(userID int64) (<-chan entity.Event, func()) {
+	ch, unsubscribe := b.hub.Subscribe(userID)
+	b.watch(userID)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			unsubscribe()
+			b.unwatch(userID)
+		})
+	}
+}
+
+// watch subscribes to userID's channel for its first local subscriber. It
+// returns once Redis confirmed the subscription, so no event published
+// afterwards is missed.
+func (b *Redis) watch(userID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.watching[userID]++
+	if b.watching[userID] > 1 || b.conn == nil {
+		return
+	}
+
+	cmd := b.conn.B().Subscribe().Channel(b.userChannel(userID)).Build()
+	if err := b.conn.Do(context.Background(), cmd).Error(); err != nil {
+		log.Printf("Failed to subscribe to events of UserID: %d, Error: %v\n", userID, err)
+	}
+}
+
+// unwatch unsubscribes from userID's channel when its last local subscriber
+// leaves.
+func (b *Redis) unwatch(userID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.watching[userID]--
+	if b.watching[userID] > 0 {
+		return
+	}
+	delete(b.watching, userID)
+	if b.conn == nil {
+		return
+	}
+
+	cmd := b.conn.B().Unsubscribe().Channel(b.userChannel(userID)).Build()
+	if err := b.conn.Do(context.Background(), cmd).Error(); err != nil {
+		log.Printf("Failed to unsubscribe from events of UserID: %d, Error: %v\n", userID, err)
+	}
+}
+
+// Run forwards events published by any instance to this instance's
+// subscribers, and to its handlers once it has any, until ctx is done.
+func (b *Redis) Run(ctx context.Context) {
+	go b.consume(ctx)
+	b.listen(ctx)
+}
+
+// listen keeps a dedicated Pub/Sub connection subscribed to the channels of
+// the watched users, reconnecting when it breaks.
+func (b *Redis) listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		conn, release := b.client.Dedicate()
+		lost := conn.SetPubSubHooks(rueidis.PubSubHooks{OnMessage: b.receive})
+
+		b.mu.Lock()
+		b.conn = conn
+		channels := make([]string, 0, len(b.watching))
+		for userID := range b.watching {
+			channels = append(channels, b.userChannel(userID))
+		}
+		var err error
+		if len(channels) > 0 {
+			err = conn.Do(ctx, conn.B().Subscribe().Channel(channels...).Build()).Error()
+		}
+		b.mu.Unlock()
+
+		if err == nil {
+			select {
+			case <-ctx.Done():
+			case err = <-lost:
+			}
+		}
+
+		b.mu.Lock()
+		b.conn = nil
+		b.mu.Unlock()
+		conn.Close()
+		release()
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Event bus subscription lost, Error: %v\n", err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+func (b *Redis) receive(msg rueidis.PubSubMessage) {
+	var n notification
+	if err := json.Unmarshal([]byte(msg.Message), &n); err != nil {
+		log.Printf("Failed to decode event bus notification: %v\n", err)
+		return
+	}
+	b.dispatch(n.UserID, n.Event)
+}
+
+// consume takes events off the queue and runs the handlers on them. Each
+// event is taken by exactly one instance.
+func (b *Redis) consume(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-b.handling:
+	}
+
+	for ctx.Err() == nil {
+		cmd := b.client.B().Brpop().Key(b.queue).Timeout(queueTimeout.Seconds()).Build()
+		popped, err := b.client.Do(ctx, cmd).AsStrSlice()
+		if err != nil {
+			if rueidis.IsRedisNil(err) || ctx.Err() != nil {
+				continue
+			}
+			log.Printf("Failed to read the event queue, Error: %v\n", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(resubscribeDelay):
+			}
+			continue
+		}
+		if len(popped) == 0 {
+			continue
+		}
+
+		// BRPOP replies with the key followed by the element.
+		var j job
+		if err := json.Unmarshal([]byte(popped[len(popped)-1]), &j); err != nil {
+			log.Printf("Failed to decode queued event: %v\n", err)
+			continue
+		}
+		b.runHandlers(ctx, j.UserID, j.event())
+	}
+}
@@ -3,18 +3,69 @@ package config
 import (
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	RedisHost         string
-	CassandraHost     string
-	CassandraKeyspace string
-	Token             string
-	ServerAddr        string
-	ClientURL         string
-	ProxyAddr         string
+	RedisHost          string
+	CassandraHost      string
+	CassandraKeyspace  string
+	Token              string
+	ServerAddr         string
+	ClientURL          string
+	ProxyAddr          string
+	InitDataMaxAge     time.Duration
+	InitDataSingleUse  bool
+	SessionSecret      string
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	PremiumTTL         time.Duration
+	InitDataValidator  string
+	BotID              int64
+	TelegramPublicKey  string
+	PrivateIDLength    int
+	PrivateIDAlphabet  string
+	PrivateIDGrace     time.Duration
+	VanityMinLength    int
+	VanityMaxLength    int
+	VanityAlphabet     string
+	VanityReserved     []string
+	VanityBlocked      []string
+	VanityCooldown     time.Duration
+	MessageTTL         time.Duration
+	EventStreamMaxLen  int64
+	EventStreamTTL     time.Duration
+	EventStreamDevices int64
+	EventStreamIdle    time.Duration
+	EventBus           string
+	SenderTokenKeys    string
+	AdminChatID        int64
+	RateLimitWindow    time.Duration
+	RateLimitSender    int64
+	RateLimitIP        int64
+	RateLimitRecipient int64
+	RateLimitPair      int64
+	RateLimitReport    int64
+	RateLimitUpload    int64
+	PowEnabled         bool
+	PowSecret          string
+	PowDifficulty      int
+	PowMaxDifficulty   int
+	PowChallengeTTL    time.Duration
+	PowVolumeWindow    time.Duration
+	PowVolumeStep      int64
+	MaxMessageSize     int
+	LegacyUntil        time.Time
+	BlobStore          string
+	BlobDir            string
+	MaxAttachmentSize  int64
+	AttachmentQuota    int64
+	AttachmentTTL      time.Duration
+	AttachmentGC       time.Duration
+	ServerRole         string
 }
 
 var AppConfig *Config
@@ -30,13 +81,116 @@ func LoadConfig() {
 
 	viper.AutomaticEnv()
 
+	viper.SetDefault("INIT_DATA_MAX_AGE", "24h")
+	viper.SetDefault("INIT_DATA_SINGLE_USE", false)
+	viper.SetDefault("ACCESS_TOKEN_TTL", "15m")
+	viper.SetDefault("REFRESH_TOKEN_TTL", "720h")
+	viper.SetDefault("PREMIUM_CHECK_TTL", "1h")
+	viper.SetDefault("INIT_DATA_VALIDATOR", "hmac")
+	viper.SetDefault("PRIVATE_ID_LENGTH", 6)
+	viper.SetDefault("PRIVATE_ID_ALPHABET", "abcdefghijklmnopqrstuvwxyz")
+	viper.SetDefault("PRIVATE_ID_GRACE_PERIOD", "72h")
+	viper.SetDefault("VANITY_ID_MIN_LENGTH", 4)
+	viper.SetDefault("VANITY_ID_MAX_LENGTH", 32)
+	viper.SetDefault("VANITY_ID_ALPHABET", "abcdefghijklmnopqrstuvwxyz0123456789_")
+	viper.SetDefault("VANITY_ID_RESERVED", "admin,administrator,support,help,pipe,settings,start,official,telegram")
+	viper.SetDefault("VANITY_ID_COOLDOWN", "168h")
+	viper.SetDefault("MESSAGE_TTL", "30m")
+	viper.SetDefault("EVENT_STREAM_MAX_LEN", 1000)
+	viper.SetDefault("EVENT_STREAM_TTL", "168h")
+	viper.SetDefault("EVENT_STREAM_MAX_DEVICES", 10)
+	viper.SetDefault("EVENT_STREAM_DEVICE_IDLE", "168h")
+	viper.SetDefault("EVENT_BUS", "redis")
+	viper.SetDefault("RATE_LIMIT_WINDOW", "1m")
+	viper.SetDefault("RATE_LIMIT_SENDER", 30)
+	viper.SetDefault("RATE_LIMIT_IP", 60)
+	viper.SetDefault("RATE_LIMIT_RECIPIENT", 120)
+	viper.SetDefault("RATE_LIMIT_PAIR", 10)
+	viper.SetDefault("RATE_LIMIT_REPORT", 5)
+	viper.SetDefault("RATE_LIMIT_UPLOAD", 20)
+	viper.SetDefault("POW_ENABLED", false)
+	viper.SetDefault("POW_DIFFICULTY", 16)
+	viper.SetDefault("POW_MAX_DIFFICULTY", 24)
+	viper.SetDefault("POW_CHALLENGE_TTL", "2m")
+	viper.SetDefault("POW_VOLUME_WINDOW", "10m")
+	viper.SetDefault("POW_VOLUME_STEP", 20)
+	viper.SetDefault("MAX_MESSAGE_SIZE", 16384)
+	viper.SetDefault("LEGACY_ENVELOPES_UNTIL", "")
+	viper.SetDefault("BLOB_STORE", "local")
+	viper.SetDefault("BLOB_DIR", "data/blobs")
+	viper.SetDefault("MAX_ATTACHMENT_SIZE", 10485760)
+	viper.SetDefault("ATTACHMENT_QUOTA", 104857600)
+	viper.SetDefault("ATTACHMENT_UPLOAD_TTL", "1h")
+	viper.SetDefault("ATTACHMENT_GC_INTERVAL", "1h")
+	viper.SetDefault("SERVER_ROLE", "all")
+
 	AppConfig = &Config{
-		RedisHost:         viper.GetString("REDIS_HOST"),
-		CassandraHost:     viper.GetString("CASSANDRA_HOST"),
-		CassandraKeyspace: viper.GetString("CASSANDRA_KEYSPACE"),
-		Token:             viper.GetString("TOKEN"),
-		ServerAddr:        viper.GetString("SERVER_ADDR"),
-		ClientURL:         viper.GetString("CLIENT_URL"),
-		ProxyAddr:         viper.GetString("PROXY_ADDR"),
+		RedisHost:          viper.GetString("REDIS_HOST"),
+		CassandraHost:      viper.GetString("CASSANDRA_HOST"),
+		CassandraKeyspace:  viper.GetString("CASSANDRA_KEYSPACE"),
+		Token:              viper.GetString("TOKEN"),
+		ServerAddr:         viper.GetString("SERVER_ADDR"),
+		ClientURL:          viper.GetString("CLIENT_URL"),
+		ProxyAddr:          viper.GetString("PROXY_ADDR"),
+		InitDataMaxAge:     viper.GetDuration("INIT_DATA_MAX_AGE"),
+		InitDataSingleUse:  viper.GetBool("INIT_DATA_SINGLE_USE"),
+		SessionSecret:      viper.GetString("SESSION_SECRET"),
+		AccessTokenTTL:     viper.GetDuration("ACCESS_TOKEN_TTL"),
+		RefreshTokenTTL:    viper.GetDuration("REFRESH_TOKEN_TTL"),
+		PremiumTTL:         viper.GetDuration("PREMIUM_CHECK_TTL"),
+		InitDataValidator:  viper.GetString("INIT_DATA_VALIDATOR"),
+		BotID:              viper.GetInt64("BOT_ID"),
+		TelegramPublicKey:  viper.GetString("TELEGRAM_PUBLIC_KEY"),
+		PrivateIDLength:    viper.GetInt("PRIVATE_ID_LENGTH"),
+		PrivateIDAlphabet:  viper.GetString("PRIVATE_ID_ALPHABET"),
+		PrivateIDGrace:     viper.GetDuration("PRIVATE_ID_GRACE_PERIOD"),
+		VanityMinLength:    viper.GetInt("VANITY_ID_MIN_LENGTH"),
+		VanityMaxLength:    viper.GetInt("VANITY_ID_MAX_LENGTH"),
+		VanityAlphabet:     viper.GetString("VANITY_ID_ALPHABET"),
+		VanityReserved:     splitList(viper.GetString("VANITY_ID_RESERVED")),
+		VanityBlocked:      splitList(viper.GetString("VANITY_ID_BLOCKED_WORDS")),
+		VanityCooldown:     viper.GetDuration("VANITY_ID_COOLDOWN"),
+		MessageTTL:         viper.GetDuration("MESSAGE_TTL"),
+		EventStreamMaxLen:  viper.GetInt64("EVENT_STREAM_MAX_LEN"),
+		EventStreamTTL:     viper.GetDuration("EVENT_STREAM_TTL"),
+		EventStreamDevices: viper.GetInt64("EVENT_STREAM_MAX_DEVICES"),
+		EventStreamIdle:    viper.GetDuration("EVENT_STREAM_DEVICE_IDLE"),
+		EventBus:           viper.GetString("EVENT_BUS"),
+		SenderTokenKeys:    viper.GetString("SENDER_TOKEN_KEYS"),
+		AdminChatID:        viper.GetInt64("ADMIN_CHAT_ID"),
+		RateLimitWindow:    viper.GetDuration("RATE_LIMIT_WINDOW"),
+		RateLimitSender:    viper.GetInt64("RATE_LIMIT_SENDER"),
+		RateLimitIP:        viper.GetInt64("RATE_LIMIT_IP"),
+		RateLimitRecipient: viper.GetInt64("RATE_LIMIT_RECIPIENT"),
+		RateLimitPair:      viper.GetInt64("RATE_LIMIT_PAIR"),
+		RateLimitReport:    viper.GetInt64("RATE_LIMIT_REPORT"),
+		RateLimitUpload:    viper.GetInt64("RATE_LIMIT_UPLOAD"),
+		PowEnabled:         viper.GetBool("POW_ENABLED"),
+		PowSecret:          viper.GetString("POW_SECRET"),
+		PowDifficulty:      viper.GetInt("POW_DIFFICULTY"),
+		PowMaxDifficulty:   viper.GetInt("POW_MAX_DIFFICULTY"),
+		PowChallengeTTL:    viper.GetDuration("POW_CHALLENGE_TTL"),
+		PowVolumeWindow:    viper.GetDuration("POW_VOLUME_WINDOW"),
+		PowVolumeStep:      viper.GetInt64("POW_VOLUME_STEP"),
+		MaxMessageSize:     viper.GetInt("MAX_MESSAGE_SIZE"),
+		LegacyUntil:        viper.GetTime("LEGACY_ENVELOPES_UNTIL"),
+		BlobStore:          viper.GetString("BLOB_STORE"),
+		BlobDir:            viper.GetString("BLOB_DIR"),
+		MaxAttachmentSize:  viper.GetInt64("MAX_ATTACHMENT_SIZE"),
+		AttachmentQuota:    viper.GetInt64("ATTACHMENT_QUOTA"),
+		AttachmentTTL:      viper.GetDuration("ATTACHMENT_UPLOAD_TTL"),
+		AttachmentGC:       viper.GetDuration("ATTACHMENT_GC_INTERVAL"),
+		ServerRole:         viper.GetString("SERVER_ROLE"),
+	}
+}
+
+// splitList parses a comma separated list, dropping blanks and lowercasing entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
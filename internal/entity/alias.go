@@ -0,0 +1,10 @@
+package entity
+
+import "time"
+
+type Alias struct {
+	PrivateID string    `json:"private_id"`
+	Label     string    `json:"label"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Attachment is an encrypted blob uploaded to go with a message. Until it is
+// sent with one it belongs to the uploader alone and expires on its own.
+type Attachment struct {
+	ID         gocql.UUID  `json:"attachment_id"`
+	Size       int64       `json:"size"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ExpiresAt  int64       `json:"expires_at,omitempty"`
+	OwnerToken string      `json:"-"`
+	ToUser     int64       `json:"-"`
+	MessageID  *gocql.UUID `json:"-"`
+}
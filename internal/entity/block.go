@@ -0,0 +1,23 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Block modes. Messages from blocked senders are refused; messages from
+// muted senders arrive silently.
+const (
+	BlockModeBlock = "block"
+	BlockModeMute  = "mute"
+)
+
+// Block hides a sender from the user who blocked them. The sender is only
+// known through their sender token, which means nothing to the blocker.
+type Block struct {
+	ID          gocql.UUID `json:"block_id"`
+	Mode        string     `json:"mode"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SenderToken string     `json:"-"`
+}
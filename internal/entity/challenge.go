@@ -0,0 +1,20 @@
+package entity
+
+// Challenge is a proof of work a sender solves before messaging an inbox:
+// find a Solution such that SHA-256 of Challenge + ":" + Solution starts with
+// Difficulty zero bits. Required is false while the check is turned off.
+type Challenge struct {
+	Required   bool   `json:"required"`
+	Challenge  string `json:"challenge,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	ExpiresAt  int64  `json:"expires_at,omitempty"`
+}
+
+// ChallengeClaims are signed into a challenge. Binding ties it to one sender
+// and recipient without revealing either.
+type ChallengeClaims struct {
+	Nonce      string `json:"nonce"`
+	Difficulty int    `json:"difficulty"`
+	ExpiresAt  int64  `json:"exp"`
+	Binding    string `json:"bind"`
+}
@@ -0,0 +1,27 @@
+package entity
+
+// Envelope versions. Version 0 is the bare base64 ciphertext clients sent
+// before envelopes existed; later versions are JSON encoded Envelopes.
+const (
+	EnvelopeLegacy = 0
+	EnvelopeV1     = 1
+)
+
+// Envelope algorithms. Each pairs an ephemeral key agreement with AES-256-GCM.
+const (
+	AlgorithmP256AESGCM   = "p256-aes-256-gcm"
+	AlgorithmX25519AESGCM = "x25519-aes-256-gcm"
+)
+
+// Envelope is the encrypted form of a message's text. Binary fields are
+// standard base64. The server checks its shape but can't read it. Clients
+// put a fresh reply key in the plaintext, and the other side encrypts its
+// reply to that key rather than to the sender's account key.
+type Envelope struct {
+	Version      int    `json:"v"`
+	Algorithm    string `json:"alg"`
+	EphemeralKey string `json:"epk"`
+	Nonce        string `json:"iv"`
+	Ciphertext   string `json:"ct"`
+	Tag          string `json:"tag"`
+}
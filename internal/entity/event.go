@@ -0,0 +1,27 @@
+package entity
+
+// Event types delivered to a user's realtime clients.
+const (
+	EventNewMessage     = "new-message"
+	EventNewReply       = "new-reply"
+	EventMessageDeleted = "message-deleted"
+	EventKeyChanged     = "key-changed"
+	EventReport         = "report"
+	EventAccountDeleted = "account-deleted"
+)
+
+// ModeratorsID is the user ID moderation events are published for. No
+// Telegram user has it, so they never reach anyone's clients.
+const ModeratorsID int64 = 0
+
+// Event is something that happened in a user's inbox. StreamID is the
+// event's position in the user's event stream; clients acknowledge it.
+type Event struct {
+	ID        string   `json:"id"`
+	StreamID  string   `json:"stream_id,omitempty"`
+	Type      string   `json:"type"`
+	Message   *Message `json:"message,omitempty"`
+	MessageID string   `json:"message_id,omitempty"`
+	PubKey    string   `json:"pubkey,omitempty"`
+	ReportID  string   `json:"report_id,omitempty"`
+}
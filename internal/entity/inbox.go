@@ -0,0 +1,23 @@
+package entity
+
+// Inbox modes. A paused inbox takes no messages until the pause ends, if it
+// ends at all; the other closed modes only take messages from Telegram
+// Premium users or from accounts old enough.
+const (
+	InboxOpen    = "open"
+	InboxPaused  = "paused"
+	InboxPremium = "premium"
+	InboxMinAge  = "min_age"
+)
+
+// Inbox statuses reported to senders.
+const (
+	InboxStatusOpen   = "open"
+	InboxStatusClosed = "closed"
+)
+
+type InboxRequest struct {
+	Mode        string `json:"mode"`
+	MinAgeDays  int    `json:"min_age_days"`
+	PausedUntil int64  `json:"paused_until"`
+}
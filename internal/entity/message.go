@@ -3,9 +3,32 @@ package entity
 import "github.com/gocql/gocql"
 
 type Message struct {
-	ID       gocql.UUID `json:"message_id"`
-	FromUser int64      `json:"from_user"`
-	ToUser   int64      `json:"to_user"`
-	Text     string     `json:"text"`
-	Date     int64      `json:"date"`
+	ID     gocql.UUID `json:"message_id"`
+	ToUser int64      `json:"to_user"`
+	Text   string     `json:"text"`
+	Date   int64      `json:"date"`
+	Alias  string     `json:"alias,omitempty"`
+	// EnvelopeVersion is the version of the ciphertext envelope in Text.
+	EnvelopeVersion int `json:"envelope_version,omitempty"`
+	// Attachments are the encrypted blobs sent with the message.
+	Attachments []gocql.UUID `json:"attachments,omitempty"`
+	// SenderToken stands in for the sender: an opaque token that's only
+	// meaningful together with the recipient. The sender's ID isn't stored.
+	SenderToken string `json:"-"`
+	// SenderRef is the sender sealed so that only the server can open it,
+	// to route replies.
+	SenderRef string `json:"-"`
+	// InReplyTo is the message this one replies to, if any.
+	InReplyTo *gocql.UUID `json:"in_reply_to,omitempty"`
+	// ReadAt is the unix time the recipient read the message, zero while unread.
+	ReadAt int64 `json:"read_at,omitempty"`
+	// Muted is set on delivery when the recipient muted the sender. It isn't stored.
+	Muted bool `json:"muted,omitempty"`
+	// ExpiresAt is the unix time the message is deleted at, zero if it is kept forever.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
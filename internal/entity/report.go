@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Report statuses. A report stays open until a moderator bans the sender or
+// dismisses it. A ban can be lifted later, which marks the report unbanned.
+const (
+	ReportOpen      = "open"
+	ReportBanned    = "banned"
+	ReportDismissed = "dismissed"
+	ReportUnbanned  = "unbanned"
+)
+
+// Report is a recipient's complaint about a message they received. Evidence
+// is the plaintext the reporter chose to share, since the server only has
+// the ciphertext. The sender stays sealed for the reporter; moderators never
+// learn who they are.
+type Report struct {
+	ID         gocql.UUID `json:"report_id"`
+	MessageID  gocql.UUID `json:"message_id"`
+	Reason     string     `json:"reason"`
+	Evidence   string     `json:"evidence,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReporterID int64      `json:"-"`
+	SenderRef  string     `json:"-"`
+}
@@ -0,0 +1,31 @@
+package entity
+
+import "github.com/gocql/gocql"
+
+// Delivery states of a sent message.
+const (
+	SentStored    = "stored"
+	SentDelivered = "delivered"
+	SentRead      = "read"
+)
+
+// SentMessage is the sender's copy of a message or reply. It never names the
+// recipient beyond the private ID the sender used.
+type SentMessage struct {
+	OutboxToken     string      `json:"-"`
+	ID              gocql.UUID  `json:"message_id"`
+	PrivateID       string      `json:"private_id,omitempty"`
+	InReplyTo       *gocql.UUID `json:"in_reply_to,omitempty"`
+	Text            string      `json:"text"`
+	EnvelopeVersion int         `json:"envelope_version,omitempty"`
+	Date            int64       `json:"date"`
+	ExpiresAt       int64       `json:"expires_at,omitempty"`
+	Status          string      `json:"status"`
+	DeliveredAt     int64       `json:"delivered_at,omitempty"`
+	ReadAt          int64       `json:"read_at,omitempty"`
+}
+
+type SentMessagePage struct {
+	Messages   []SentMessage `json:"messages"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
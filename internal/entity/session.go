@@ -0,0 +1,30 @@
+package entity
+
+type Session struct {
+	ID        string `json:"sid"`
+	UserID    int64  `json:"uid"`
+	Username  string `json:"usr,omitempty"`
+	FirstName string `json:"fn,omitempty"`
+	IsPremium bool   `json:"prm,omitempty"`
+	// PremiumAt is when IsPremium was read from Telegram init data.
+	PremiumAt int64 `json:"pat,omitempty"`
+}
+
+type AccessClaims struct {
+	Session
+	ExpiresAt int64 `json:"exp"`
+}
+
+// Ticket is a short-lived, single-use credential for connections that can't
+// carry an Authorization header, such as browser WebSocket and EventSource.
+type Ticket struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type SessionTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
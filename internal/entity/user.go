@@ -5,8 +5,20 @@ import (
 )
 
 type User struct {
-	ID        int64     `json:"user_id"`
-	PrivateID string    `json:"private_id"`
-	PubKey    string    `json:"pubkey"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                 int64     `json:"user_id"`
+	PrivateID          string    `json:"private_id"`
+	PubKey             string    `json:"pubkey"`
+	CreatedAt          time.Time `json:"created_at"`
+	Retention          int       `json:"retention"`
+	Unread             int64     `json:"unread,omitempty"`
+	InboxMode          string    `json:"inbox_mode,omitempty"`
+	InboxMinAgeDays    int       `json:"inbox_min_age_days,omitempty"`
+	InboxPausedUntil   int64     `json:"inbox_paused_until,omitempty"`
+	InboxStatus        string    `json:"inbox_status,omitempty"`
+	InboxReason        string    `json:"inbox_reason,omitempty"`
+	PrivateIDChangedAt time.Time `json:"-"`
+	VanityChangedAt    time.Time `json:"-"`
+	Retired            bool      `json:"-"`
+	AliasLabel         string    `json:"-"`
+	AliasDisabled      bool      `json:"-"`
 }
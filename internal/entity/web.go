@@ -1,9 +1,43 @@
 package entity
 
+import "github.com/gocql/gocql"
+
 type Text struct {
-	Message string `json:"message"`
+	Message     string       `json:"message"`
+	Attachments []gocql.UUID `json:"attachments"`
 }
 
 type PubKey struct {
 	Value string `json:"pubkey"`
 }
+
+type Retention struct {
+	Value int `json:"retention"`
+}
+
+type RefreshToken struct {
+	Value string `json:"refresh_token"`
+}
+
+type RotatePrivateID struct {
+	KeepOld bool `json:"keep_old"`
+}
+
+type VanityID struct {
+	Value   string `json:"private_id"`
+	KeepOld bool   `json:"keep_old"`
+}
+
+type AliasRequest struct {
+	Label   string `json:"label"`
+	Enabled *bool  `json:"enabled"`
+}
+
+type BlockRequest struct {
+	Mode string `json:"mode"`
+}
+
+type ReportRequest struct {
+	Reason   string `json:"reason"`
+	Evidence string `json:"evidence"`
+}
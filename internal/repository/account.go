@@ -2,7 +2,9 @@ package repository
 
 import (
 	"fmt"
+	"log"
 	"pipe/internal/entity"
+	"time"
 
 	"github.com/gocql/gocql"
 )
@@ -19,68 +21,179 @@ func NewAccountCassandraRepository(session *gocql.Session) *AccountCassandraRepo
 	}
 }
 
+// Save claims user.PrivateID with a lightweight transaction and then creates
+// the user. It returns ErrPrivateIDTaken if the private ID belongs to someone
+// else and ErrUserExists if the user was created concurrently.
 func (r *AccountCassandraRepository) Save(user entity.User) error {
-	batch := r.session.NewBatch(gocql.LoggedBatch)
-	batch.Query(`
-		INSERT INTO users_by_id (user_id, private_id, pubkey, created_at) VALUES (?, ?, ?, ?)`,
+	applied, err := r.session.Query(`
+		INSERT INTO users_by_private_id (user_id, private_id, pubkey, created_at) VALUES (?, ?, ?, ?) IF NOT EXISTS`,
 		user.ID, user.PrivateID, user.PubKey, user.CreatedAt,
-	)
-	batch.Query(`
-		INSERT INTO users_by_private_id (user_id, private_id, pubkey, created_at) VALUES (?, ?, ?, ?)`,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to claim private id: %w", err)
+	}
+	if !applied {
+		return ErrPrivateIDTaken
+	}
+
+	applied, err = r.session.Query(`
+		INSERT INTO users_by_id (user_id, private_id, pubkey, created_at) VALUES (?, ?, ?, ?) IF NOT EXISTS`,
 		user.ID, user.PrivateID, user.PubKey, user.CreatedAt,
-	)
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		if releaseErr := r.releasePrivateID(user); releaseErr != nil {
+			log.Printf("Failed to release private ID %s: %v\n", user.PrivateID, releaseErr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		return ErrUserExists
+	}
 
-	if err := r.session.ExecuteBatch(batch); err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+	return nil
+}
+
+// releasePrivateID frees a private ID claimed by user, leaving it alone if it
+// was claimed by someone else in the meantime. Every write to
+// users_by_private_id is a lightweight transaction; mixing in plain writes
+// would let them race the claims.
+func (r *AccountCassandraRepository) releasePrivateID(user entity.User) error {
+	_, err := r.session.Query(`
+		DELETE FROM users_by_private_id WHERE private_id = ? IF user_id = ?`,
+		user.PrivateID, user.ID,
+	).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// RotatePrivateID moves user to newPrivateID. The old private ID keeps
+// resolving to the user as a retired link for grace, or is removed right away
+// when grace is zero. user.VanityChangedAt is stored as given, so vanity
+// claims stamp it and random rotations carry it over.
+func (r *AccountCassandraRepository) RotatePrivateID(user entity.User, newPrivateID string, grace time.Duration) error {
+	applied, err := r.session.Query(`
+		INSERT INTO users_by_private_id (user_id, private_id, pubkey, created_at) VALUES (?, ?, ?, ?) IF NOT EXISTS`,
+		user.ID, newPrivateID, user.PubKey, user.CreatedAt,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to claim private id: %w", err)
+	}
+	if !applied {
+		return ErrPrivateIDTaken
+	}
+
+	if err := r.session.Query(`
+		UPDATE users_by_id SET private_id = ?, private_id_changed_at = ?, vanity_changed_at = ? WHERE user_id = ?`,
+		newPrivateID, time.Now(), user.VanityChangedAt, user.ID,
+	).Exec(); err != nil {
+		if releaseErr := r.releasePrivateID(entity.User{ID: user.ID, PrivateID: newPrivateID}); releaseErr != nil {
+			log.Printf("Failed to release private ID %s: %v\n", newPrivateID, releaseErr)
+		}
+		return fmt.Errorf("failed to rotate private id: %w", err)
+	}
+
+	// The user already moved to the new ID, so failing to retire the old one
+	// only leaves it resolving until it's cleaned up.
+	if err := r.releasePrivateID(user); err != nil {
+		log.Printf("Failed to release old private ID %s: %v\n", user.PrivateID, err)
+		return nil
+	}
+	if grace > 0 {
+		applied, err := r.session.Query(`
+			INSERT INTO users_by_private_id (user_id, private_id, pubkey, created_at, retired) VALUES (?, ?, ?, ?, true) IF NOT EXISTS USING TTL ?`,
+			user.ID, user.PrivateID, user.PubKey, user.CreatedAt, int(grace.Seconds()),
+		).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			log.Printf("Failed to retire private ID %s: %v\n", user.PrivateID, err)
+		} else if !applied {
+			log.Printf("Private ID %s was claimed before it could be retired\n", user.PrivateID)
+		}
 	}
 
 	return nil
 }
 
 func (r *AccountCassandraRepository) SetPubKey(user entity.User) error {
-	batch := r.session.NewBatch(gocql.LoggedBatch)
-	// batch.Query(`
-	// 	DELETE FROM users_by_private_id WHERE private_id = ?`,
-	// 	user.PrivateID,
-	// )
-	// batch.Query(`
-	// 	INSERT INTO users_by_private_id (user_id, private_id, pubkey, created_at) VALUES (?, ?, ?, ?)`,
-	// 	user.ID, user.PrivateID, user.PubKey, user.CreatedAt,
-	// )
-	batch.Query(`
+	if err := r.session.Query(`
 		UPDATE users_by_id 
 		SET pubkey = ? 
 		WHERE user_id = ?`,
 		user.PubKey, user.ID,
-	)
-	batch.Query(`
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to update user pubkey: %w", err)
+	}
+
+	if _, err := r.session.Query(`
 		UPDATE users_by_private_id 
 		SET pubkey = ? 
-		WHERE private_id = ?`,
-		user.PubKey, user.PrivateID,
-	)
-
-	if err := r.session.ExecuteBatch(batch); err != nil {
+		WHERE private_id = ? IF user_id = ?`,
+		user.PubKey, user.PrivateID, user.ID,
+	).MapScanCAS(map[string]interface{}{}); err != nil {
 		return fmt.Errorf("failed to update user pubkey: %w", err)
 	}
 
 	return nil
 }
 
+func (r *AccountCassandraRepository) SetRetention(user entity.User) error {
+	if err := r.session.Query(`
+		UPDATE users_by_id SET retention = ? WHERE user_id = ?`,
+		user.Retention, user.ID,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to update user retention: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AccountCassandraRepository) SetInbox(user entity.User) error {
+	if err := r.session.Query(`
+		UPDATE users_by_id SET inbox_mode = ?, inbox_min_age_days = ?, inbox_paused_until = ? WHERE user_id = ?`,
+		user.InboxMode, user.InboxMinAgeDays, user.InboxPausedUntil, user.ID,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to update user inbox: %w", err)
+	}
+
+	return nil
+}
+
 func (r *AccountCassandraRepository) Delete(user entity.User) error {
+	aliases, err := r.AliasesByUserID(user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	// Lookup rows are released first so a retry after a failure still finds
+	// the user and its aliases.
+	for _, alias := range aliases {
+		if err := r.releasePrivateID(entity.User{ID: user.ID, PrivateID: alias.PrivateID}); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+	}
+	if err := r.releasePrivateID(user); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
 	batch := r.session.NewBatch(gocql.LoggedBatch)
 	batch.Query(`
-		DELETE FROM users_by_id WHERE user_id = ?`,
+		DELETE FROM aliases_by_user WHERE user_id = ?`,
 		user.ID,
 	)
 	batch.Query(`
-		DELETE FROM users_by_private_id WHERE private_id = ?`,
-		user.PrivateID,
+		DELETE FROM users_by_id WHERE user_id = ?`,
+		user.ID,
 	)
 	batch.Query(`
 	DELETE FROM messages WHERE to_user = ?`,
 		user.ID,
 	)
+	batch.Query(`
+		DELETE FROM replies WHERE to_user = ?`,
+		user.ID,
+	)
+	batch.Query(`
+		DELETE FROM blocks WHERE user_id = ?`,
+		user.ID,
+	)
 
 	if err := r.session.ExecuteBatch(batch); err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"fmt"
+	"pipe/internal/entity"
+)
+
+// CreateAlias claims alias.PrivateID as an extra inbox link of user. It
+// returns ErrPrivateIDTaken if the private ID is already in use.
+func (r *AccountCassandraRepository) CreateAlias(user entity.User, alias entity.Alias) error {
+	applied, err := r.session.Query(`
+		INSERT INTO users_by_private_id (user_id, private_id, pubkey, created_at, label, disabled) VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS`,
+		user.ID, alias.PrivateID, user.PubKey, alias.CreatedAt, alias.Label, !alias.Enabled,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to claim alias: %w", err)
+	}
+	if !applied {
+		return ErrPrivateIDTaken
+	}
+
+	if err := r.session.Query(`
+		INSERT INTO aliases_by_user (user_id, private_id, label, disabled, created_at) VALUES (?, ?, ?, ?, ?)`,
+		user.ID, alias.PrivateID, alias.Label, !alias.Enabled, alias.CreatedAt,
+	).Exec(); err != nil {
+		if releaseErr := r.releasePrivateID(entity.User{ID: user.ID, PrivateID: alias.PrivateID}); releaseErr != nil {
+			return fmt.Errorf("failed to create alias: %w (release failed: %v)", err, releaseErr)
+		}
+		return fmt.Errorf("failed to create alias: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AccountCassandraRepository) AliasesByUserID(userID int64) ([]entity.Alias, error) {
+	aliases := []entity.Alias{}
+	iter := r.session.Query(`SELECT private_id, label, disabled, created_at 
+	FROM aliases_by_user WHERE user_id = ?`, userID).Iter()
+
+	var alias entity.Alias
+	var disabled bool
+	for iter.Scan(&alias.PrivateID, &alias.Label, &disabled, &alias.CreatedAt) {
+		alias.Enabled = !disabled
+		aliases = append(aliases, alias)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+func (r *AccountCassandraRepository) AliasByID(userID int64, privateID string) (entity.Alias, error) {
+	alias := entity.Alias{}
+	var disabled bool
+	err := r.session.Query(`SELECT private_id, label, disabled, created_at 
+	FROM aliases_by_user WHERE user_id = ? AND private_id = ?`, userID, privateID).
+		Scan(&alias.PrivateID, &alias.Label, &disabled, &alias.CreatedAt)
+	if err != nil {
+		return entity.Alias{}, err
+	}
+	alias.Enabled = !disabled
+	return alias, nil
+}
+
+func (r *AccountCassandraRepository) UpdateAlias(userID int64, alias entity.Alias) error {
+	if _, err := r.session.Query(`
+		UPDATE users_by_private_id SET label = ?, disabled = ? WHERE private_id = ? IF user_id = ?`,
+		alias.Label, !alias.Enabled, alias.PrivateID, userID,
+	).MapScanCAS(map[string]interface{}{}); err != nil {
+		return fmt.Errorf("failed to update alias: %w", err)
+	}
+
+	if err := r.session.Query(`
+		UPDATE aliases_by_user SET label = ?, disabled = ? WHERE user_id = ? AND private_id = ?`,
+		alias.Label, !alias.Enabled, userID, alias.PrivateID,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to update alias: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AccountCassandraRepository) DeleteAlias(userID int64, privateID string) error {
+	if err := r.releasePrivateID(entity.User{ID: userID, PrivateID: privateID}); err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+
+	if err := r.session.Query(`
+		DELETE FROM aliases_by_user WHERE user_id = ? AND private_id = ?`,
+		userID, privateID,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+
+	return nil
+}
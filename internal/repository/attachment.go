@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"fmt"
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const insertAttachment = `
+	INSERT INTO attachments (attachment_id, owner_token, to_user, message_id, size, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+func attachmentValues(attachment entity.Attachment, ttl time.Duration) []interface{} {
+	return []interface{}{
+		attachment.ID, attachment.OwnerToken, attachment.ToUser, attachment.MessageID, attachment.Size,
+		attachment.CreatedAt, attachment.ExpiresAt, int(ttl.Seconds()),
+	}
+}
+
+// SaveAttachment stores an uploaded attachment for ttl. Sending it stores it
+// again, along with the message, for as long as the message.
+func (m *MessageCassandraRepository) SaveAttachment(attachment entity.Attachment, ttl time.Duration) error {
+	if err := m.session.Query(insertAttachment, attachmentValues(attachment, ttl)...).Exec(); err != nil {
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) AttachmentByID(attachmentID gocql.UUID) (entity.Attachment, error) {
+	var attachment entity.Attachment
+	if err := m.session.Query(`SELECT attachment_id, owner_token, to_user, message_id, size, created_at, expires_at
+	FROM attachments WHERE attachment_id = ?`, attachmentID).Scan(
+		&attachment.ID, &attachment.OwnerToken, &attachment.ToUser, &attachment.MessageID, &attachment.Size,
+		&attachment.CreatedAt, &attachment.ExpiresAt,
+	); err != nil {
+		return entity.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// ClaimAttachment marks an attachment as sent with messageID, unless another
+// message already took it, and reports whether it did. The claim expires
+// after ttl unless the message is stored.
+func (m *MessageCassandraRepository) ClaimAttachment(attachmentID, messageID gocql.UUID, ttl time.Duration) (bool, error) {
+	applied, err := m.session.Query(`UPDATE attachments USING TTL ? SET message_id = ? WHERE attachment_id = ? IF message_id = null`,
+		int(ttl.Seconds()), messageID, attachmentID,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return false, fmt.Errorf("failed to claim attachment: %w", err)
+	}
+	return applied, nil
+}
+
+// UnclaimAttachment gives up the claim of messageID on an attachment, for a
+// message that failed to send.
+func (m *MessageCassandraRepository) UnclaimAttachment(attachmentID, messageID gocql.UUID) error {
+	if _, err := m.session.Query(`UPDATE attachments SET message_id = null WHERE attachment_id = ? IF message_id = ?`,
+		attachmentID, messageID,
+	).MapScanCAS(map[string]interface{}{}); err != nil {
+		return fmt.Errorf("failed to unclaim attachment: %w", err)
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) DeleteAttachment(attachment entity.Attachment) error {
+	if err := m.session.Query(`DELETE FROM attachments WHERE attachment_id = ?`, attachment.ID).Exec(); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pipe/internal/entity"
+	"strconv"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+var _ Auth = &AuthRedisRepository{}
+
+type AuthRedisRepository struct {
+	client rueidis.Client
+}
+
+func NewAuthRedisRepository(redisClient rueidis.Client) *AuthRedisRepository {
+	return &AuthRedisRepository{client: redisClient}
+}
+
+// ClaimInitData records the init data hash for ttl and reports whether it
+// was seen for the first time.
+func (r *AuthRedisRepository) ClaimInitData(ctx context.Context, hash string, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("initdata:%s", hash)
+	cmd := r.client.B().Set().Key(key).Value("1").Nx().Ex(ttl).Build()
+	if err := r.client.Do(ctx, cmd).Error(); err != nil {
+		if rueidis.IsRedisNil(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// SaveSession stores the session together with the hash of its current
+// refresh token and indexes it under the owning user.
+func (r *AuthRedisRepository) SaveSession(ctx context.Context, session entity.Session, refreshHash string, ttl time.Duration) error {
+	key := sessionKey(session.ID)
+	userKey := userSessionsKey(session.UserID)
+	cmds := rueidis.Commands{
+		r.client.B().Hset().Key(key).FieldValue().
+			FieldValue("user_id", strconv.FormatInt(session.UserID, 10)).
+			FieldValue("username", session.Username).
+			FieldValue("first_name", session.FirstName).
+			FieldValue("is_premium", strconv.FormatBool(session.IsPremium)).
+			FieldValue("premium_at", strconv.FormatInt(session.PremiumAt, 10)).
+			FieldValue("refresh", refreshHash).
+			Build(),
+		r.client.B().Expire().Key(key).Seconds(int64(ttl.Seconds())).Build(),
+		r.client.B().Sadd().Key(userKey).Member(session.ID).Build(),
+		r.client.B().Expire().Key(userKey).Seconds(int64(ttl.Seconds())).Build(),
+	}
+	for _, resp := range r.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return fmt.Errorf("failed to save session: %w", err)
+		}
+	}
+	return nil
+}
+
+// SessionByID returns the session and its refresh token hash. It returns
+// rueidis.Nil when the session does not exist.
+func (r *AuthRedisRepository) SessionByID(ctx context.Context, sessionID string) (entity.Session, string, error) {
+	cmd := r.client.B().Hgetall().Key(sessionKey(sessionID)).Build()
+	fields, err := r.client.Do(ctx, cmd).AsStrMap()
+	if err != nil {
+		return entity.Session{}, "", err
+	}
+	if len(fields) == 0 {
+		return entity.Session{}, "", rueidis.Nil
+	}
+
+	userID, err := strconv.ParseInt(fields["user_id"], 10, 64)
+	if err != nil {
+		return entity.Session{}, "", fmt.Errorf("invalid session user id: %w", err)
+	}
+	isPremium, _ := strconv.ParseBool(fields["is_premium"])
+	premiumAt, _ := strconv.ParseInt(fields["premium_at"], 10, 64)
+
+	session := entity.Session{
+		ID:        sessionID,
+		UserID:    userID,
+		Username:  fields["username"],
+		FirstName: fields["first_name"],
+		IsPremium: isPremium,
+		PremiumAt: premiumAt,
+	}
+	return session, fields["refresh"], nil
+}
+
+// rotateScript replaces the refresh hash of a session only if it still holds
+// the expected one, so a refresh token can be exchanged at most once.
+var rotateScript = rueidis.NewLuaScript(`
+local current = redis.call('HGET', KEYS[1], 'refresh')
+if current ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'refresh', ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return 1
+`)
+
+// RotateRefresh swaps the refresh hash of the session from oldHash to newHash
+// and extends it by ttl. It reports false if the session no longer holds
+// oldHash, because it was revoked or already refreshed.
+func (r *AuthRedisRepository) RotateRefresh(ctx context.Context, session entity.Session, oldHash, newHash string, ttl time.Duration) (bool, error) {
+	keys := []string{sessionKey(session.ID), userSessionsKey(session.UserID)}
+	args := []string{oldHash, newHash, strconv.FormatInt(int64(ttl.Seconds()), 10)}
+	rotated, err := rotateScript.Exec(ctx, r.client, keys, args).AsInt64()
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return rotated == 1, nil
+}
+
+// RevokeSession deletes the session and keeps its ID on the revocation list
+// for ttl, so access tokens issued for it are rejected until they expire.
+func (r *AuthRedisRepository) RevokeSession(ctx context.Context, userID int64, sessionID string, ttl time.Duration) error {
+	cmds := rueidis.Commands{
+		r.client.B().Del().Key(sessionKey(sessionID)).Build(),
+		r.client.B().Srem().Key(userSessionsKey(userID)).Member(sessionID).Build(),
+		r.client.B().Set().Key(revokedSessionKey(sessionID)).Value("1").Ex(ttl).Build(),
+	}
+	for _, resp := range r.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *AuthRedisRepository) SessionIDsByUser(ctx context.Context, userID int64) ([]string, error) {
+	cmd := r.client.B().Smembers().Key(userSessionsKey(userID)).Build()
+	return r.client.Do(ctx, cmd).AsStrSlice()
+}
+
+func (r *AuthRedisRepository) IsSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	cmd := r.client.B().Exists().Key(revokedSessionKey(sessionID)).Build()
+	n, err := r.client.Do(ctx, cmd).AsInt64()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SaveTicket stores the session a realtime ticket stands for until it is
+// claimed or ttl passes.
+func (r *AuthRedisRepository) SaveTicket(ctx context.Context, ticketHash string, session entity.Session, ttl time.Duration) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to serialize ticket: %w", err)
+	}
+	cmd := r.client.B().Set().Key(ticketKey(ticketHash)).Value(string(payload)).Px(ttl).Build()
+	return r.client.Do(ctx, cmd).Error()
+}
+
+// ClaimTicket returns the session of a ticket and deletes it, so each ticket
+// works once. It returns rueidis.Nil for unknown or used tickets.
+func (r *AuthRedisRepository) ClaimTicket(ctx context.Context, ticketHash string) (entity.Session, error) {
+	cmd := r.client.B().Getdel().Key(ticketKey(ticketHash)).Build()
+	payload, err := r.client.Do(ctx, cmd).ToString()
+	if err != nil {
+		return entity.Session{}, err
+	}
+
+	var session entity.Session
+	if err := json.Unmarshal([]byte(payload), &session); err != nil {
+		return entity.Session{}, fmt.Errorf("invalid ticket payload: %w", err)
+	}
+	return session, nil
+}
+
+func sessionKey(sessionID string) string {
+	return fmt.Sprintf("session:%s", sessionID)
+}
+
+func userSessionsKey(userID int64) string {
+	return fmt.Sprintf("user:%d:sessions", userID)
+}
+
+func revokedSessionKey(sessionID string) string {
+	return fmt.Sprintf("session:revoked:%s", sessionID)
+}
+
+func ticketKey(ticketHash string) string {
+	return fmt.Sprintf("ticket:%s", ticketHash)
+}
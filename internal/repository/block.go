@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"fmt"
+	"pipe/internal/entity"
+
+	"github.com/gocql/gocql"
+)
+
+func (m *MessageCassandraRepository) SaveBlock(userID int64, block entity.Block) error {
+	if err := m.session.Query(`
+		INSERT INTO blocks (user_id, sender_token, block_id, mode, created_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, block.SenderToken, block.ID, block.Mode, block.CreatedAt,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to save block: %w", err)
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) BlocksByUserID(userID int64) ([]entity.Block, error) {
+	blocks := []entity.Block{}
+	iter := m.session.Query(`SELECT sender_token, block_id, mode, created_at FROM blocks WHERE user_id = ?`, userID).Iter()
+
+	var block entity.Block
+	for iter.Scan(&block.SenderToken, &block.ID, &block.Mode, &block.CreatedAt) {
+		blocks = append(blocks, block)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// BlocksBySenderTokens returns userID's blocks of any of senderTokens.
+func (m *MessageCassandraRepository) BlocksBySenderTokens(userID int64, senderTokens []string) ([]entity.Block, error) {
+	blocks := []entity.Block{}
+	iter := m.session.Query(`SELECT sender_token, block_id, mode, created_at FROM blocks
+	WHERE user_id = ? AND sender_token IN ?`, userID, senderTokens).Iter()
+
+	var block entity.Block
+	for iter.Scan(&block.SenderToken, &block.ID, &block.Mode, &block.CreatedAt) {
+		blocks = append(blocks, block)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// DeleteBlock removes one of userID's blocks. It returns gocql.ErrNotFound if
+// userID has no block with blockID.
+func (m *MessageCassandraRepository) DeleteBlock(userID int64, blockID gocql.UUID) error {
+	blocks, err := m.BlocksByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		if block.ID != blockID {
+			continue
+		}
+		if err := m.session.Query(`DELETE FROM blocks WHERE user_id = ? AND sender_token = ?`,
+			userID, block.SenderToken,
+		).Exec(); err != nil {
+			return fmt.Errorf("failed to delete block: %w", err)
+		}
+		return nil
+	}
+
+	return gocql.ErrNotFound
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"pipe/internal/entity"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// testCassandra connects to the Cassandra node named by PIPE_TEST_CASSANDRA,
+// whose pipe keyspace has the schema from init.cql, skipping the test when it
+// isn't set. Like the Redis tests, keep rows under testUserID.
+func testCassandra(t *testing.T) *gocql.Session {
+	t.Helper()
+
+	addr := os.Getenv("PIPE_TEST_CASSANDRA")
+	if addr == "" {
+		t.Skip("PIPE_TEST_CASSANDRA is not set")
+	}
+
+	cluster := gocql.NewCluster(addr)
+	cluster.Keyspace = "pipe"
+	session, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("failed to connect to cassandra: %v", err)
+	}
+	t.Cleanup(session.Close)
+	return session
+}
+
+// sendTestMessages stores count messages for userID, a second apart and
+// returned newest first, and deletes them when the test ends.
+func sendTestMessages(t *testing.T, repo *MessageCassandraRepository, userID int64, count int) []entity.Message {
+	t.Helper()
+	outboxToken := fmt.Sprintf("test-%d", userID)
+	t.Cleanup(func() {
+		repo.DeleteAllByUserID(userID)
+		repo.session.Query(`DELETE FROM messages_by_sender WHERE outbox_token = ?`, outboxToken).Exec()
+	})
+
+	now := time.Now().Unix()
+	messages := make([]entity.Message, count)
+	for i := range messages {
+		message := entity.Message{ID: gocql.TimeUUID(), ToUser: userID, Text: "ciphertext", Date: now - int64(i)}
+		sent := entity.SentMessage{ID: message.ID, OutboxToken: outboxToken, Date: message.Date}
+		if err := repo.Send(message, sent, nil, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		messages[i] = message
+	}
+	return messages
+}
+
+func TestByUserIDPages(t *testing.T) {
+	repo := NewMessageCassandraRepository(testCassandra(t))
+	userID := testUserID()
+	want := sendTestMessages(t, repo, userID, 5)
+
+	var (
+		got       []entity.Message
+		pages     int
+		pageState []byte
+	)
+	for {
+		page, next, err := repo.ByUserID(userID, 2, pageState)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("page of %d messages, want at most 2", len(page))
+		}
+		got = append(got, page...)
+		pages++
+		if len(next) == 0 {
+			break
+		}
+		pageState = next
+	}
+
+	if len(got) != len(want) || pages < 3 {
+		t.Fatalf("read %d messages in %d pages, want %d in at least 3", len(got), pages, len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Fatalf("message %d = %s, want %s newest first", i, got[i].ID, want[i].ID)
+		}
+	}
+}
+
+func TestClaimAttachment(t *testing.T) {
+	repo := NewMessageCassandraRepository(testCassandra(t))
+	attachment := entity.Attachment{
+		ID:         gocql.TimeUUID(),
+		OwnerToken: fmt.Sprintf("test-%d", testUserID()),
+		Size:       4,
+		CreatedAt:  time.Now(),
+	}
+	if err := repo.SaveAttachment(attachment, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.DeleteAttachment(attachment) })
+
+	first, second := gocql.TimeUUID(), gocql.TimeUUID()
+	if claimed, err := repo.ClaimAttachment(attachment.ID, first, time.Minute); err != nil || !claimed {
+		t.Fatalf("ClaimAttachment() = %v, %v; want true", claimed, err)
+	}
+	if claimed, err := repo.ClaimAttachment(attachment.ID, second, time.Minute); err != nil || claimed {
+		t.Fatalf("ClaimAttachment() of a claimed attachment = %v, %v; want false", claimed, err)
+	}
+
+	// Only the message holding the claim can give it up.
+	if err := repo.UnclaimAttachment(attachment.ID, second); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := repo.AttachmentByID(attachment.ID); err != nil || stored.MessageID == nil || *stored.MessageID != first {
+		t.Fatalf("AttachmentByID() = %+v, %v; want it claimed by the first message", stored, err)
+	}
+	if err := repo.UnclaimAttachment(attachment.ID, first); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := repo.ClaimAttachment(attachment.ID, second, time.Minute); err != nil || !claimed {
+		t.Fatalf("ClaimAttachment() after unclaiming = %v, %v; want true", claimed, err)
+	}
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// ClaimChallenge marks a proof of work challenge as used. It returns false if
+// the challenge was already claimed. The claim is kept until the challenge
+// expires at expiresAt.
+func (r *RedisRepo) ClaimChallenge(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	key := fmt.Sprintf("pow:used:%s", nonce)
+	cmd := r.client.B().Set().Key(key).Value("1").Nx().Exat(expiresAt).Build()
+	if err := r.client.Do(ctx, cmd).Error(); err != nil {
+		if rueidis.IsRedisNil(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
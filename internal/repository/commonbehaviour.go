@@ -20,7 +20,7 @@ func NewCassandraCommonBehaviour(session *gocql.Session) *CassandraCommonBehavio
 
 func (r *CassandraCommonBehaviour) ByID(ID int64) (entity.User, error) {
 	user := entity.User{}
-	err := r.session.Query("SELECT user_id, private_id, pubkey, created_at FROM users_by_id WHERE user_id = ?", ID).Scan(&user.ID, &user.PrivateID, &user.PubKey, &user.CreatedAt)
+	err := r.session.Query("SELECT user_id, private_id, pubkey, created_at, private_id_changed_at, vanity_changed_at, retention, inbox_mode, inbox_min_age_days, inbox_paused_until FROM users_by_id WHERE user_id = ?", ID).Scan(&user.ID, &user.PrivateID, &user.PubKey, &user.CreatedAt, &user.PrivateIDChangedAt, &user.VanityChangedAt, &user.Retention, &user.InboxMode, &user.InboxMinAgeDays, &user.InboxPausedUntil)
 	if err != nil {
 		return entity.User{}, err
 	}
@@ -29,9 +29,9 @@ func (r *CassandraCommonBehaviour) ByID(ID int64) (entity.User, error) {
 
 func (r *CassandraCommonBehaviour) ByPrivateID(privateID string) (entity.User, error) {
 	user := entity.User{}
-	err := r.session.Query(`SELECT user_id, private_id, pubkey, created_at FROM users_by_private_id WHERE private_id = ?`, privateID).
+	err := r.session.Query(`SELECT user_id, private_id, pubkey, created_at, retired, label, disabled FROM users_by_private_id WHERE private_id = ?`, privateID).
 		Consistency(gocql.One).
-		Scan(&user.ID, &user.PrivateID, &user.PubKey, &user.CreatedAt)
+		Scan(&user.ID, &user.PrivateID, &user.PubKey, &user.CreatedAt, &user.Retired, &user.AliasLabel, &user.AliasDisabled)
 	if err != nil {
 		return entity.User{}, err
 	}
@@ -3,11 +3,17 @@ package repository
 import (
 	"fmt"
 	"pipe/internal/entity"
+	"time"
 
 	"github.com/gocql/gocql"
 )
 
-var _ Message = &MessageCassandraRepository{}
+var (
+	_ Message    = &MessageCassandraRepository{}
+	_ Block      = &MessageCassandraRepository{}
+	_ Moderation = &MessageCassandraRepository{}
+	_ Attachment = &MessageCassandraRepository{}
+)
 
 type MessageCassandraRepository struct {
 	*CassandraCommonBehaviour
@@ -19,12 +25,58 @@ func NewMessageCassandraRepository(session *gocql.Session) *MessageCassandraRepo
 	}
 }
 
-func (m *MessageCassandraRepository) ByUserID(ID int64) ([]entity.Message, error) {
+// ByUserID returns one page of up to limit messages, newest first, starting
+// at pageState. The returned page state is empty on the last page.
+func (m *MessageCassandraRepository) ByUserID(ID int64, limit int, pageState []byte) ([]entity.Message, []byte, error) {
+	messages := []entity.Message{}
+	iter := m.session.Query(`SELECT message_id, text, envelope_version, date, alias, expires_at, sender_token, sender_ref, in_reply_to, read_at, attachments 
+	FROM messages WHERE to_user = ? ORDER BY date DESC`, ID).
+		PageSize(limit).
+		PageState(pageState).
+		Iter()
+	nextPageState := iter.PageState()
+
+	var message entity.Message
+	for iter.Scan(&message.ID, &message.Text, &message.EnvelopeVersion, &message.Date, &message.Alias, &message.ExpiresAt, &message.SenderToken, &message.SenderRef, &message.InReplyTo, &message.ReadAt, &message.Attachments) {
+		messages = append(messages, message)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return messages, nextPageState, nil
+}
+
+// ByID looks up a message in ID's inbox. It returns gocql.ErrNotFound if the
+// message does not exist or belongs to another recipient.
+func (m *MessageCassandraRepository) ByID(ID int64, messageID gocql.UUID) (entity.Message, error) {
+	message := entity.Message{ToUser: ID}
+	err := m.session.Query(`SELECT message_id, text, envelope_version, date, alias, expires_at, sender_token, sender_ref, in_reply_to, read_at, attachments 
+	FROM messages WHERE to_user = ? AND message_id = ? ALLOW FILTERING`, ID, messageID).
+		Scan(&message.ID, &message.Text, &message.EnvelopeVersion, &message.Date, &message.Alias, &message.ExpiresAt, &message.SenderToken, &message.SenderRef, &message.InReplyTo, &message.ReadAt, &message.Attachments)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	return message, nil
+}
+
+// SenderRef returns the sealed sender of the message dated date in ID's
+// inbox, looked up by its full key.
+func (m *MessageCassandraRepository) SenderRef(ID int64, date int64, messageID gocql.UUID) (string, error) {
+	var senderRef string
+	err := m.session.Query(`SELECT sender_ref FROM messages WHERE to_user = ? AND date = ? AND message_id = ?`,
+		ID, date, messageID).Scan(&senderRef)
+	return senderRef, err
+}
+
+// Since returns up to limit of ID's messages dated at or after date, oldest first.
+func (m *MessageCassandraRepository) Since(ID int64, date int64, limit int) ([]entity.Message, error) {
 	messages := []entity.Message{}
-	iter := m.session.Query(`SELECT message_id, text, date 
-	FROM messages WHERE to_user = ? ORDER BY date DESC LIMIT 100`, ID).Iter()
+	iter := m.session.Query(`SELECT message_id, text, envelope_version, date, alias, expires_at, sender_token, sender_ref, in_reply_to, read_at, attachments 
+	FROM messages WHERE to_user = ? AND date >= ? ORDER BY date ASC LIMIT ?`, ID, date, limit).Iter()
+
 	var message entity.Message
-	for iter.Scan(&message.ID, &message.Text, &message.Date) {
+	for iter.Scan(&message.ID, &message.Text, &message.EnvelopeVersion, &message.Date, &message.Alias, &message.ExpiresAt, &message.SenderToken, &message.SenderRef, &message.InReplyTo, &message.ReadAt, &message.Attachments) {
 		messages = append(messages, message)
 	}
 	if err := iter.Close(); err != nil {
@@ -34,6 +86,34 @@ func (m *MessageCassandraRepository) ByUserID(ID int64) ([]entity.Message, error
 	return messages, nil
 }
 
+// MarkRead records when the recipient read message. The message keeps its
+// original expiry.
+func (m *MessageCassandraRepository) MarkRead(message entity.Message, at int64) error {
+	ttl := 0
+	if message.ExpiresAt > 0 {
+		if ttl = int(message.ExpiresAt - time.Now().Unix()); ttl <= 0 {
+			return nil
+		}
+	}
+
+	if _, err := m.session.Query(`UPDATE messages USING TTL ? SET read_at = ? 
+	WHERE to_user = ? AND date = ? AND message_id = ? IF EXISTS`,
+		ttl, at, message.ToUser, message.Date, message.ID,
+	).MapScanCAS(map[string]interface{}{}); err != nil {
+		return fmt.Errorf("failed to mark message read: %w", err)
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) Delete(message entity.Message) error {
+	if err := m.session.Query(`DELETE FROM messages WHERE to_user = ? AND date = ? AND message_id = ?`,
+		message.ToUser, message.Date, message.ID,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
 func (m *MessageCassandraRepository) DeleteAllByUserID(ID int64) error {
 	if err := m.session.Query(`DELETE FROM messages WHERE to_user = ?`, ID).Exec(); err != nil {
 		return fmt.Errorf("failed to delete all message: %w", err)
@@ -41,12 +121,21 @@ func (m *MessageCassandraRepository) DeleteAllByUserID(ID int64) error {
 	return nil
 }
 
-func (m *MessageCassandraRepository) Send(message entity.Message) error {
+// Send stores message, the sender's copy of it and the attachments sent with
+// it for ttl, all or none of them. A zero ttl keeps the message until it is
+// deleted.
+func (m *MessageCassandraRepository) Send(message entity.Message, sent entity.SentMessage, attachments []entity.Attachment, ttl time.Duration) error {
 	batch := m.session.NewBatch(gocql.LoggedBatch)
 	batch.Query(`
-		INSERT INTO messages (message_id, from_user, to_user, text, date) VALUES (?, ?, ?, ?, ?) USING TTL 1800`,
-		message.ID, message.FromUser, message.ToUser, message.Text, message.Date,
+		INSERT INTO messages (message_id, sender_token, sender_ref, in_reply_to, to_user, text, envelope_version, attachments, date, alias, expires_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		message.ID, message.SenderToken, message.SenderRef, message.InReplyTo, message.ToUser, message.Text, message.EnvelopeVersion,
+		message.Attachments, message.Date, message.Alias, message.ExpiresAt, int(ttl.Seconds()),
 	)
+	addSent(batch, sent, ttl)
+	for _, attachment := range attachments {
+		batch.Query(insertAttachment, attachmentValues(attachment, ttl)...)
+	}
 
 	if err := m.session.ExecuteBatch(batch); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
@@ -54,3 +143,49 @@ func (m *MessageCassandraRepository) Send(message entity.Message) error {
 
 	return nil
 }
+
+// SealSenders replaces the plaintext from_user of messages stored before
+// sealed sender with the sender token and sealed reference seal derives,
+// keeping each message's remaining TTL. It returns the number of messages
+// sealed.
+func (m *MessageCassandraRepository) SealSenders(seal func(recipient, sender int64) (string, string, error)) (int, error) {
+	iter := m.session.Query(`SELECT to_user, date, message_id, from_user, TTL(text) FROM messages`).Iter()
+
+	var (
+		sealed       int
+		toUser, date int64
+		messageID    gocql.UUID
+		fromUser     *int64
+		ttl          *int
+	)
+	for iter.Scan(&toUser, &date, &messageID, &fromUser, &ttl) {
+		if fromUser == nil {
+			continue
+		}
+
+		remaining := 0
+		if ttl != nil {
+			remaining = *ttl
+		}
+
+		senderToken, senderRef, err := seal(toUser, *fromUser)
+		if err != nil {
+			iter.Close()
+			return sealed, fmt.Errorf("failed to seal message %s: %w", messageID, err)
+		}
+
+		if err := m.session.Query(`UPDATE messages USING TTL ? SET sender_token = ?, sender_ref = ?, from_user = null 
+		WHERE to_user = ? AND date = ? AND message_id = ?`,
+			remaining, senderToken, senderRef, toUser, date, messageID,
+		).Exec(); err != nil {
+			iter.Close()
+			return sealed, fmt.Errorf("failed to seal message %s: %w", messageID, err)
+		}
+		sealed++
+	}
+	if err := iter.Close(); err != nil {
+		return sealed, err
+	}
+
+	return sealed, nil
+}
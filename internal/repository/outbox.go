@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"fmt"
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// addSent adds the sender's copy of a message to batch, so it is written
+// together with the recipient's copy and expires with it.
+func addSent(batch *gocql.Batch, sent entity.SentMessage, ttl time.Duration) {
+	batch.Query(`
+		INSERT INTO messages_by_sender (outbox_token, message_id, private_id, in_reply_to, text, envelope_version, date, expires_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		sent.OutboxToken, sent.ID, sent.PrivateID, sent.InReplyTo, sent.Text, sent.EnvelopeVersion, sent.Date, sent.ExpiresAt, int(ttl.Seconds()),
+	)
+}
+
+// SentByOutboxTokens returns one page of up to limit sent messages stored
+// under any of tokens, starting at pageState. Each token's messages come
+// newest first; there's more than one token only after a key rotation.
+func (m *MessageCassandraRepository) SentByOutboxTokens(tokens []string, limit int, pageState []byte) ([]entity.SentMessage, []byte, error) {
+	sent := []entity.SentMessage{}
+	iter := m.session.Query(`SELECT outbox_token, message_id, private_id, in_reply_to, text, envelope_version, date, expires_at, delivered_at, read_at 
+	FROM messages_by_sender WHERE outbox_token IN ?`, tokens).
+		PageSize(limit).
+		PageState(pageState).
+		Iter()
+	nextPageState := iter.PageState()
+
+	var message entity.SentMessage
+	for iter.Scan(&message.OutboxToken, &message.ID, &message.PrivateID, &message.InReplyTo, &message.Text,
+		&message.EnvelopeVersion, &message.Date, &message.ExpiresAt, &message.DeliveredAt, &message.ReadAt) {
+		sent = append(sent, message)
+		message = entity.SentMessage{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return sent, nextPageState, nil
+}
+
+// DeleteSentByOutboxTokens removes every sent message stored under tokens.
+func (m *MessageCassandraRepository) DeleteSentByOutboxTokens(tokens []string) error {
+	if err := m.session.Query(`DELETE FROM messages_by_sender WHERE outbox_token IN ?`, tokens).Exec(); err != nil {
+		return fmt.Errorf("failed to delete sent messages: %w", err)
+	}
+	return nil
+}
+
+// MarkSentDelivered records when the sent message was first delivered to
+// one of the recipient's devices. Messages without a sender's copy, such as
+// those sent before the outbox existed, are left alone.
+func (m *MessageCassandraRepository) MarkSentDelivered(sent entity.SentMessage, at int64) error {
+	return m.markSent(sent, "delivered_at", at)
+}
+
+// MarkSentRead records when the recipient first read the sent message.
+func (m *MessageCassandraRepository) MarkSentRead(sent entity.SentMessage, at int64) error {
+	return m.markSent(sent, "read_at", at)
+}
+
+// markSent sets column once, in a single lightweight transaction. Comparing
+// expires_at, which every sender's copy is written with, keeps the update
+// from creating a row for a copy that doesn't exist.
+func (m *MessageCassandraRepository) markSent(sent entity.SentMessage, column string, at int64) error {
+	// Keep the copy expiring together with the recipient's.
+	ttl := 0
+	if sent.ExpiresAt > 0 {
+		if ttl = int(sent.ExpiresAt - time.Now().Unix()); ttl <= 0 {
+			return nil
+		}
+	}
+
+	if _, err := m.session.Query(fmt.Sprintf(`UPDATE messages_by_sender USING TTL ? SET %[1]s = ? 
+	WHERE outbox_token = ? AND date = ? AND message_id = ? IF %[1]s = null AND expires_at = ?`, column),
+		ttl, at, sent.OutboxToken, sent.Date, sent.ID, sent.ExpiresAt,
+	).MapScanCAS(map[string]interface{}{}); err != nil {
+		return fmt.Errorf("failed to update sent message: %w", err)
+	}
+	return nil
+}
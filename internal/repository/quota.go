@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// quotaKeys returns the sorted set of when each of ownerToken's reservations
+// expires and the hash of how many bytes each one holds.
+func quotaKeys(ownerToken string) []string {
+	return []string{
+		fmt.Sprintf("attachments:%s:expiry", ownerToken),
+		fmt.Sprintf("attachments:%s:size", ownerToken),
+	}
+}
+
+// reserveScript drops expired reservations, then reserves as much of the
+// requested size as is left of the quota, counting everything but the
+// attachment's own earlier reservation. It returns the bytes reserved, or 0
+// if the quota is used up, in which case nothing changes.
+var reserveScript = rueidis.NewLuaScript(`
+local now = tonumber(ARGV[1])
+local id = ARGV[2]
+local size = tonumber(ARGV[3])
+local quota = tonumber(ARGV[4])
+local expiresAt = tonumber(ARGV[5])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now)
+for _, old in ipairs(expired) do
+	redis.call('ZREM', KEYS[1], old)
+	redis.call('HDEL', KEYS[2], old)
+end
+local used = 0
+for _, reserved in ipairs(redis.call('HVALS', KEYS[2])) do
+	used = used + tonumber(reserved)
+end
+used = used - (tonumber(redis.call('HGET', KEYS[2], id)) or 0)
+local free = quota - used
+if free <= 0 then
+	return 0
+end
+if size < free then
+	free = size
+end
+redis.call('ZADD', KEYS[1], expiresAt, id)
+redis.call('HSET', KEYS[2], id, free)
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[1], last[2])
+redis.call('PEXPIREAT', KEYS[2], last[2])
+return free
+`)
+
+// ReserveQuota reserves up to size bytes of ownerToken's quota for an upload
+// until expiresAt and returns how many it got, 0 once the quota is used up.
+// Reserving again for the same attachment replaces its reservation, so the
+// final size of an upload can be reserved once it's known.
+func (r *RedisRepo) ReserveQuota(ctx context.Context, ownerToken, attachmentID string, size, quota int64, expiresAt time.Time) (int64, error) {
+	return reserveScript.Exec(ctx, r.client, quotaKeys(ownerToken), []string{
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+		attachmentID,
+		strconv.FormatInt(size, 10),
+		strconv.FormatInt(quota, 10),
+		strconv.FormatInt(expiresAt.UnixMilli(), 10),
+	}).AsInt64()
+}
+
+// ReleaseQuota gives the reservations of attachmentIDs back to ownerToken's
+// quota.
+func (r *RedisRepo) ReleaseQuota(ctx context.Context, ownerToken string, attachmentIDs ...string) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	keys := quotaKeys(ownerToken)
+	for _, resp := range r.client.DoMulti(ctx,
+		r.client.B().Zrem().Key(keys[0]).Member(attachmentIDs...).Build(),
+		r.client.B().Hdel().Key(keys[1]).Field(attachmentIDs...).Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReserveQuota(t *testing.T) {
+	repo := NewRedisRepository(testRedis(t), 100, time.Minute, 10, time.Hour)
+	ctx := context.Background()
+
+	owner := fmt.Sprintf("test-%d", testUserID())
+	t.Cleanup(func() {
+		repo.client.Do(ctx, repo.client.B().Del().Key(quotaKeys(owner)...).Build())
+	})
+	expiresAt := time.Now().Add(time.Minute)
+
+	if got, err := repo.ReserveQuota(ctx, owner, "a", 60, 100, expiresAt); err != nil || got != 60 {
+		t.Fatalf("first reservation = %d, %v; want 60", got, err)
+	}
+	// Only what's left of the quota is reserved.
+	if got, err := repo.ReserveQuota(ctx, owner, "b", 60, 100, expiresAt); err != nil || got != 40 {
+		t.Fatalf("second reservation = %d, %v; want the remaining 40", got, err)
+	}
+	if got, err := repo.ReserveQuota(ctx, owner, "c", 60, 100, expiresAt); err != nil || got != 0 {
+		t.Fatalf("reservation over the quota = %d, %v; want 0", got, err)
+	}
+
+	// Shrinking a reservation to the uploaded size frees the rest.
+	if got, err := repo.ReserveQuota(ctx, owner, "a", 10, 100, expiresAt); err != nil || got != 10 {
+		t.Fatalf("shrunk reservation = %d, %v; want 10", got, err)
+	}
+	if got, err := repo.ReserveQuota(ctx, owner, "c", 60, 100, expiresAt); err != nil || got != 50 {
+		t.Fatalf("reservation after shrinking = %d, %v; want 50", got, err)
+	}
+
+	if err := repo.ReleaseQuota(ctx, owner, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.ReserveQuota(ctx, owner, "d", 60, 100, expiresAt); err != nil || got != 50 {
+		t.Fatalf("reservation after release = %d, %v; want 50", got, err)
+	}
+}
+
+func TestReserveQuotaExpires(t *testing.T) {
+	repo := NewRedisRepository(testRedis(t), 100, time.Minute, 10, time.Hour)
+	ctx := context.Background()
+
+	owner := fmt.Sprintf("test-%d", testUserID())
+	t.Cleanup(func() {
+		repo.client.Do(ctx, repo.client.B().Del().Key(quotaKeys(owner)...).Build())
+	})
+
+	if got, err := repo.ReserveQuota(ctx, owner, "a", 100, 100, time.Now().Add(100*time.Millisecond)); err != nil || got != 100 {
+		t.Fatalf("reservation = %d, %v; want 100", got, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	if got, err := repo.ReserveQuota(ctx, owner, "b", 100, 100, time.Now().Add(time.Minute)); err != nil || got != 100 {
+		t.Fatalf("reservation after expiry = %d, %v; want 100", got, err)
+	}
+}
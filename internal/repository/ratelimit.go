@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// RateLimit allows at most Limit hits on Key per sliding window.
+type RateLimit struct {
+	Key   string
+	Limit int64
+}
+
+// hitScript keeps one sorted set of hit times per key. A hit is only counted
+// when every key is under its limit; otherwise it returns the milliseconds
+// until the most constrained key allows another one.
+var hitScript = rueidis.NewLuaScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local wait = 0
+for i, key in ipairs(KEYS) do
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+	local limit = tonumber(ARGV[3 + i])
+	local count = redis.call('ZCARD', key)
+	if count >= limit then
+		local oldest = redis.call('ZRANGE', key, count - limit, count - limit, 'WITHSCORES')
+		local retry = tonumber(oldest[2]) + window - now
+		if retry < 1 then retry = 1 end
+		if retry > wait then wait = retry end
+	end
+end
+if wait > 0 then
+	return wait
+end
+for _, key in ipairs(KEYS) do
+	redis.call('ZADD', key, now, ARGV[3])
+	redis.call('PEXPIRE', key, window)
+end
+return 0
+`)
+
+// Hit counts a hit against all of limits at once and returns how long to wait
+// before retrying, or zero if the hit was allowed.
+func (r *RedisRepo) Hit(ctx context.Context, limits []RateLimit, window time.Duration) (time.Duration, error) {
+	if len(limits) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now().UnixMilli()
+	member := strconv.FormatInt(now, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	keys := make([]string, 0, len(limits))
+	args := []string{strconv.FormatInt(now, 10), strconv.FormatInt(window.Milliseconds(), 10), member}
+	for _, limit := range limits {
+		keys = append(keys, limit.Key)
+		args = append(args, strconv.FormatInt(limit.Limit, 10))
+	}
+
+	wait, err := hitScript.Exec(ctx, r.client, keys, args).AsInt64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// Hits returns how many hits key got within the last window.
+func (r *RedisRepo) Hits(ctx context.Context, key string, window time.Duration) (int64, error) {
+	since := strconv.FormatInt(time.Now().Add(-window).UnixMilli(), 10)
+	cmd := r.client.B().Zcount().Key(key).Min("(" + since).Max("+inf").Build()
+	return r.client.Do(ctx, cmd).AsInt64()
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHitLimits(t *testing.T) {
+	repo := NewRedisRepository(testRedis(t), 100, time.Minute, 10, time.Hour)
+	ctx := context.Background()
+
+	id := testUserID()
+	sender := RateLimit{Key: fmt.Sprintf("test:ratelimit:%d:sender", id), Limit: 3}
+	pair := RateLimit{Key: fmt.Sprintf("test:ratelimit:%d:pair", id), Limit: 2}
+	t.Cleanup(func() {
+		repo.client.Do(ctx, repo.client.B().Del().Key(sender.Key, pair.Key).Build())
+	})
+
+	for i := 0; i < 2; i++ {
+		wait, err := repo.Hit(ctx, []RateLimit{sender, pair}, time.Minute)
+		if err != nil || wait != 0 {
+			t.Fatalf("hit %d = %v, %v; want allowed", i+1, wait, err)
+		}
+	}
+
+	// The pair is full, so the hit is refused and counted against neither key.
+	wait, err := repo.Hit(ctx, []RateLimit{sender, pair}, time.Minute)
+	if err != nil || wait <= 0 || wait > time.Minute {
+		t.Fatalf("hit over the pair limit = %v, %v; want a wait of at most the window", wait, err)
+	}
+	if hits, err := repo.Hits(ctx, sender.Key, time.Minute); err != nil || hits != 2 {
+		t.Fatalf("sender hits = %d, %v; want 2", hits, err)
+	}
+
+	// The sender alone still has room for one more.
+	if wait, err := repo.Hit(ctx, []RateLimit{sender}, time.Minute); err != nil || wait != 0 {
+		t.Fatalf("sender hit = %v, %v; want allowed", wait, err)
+	}
+	if wait, err := repo.Hit(ctx, []RateLimit{sender}, time.Minute); err != nil || wait <= 0 {
+		t.Fatalf("sender hit over the limit = %v, %v; want a wait", wait, err)
+	}
+}
+
+func TestHitWindowSlides(t *testing.T) {
+	repo := NewRedisRepository(testRedis(t), 100, time.Minute, 10, time.Hour)
+	ctx := context.Background()
+
+	limit := RateLimit{Key: fmt.Sprintf("test:ratelimit:%d:window", testUserID()), Limit: 1}
+	t.Cleanup(func() {
+		repo.client.Do(ctx, repo.client.B().Del().Key(limit.Key).Build())
+	})
+
+	window := 200 * time.Millisecond
+	if wait, err := repo.Hit(ctx, []RateLimit{limit}, window); err != nil || wait != 0 {
+		t.Fatalf("first hit = %v, %v; want allowed", wait, err)
+	}
+	if wait, err := repo.Hit(ctx, []RateLimit{limit}, window); err != nil || wait <= 0 || wait > window {
+		t.Fatalf("second hit = %v, %v; want a wait within the window", wait, err)
+	}
+
+	time.Sleep(window + 50*time.Millisecond)
+	if wait, err := repo.Hit(ctx, []RateLimit{limit}, window); err != nil || wait != 0 {
+		t.Fatalf("hit after the window = %v, %v; want allowed", wait, err)
+	}
+}
+
+func TestClaimChallenge(t *testing.T) {
+	repo := NewRedisRepository(testRedis(t), 100, time.Minute, 10, time.Hour)
+	ctx := context.Background()
+
+	nonce := fmt.Sprintf("test-%d", testUserID())
+	expiresAt := time.Now().Add(time.Minute)
+
+	if claimed, err := repo.ClaimChallenge(ctx, nonce, expiresAt); err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v; want true", claimed, err)
+	}
+	if claimed, err := repo.ClaimChallenge(ctx, nonce, expiresAt); err != nil || claimed {
+		t.Fatalf("second claim = %v, %v; want false", claimed, err)
+	}
+}
@@ -2,45 +2,314 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"pipe/internal/entity"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/redis/rueidis"
 )
 
-var _ RedisRepository = &RedisRepo{}
+var (
+	_ Events          = &RedisRepo{}
+	_ Unread          = &RedisRepo{}
+	_ Challenge       = &RedisRepo{}
+	_ AttachmentQuota = &RedisRepo{}
+)
+
+const (
+	// eventField is the stream entry field holding the serialized event.
+	eventField = "event"
+	// ackBatch caps how many pending entries one ack covers.
+	ackBatch = 1000
+)
 
+// RedisRepo keeps each user's events in a Redis Stream. Every device reads
+// the stream through its own consumer group, so all devices see every event
+// and each tracks its own acknowledged position.
 type RedisRepo struct {
-	client rueidis.Client
+	client     rueidis.Client
+	maxLen     int64
+	ttl        time.Duration
+	maxDevices int64
+	deviceIdle time.Duration
+}
+
+// NewRedisRepository creates a repository whose streams are trimmed to about
+// maxLen entries and expire after ttl without new events. Each stream keeps
+// consumer groups for at most maxDevices devices; groups of devices that
+// haven't read for deviceIdle are removed.
+func NewRedisRepository(redisClient rueidis.Client, maxLen int64, ttl time.Duration, maxDevices int64, deviceIdle time.Duration) *RedisRepo {
+	return &RedisRepo{
+		client:     redisClient,
+		maxLen:     maxLen,
+		ttl:        ttl,
+		maxDevices: maxDevices,
+		deviceIdle: deviceIdle,
+	}
 }
 
-func NewRedisRepository(redisClient rueidis.Client) RedisRepository {
-	return &RedisRepo{client: redisClient}
+func streamKey(userID int64) string {
+	return fmt.Sprintf("user:%d:events", userID)
 }
 
-func (r *RedisRepo) PushMessage(ctx context.Context, userID int64, message string) error {
-	listKey := fmt.Sprintf("user:%d:messages", userID)
-	cmd := r.client.B().Rpush().Key(listKey).Element(message).Build()
-	return r.client.Do(ctx, cmd).Error()
+// devicesKey holds when each device last read the user's stream.
+func devicesKey(userID int64) string {
+	return fmt.Sprintf("user:%d:devices", userID)
+}
+
+func (r *RedisRepo) AppendEvent(ctx context.Context, userID int64, event string) (string, error) {
+	key := streamKey(userID)
+
+	cmds := rueidis.Commands{
+		r.client.B().Xadd().Key(key).Maxlen().Almost().Threshold(strconv.FormatInt(r.maxLen, 10)).
+			Id("*").FieldValue().FieldValue(eventField, event).Build(),
+	}
+	if r.ttl > 0 {
+		cmds = append(cmds, r.client.B().Expire().Key(key).Seconds(int64(r.ttl.Seconds())).Build())
+	}
+
+	results := r.client.DoMulti(ctx, cmds...)
+	for _, result := range results[1:] {
+		if err := result.Error(); err != nil {
+			return "", err
+		}
+	}
+	return results[0].ToString()
 }
 
-func (r *RedisRepo) GetMessages(ctx context.Context, userID, start, stop int64) ([]string, error) {
-	listKey := fmt.Sprintf("user:%d:messages", userID)
-	cmd := r.client.B().Lrange().Key(listKey).Start(start).Stop(stop).Build()
-	messages, err := r.client.Do(ctx, cmd).AsStrSlice()
+// PendingEvents returns up to count events delivered to deviceID but not yet
+// acknowledged, starting after the entry ID after ("0" for the beginning).
+func (r *RedisRepo) PendingEvents(ctx context.Context, userID int64, deviceID, after string, count int64) ([]StreamEntry, error) {
+	entries, err := r.readGroup(ctx, userID, deviceID, after, count, -1)
 	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Entries deleted while pending come back without fields; there's
+	// nothing left to deliver, so they're acknowledged right away.
+	var deleted []string
+	live := entries[:0]
+	for _, entry := range entries {
+		if entry.Event == "" {
+			deleted = append(deleted, entry.ID)
+			continue
+		}
+		live = append(live, entry)
+	}
+	if len(deleted) > 0 {
+		cmd := r.client.B().Xack().Key(streamKey(userID)).Group(deviceID).Id(deleted...).Build()
+		if err := r.client.Do(ctx, cmd).Error(); err != nil {
+			return nil, err
+		}
+		if len(live) == 0 {
+			return r.PendingEvents(ctx, userID, deviceID, deleted[len(deleted)-1], count)
+		}
+	}
+
+	return live, nil
+}
+
+// ReadEvents blocks for up to block until events deviceID hasn't seen yet
+// arrive; a negative block returns right away. It returns rueidis.Nil on
+// timeout.
+func (r *RedisRepo) ReadEvents(ctx context.Context, userID int64, deviceID string, count int64, block time.Duration) ([]StreamEntry, error) {
+	return r.readGroup(ctx, userID, deviceID, ">", count, block)
+}
+
+// readGroup reads the user's stream as deviceID, creating the device's
+// consumer group on first use. A negative block doesn't block.
+func (r *RedisRepo) readGroup(ctx context.Context, userID int64, deviceID, id string, count int64, block time.Duration) ([]StreamEntry, error) {
+	key := streamKey(userID)
+
+	if err := r.touchDevice(ctx, userID, deviceID); err != nil {
 		return nil, err
 	}
 
-	delCmd := r.client.B().Del().Key(listKey).Build()
-	if err := r.client.Do(ctx, delCmd).Error(); err != nil {
+	read := func() (map[string][]rueidis.XRangeEntry, error) {
+		group := r.client.B().Xreadgroup().Group(deviceID, deviceID).Count(count)
+		if block >= 0 {
+			return r.client.Do(ctx, group.Block(block.Milliseconds()).Streams().Key(key).Id(id).Build()).AsXRead()
+		}
+		return r.client.Do(ctx, group.Streams().Key(key).Id(id).Build()).AsXRead()
+	}
+
+	streams, err := read()
+	if err != nil && isNoGroup(err) {
+		if err = r.createGroup(ctx, userID, deviceID); err == nil {
+			streams, err = read()
+		}
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	return messages, nil
+	entries := make([]StreamEntry, 0, len(streams[key]))
+	for _, entry := range streams[key] {
+		entries = append(entries, StreamEntry{ID: entry.ID, Event: entry.FieldValues[eventField]})
+	}
+	return entries, nil
+}
+
+// touchDevice records that deviceID is reading the user's stream, so its
+// consumer group isn't removed as idle.
+func (r *RedisRepo) touchDevice(ctx context.Context, userID int64, deviceID string) error {
+	key := devicesKey(userID)
+	cmds := rueidis.Commands{
+		r.client.B().Zadd().Key(key).ScoreMember().ScoreMember(float64(time.Now().UnixMilli()), deviceID).Build(),
+	}
+	if r.ttl > 0 {
+		cmds = append(cmds, r.client.B().Expire().Key(key).Seconds(int64(r.ttl.Seconds())).Build())
+	}
+	for _, resp := range r.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createGroupScript creates the consumer group of a device after making room
+// for it. Groups the device set doesn't know yet are adopted as idle, groups
+// of idle devices are destroyed, and if the stream still has too many, the
+// least recently used ones go too.
+var createGroupScript = rueidis.NewLuaScript(`
+local now = tonumber(ARGV[2])
+local groups = redis.pcall('XINFO', 'GROUPS', KEYS[1])
+if type(groups) == 'table' and not groups.err then
+	for _, group in ipairs(groups) do
+		if not redis.call('ZSCORE', KEYS[2], group[2]) then
+			redis.call('ZADD', KEYS[2], 0, group[2])
+		end
+	end
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+local stale = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now - tonumber(ARGV[3]))
+for _, device in ipairs(stale) do
+	redis.pcall('XGROUP', 'DESTROY', KEYS[1], device)
+	redis.call('ZREM', KEYS[2], device)
+end
+local excess = redis.call('ZCARD', KEYS[2]) - tonumber(ARGV[4]) + 1
+if excess > 0 then
+	for _, device in ipairs(redis.call('ZRANGE', KEYS[2], 0, excess - 1)) do
+		redis.pcall('XGROUP', 'DESTROY', KEYS[1], device)
+		redis.call('ZREM', KEYS[2], device)
+	end
+end
+local created = redis.pcall('XGROUP', 'CREATE', KEYS[1], ARGV[1], '$', 'MKSTREAM')
+if type(created) == 'table' and created.err and not string.find(created.err, 'BUSYGROUP') then
+	return redis.error_reply(created.err)
+end
+redis.call('ZADD', KEYS[2], now, ARGV[1])
+local ttl = tonumber(ARGV[5])
+if ttl > 0 then
+	if redis.call('TTL', KEYS[1]) < 0 then
+		redis.call('EXPIRE', KEYS[1], ttl)
+	end
+	redis.call('EXPIRE', KEYS[2], ttl)
+end
+return 1
+`)
+
+// createGroup starts deviceID's consumer group at the end of the stream:
+// a new device picks up history from Cassandra, not from the stream.
+func (r *RedisRepo) createGroup(ctx context.Context, userID int64, deviceID string) error {
+	maxDevices := r.maxDevices
+	if maxDevices <= 0 {
+		maxDevices = math.MaxInt32
+	}
+	deviceIdle := r.deviceIdle
+	if deviceIdle <= 0 {
+		deviceIdle = math.MaxInt64
+	}
+
+	keys := []string{streamKey(userID), devicesKey(userID)}
+	args := []string{
+		deviceID,
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+		strconv.FormatInt(deviceIdle.Milliseconds(), 10),
+		strconv.FormatInt(maxDevices, 10),
+		strconv.FormatInt(int64(r.ttl.Seconds()), 10),
+	}
+	return createGroupScript.Exec(ctx, r.client, keys, args).Error()
+}
+
+// AckEvents acknowledges every event delivered to deviceID up to and
+// including the entry ID upTo.
+func (r *RedisRepo) AckEvents(ctx context.Context, userID int64, deviceID, upTo string) error {
+	key := streamKey(userID)
+
+	cmd := r.client.B().Xpending().Key(key).Group(deviceID).Start("-").End(upTo).Count(ackBatch).Build()
+	pending, err := r.client.Do(ctx, cmd).ToArray()
+	if err != nil {
+		if isNoGroup(err) {
+			return nil
+		}
+		return err
+	}
+
+	ids := make([]string, 0, len(pending))
+	for _, entry := range pending {
+		fields, err := entry.ToArray()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		if id, err := fields[0].ToString(); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ackCmd := r.client.B().Xack().Key(key).Group(deviceID).Id(ids...).Build()
+	return r.client.Do(ctx, ackCmd).Error()
+}
+
+// RemoveMessage drops new-message events for messageID from the user's stream.
+func (r *RedisRepo) RemoveMessage(ctx context.Context, userID int64, messageID string) error {
+	key := streamKey(userID)
+
+	cmd := r.client.B().Xrange().Key(key).Start("-").End("+").Build()
+	entries, err := r.client.Do(ctx, cmd).AsXRange()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		var event struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(entry.FieldValues[eventField]), &event); err != nil {
+			continue
+		}
+		if event.Type == entity.EventNewMessage && event.ID == messageID {
+			ids = append(ids, entry.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	delCmd := r.client.B().Xdel().Key(key).Id(ids...).Build()
+	return r.client.Do(ctx, delCmd).Error()
+}
+
+// ClearMessages deletes the user's stream along with every device's position.
+func (r *RedisRepo) ClearMessages(ctx context.Context, userID int64) error {
+	cmd := r.client.B().Del().Key(streamKey(userID), devicesKey(userID)).Build()
+	return r.client.Do(ctx, cmd).Error()
 }
 
-func (r *RedisRepo) WaitForNewMessage(ctx context.Context, userID int64, timeout float64) ([]string, error) {
-	listKey := fmt.Sprintf("user:%d:messages", userID)
-	cmd := r.client.B().Blpop().Key(listKey).Timeout(timeout).Build()
-	return r.client.Do(ctx, cmd).AsStrSlice()
+func isNoGroup(err error) bool {
+	redisErr, ok := rueidis.IsRedisErr(err)
+	return ok && strings.HasPrefix(redisErr.Error(), "NOGROUP")
 }
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// testRedis connects to the Redis server named by PIPE_TEST_REDIS, skipping
+// the test when it isn't set. Every key a test uses should include testUserID
+// so runs don't collide.
+func testRedis(t *testing.T) rueidis.Client {
+	t.Helper()
+
+	addr := os.Getenv("PIPE_TEST_REDIS")
+	if addr == "" {
+		t.Skip("PIPE_TEST_REDIS is not set")
+	}
+
+	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{addr}})
+	if err != nil {
+		t.Fatalf("failed to connect to redis: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+// testUserID returns a user ID no other test run uses.
+func testUserID() int64 {
+	return -time.Now().UnixNano()
+}
+
+func groupNames(t *testing.T, client rueidis.Client, key string) map[string]bool {
+	t.Helper()
+
+	groups, err := client.Do(context.Background(), client.B().XinfoGroups().Key(key).Build()).ToArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, group := range groups {
+		fields, err := group.AsStrMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[fields["name"]] = true
+	}
+	return names
+}
+
+func TestConsumerGroupCap(t *testing.T) {
+	client := testRedis(t)
+	ctx := context.Background()
+	repo := NewRedisRepository(client, 100, time.Minute, 3, time.Hour)
+
+	userID := testUserID()
+	t.Cleanup(func() { repo.ClearMessages(ctx, userID) })
+
+	for i := range 5 {
+		if _, err := repo.PendingEvents(ctx, userID, fmt.Sprintf("device-%d", i), "0", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups := groupNames(t, client, streamKey(userID))
+	if len(groups) != 3 {
+		t.Fatalf("stream has %d consumer groups, want 3: %v", len(groups), groups)
+	}
+	for _, device := range []string{"device-2", "device-3", "device-4"} {
+		if !groups[device] {
+			t.Fatalf("recently used %s lost its group: %v", device, groups)
+		}
+	}
+}
+
+func TestConsumerGroupIdle(t *testing.T) {
+	client := testRedis(t)
+	ctx := context.Background()
+	repo := NewRedisRepository(client, 100, time.Minute, 10, time.Hour)
+
+	userID := testUserID()
+	t.Cleanup(func() { repo.ClearMessages(ctx, userID) })
+
+	if _, err := repo.PendingEvents(ctx, userID, "old", "0", 10); err != nil {
+		t.Fatal(err)
+	}
+	// Pretend the device last read two hours ago.
+	stale := float64(time.Now().Add(-2 * time.Hour).UnixMilli())
+	cmd := client.B().Zadd().Key(devicesKey(userID)).ScoreMember().ScoreMember(stale, "old").Build()
+	if err := client.Do(ctx, cmd).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.PendingEvents(ctx, userID, "new", "0", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := groupNames(t, client, streamKey(userID))
+	if groups["old"] || !groups["new"] {
+		t.Fatalf("groups = %v, want only the active device", groups)
+	}
+}
+
+func TestConsumerGroupAdoptsUntracked(t *testing.T) {
+	client := testRedis(t)
+	ctx := context.Background()
+	repo := NewRedisRepository(client, 100, time.Minute, 2, time.Hour)
+
+	userID := testUserID()
+	t.Cleanup(func() { repo.ClearMessages(ctx, userID) })
+
+	// A group created before devices were tracked.
+	cmd := client.B().XgroupCreate().Key(streamKey(userID)).Group("legacy").Id("$").Mkstream().Build()
+	if err := client.Do(ctx, cmd).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, device := range []string{"a", "b"} {
+		if _, err := repo.PendingEvents(ctx, userID, device, "0", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups := groupNames(t, client, streamKey(userID))
+	if groups["legacy"] || !groups["a"] || !groups["b"] {
+		t.Fatalf("groups = %v, want the untracked group evicted first", groups)
+	}
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"fmt"
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// RepliesByUserID returns one page of up to limit replies to messages ID
+// sent, newest first, starting at pageState. The returned page state is empty
+// on the last page.
+func (m *MessageCassandraRepository) RepliesByUserID(ID int64, limit int, pageState []byte) ([]entity.Message, []byte, error) {
+	replies := []entity.Message{}
+	iter := m.session.Query(`SELECT reply_id, text, envelope_version, date, expires_at, sender_token, sender_ref, in_reply_to 
+	FROM replies WHERE to_user = ? ORDER BY date DESC`, ID).
+		PageSize(limit).
+		PageState(pageState).
+		Iter()
+	nextPageState := iter.PageState()
+
+	var reply entity.Message
+	for iter.Scan(&reply.ID, &reply.Text, &reply.EnvelopeVersion, &reply.Date, &reply.ExpiresAt, &reply.SenderToken, &reply.SenderRef, &reply.InReplyTo) {
+		replies = append(replies, reply)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return replies, nextPageState, nil
+}
+
+// ReplyByID looks up a reply sent to ID. It returns gocql.ErrNotFound if the
+// reply does not exist or belongs to another user.
+func (m *MessageCassandraRepository) ReplyByID(ID int64, replyID gocql.UUID) (entity.Message, error) {
+	reply := entity.Message{ToUser: ID}
+	err := m.session.Query(`SELECT reply_id, text, envelope_version, date, expires_at, sender_token, sender_ref, in_reply_to 
+	FROM replies WHERE to_user = ? AND reply_id = ? ALLOW FILTERING`, ID, replyID).
+		Scan(&reply.ID, &reply.Text, &reply.EnvelopeVersion, &reply.Date, &reply.ExpiresAt, &reply.SenderToken, &reply.SenderRef, &reply.InReplyTo)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	return reply, nil
+}
+
+// ReplySenderRef returns the sealed sender of the reply dated date that ID
+// received, looked up by its full key.
+func (m *MessageCassandraRepository) ReplySenderRef(ID int64, date int64, replyID gocql.UUID) (string, error) {
+	var senderRef string
+	err := m.session.Query(`SELECT sender_ref FROM replies WHERE to_user = ? AND date = ? AND reply_id = ?`,
+		ID, date, replyID).Scan(&senderRef)
+	return senderRef, err
+}
+
+// SendReply stores reply, and the replier's copy of it, for ttl. A zero ttl
+// keeps the reply until it is deleted.
+func (m *MessageCassandraRepository) SendReply(reply entity.Message, sent entity.SentMessage, ttl time.Duration) error {
+	batch := m.session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`
+		INSERT INTO replies (reply_id, sender_token, sender_ref, in_reply_to, to_user, text, envelope_version, date, expires_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		reply.ID, reply.SenderToken, reply.SenderRef, reply.InReplyTo, reply.ToUser, reply.Text, reply.EnvelopeVersion, reply.Date,
+		reply.ExpiresAt, int(ttl.Seconds()),
+	)
+	addSent(batch, sent, ttl)
+
+	if err := m.session.ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+	return nil
+}
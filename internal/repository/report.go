@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// SaveReport stores report unless its reporter already reported the same
+// message, in which case it returns ErrReportExists.
+func (m *MessageCassandraRepository) SaveReport(report entity.Report) error {
+	applied, err := m.session.Query(`
+		INSERT INTO reports_by_reporter (reporter_id, message_id, report_id) VALUES (?, ?, ?) IF NOT EXISTS`,
+		report.ReporterID, report.MessageID, report.ID,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to claim report: %w", err)
+	}
+	if !applied {
+		return ErrReportExists
+	}
+
+	if err := m.session.Query(`
+		INSERT INTO reports (report_id, message_id, reporter_id, sender_ref, reason, evidence, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		report.ID, report.MessageID, report.ReporterID, report.SenderRef, report.Reason, report.Evidence, report.Status, report.CreatedAt,
+	).Exec(); err != nil {
+		// Let the reporter try again.
+		if releaseErr := m.session.Query(`DELETE FROM reports_by_reporter WHERE reporter_id = ? AND message_id = ?`,
+			report.ReporterID, report.MessageID,
+		).Exec(); releaseErr != nil {
+			log.Printf("Failed to release report claim %s, Error: %v\n", report.ID, releaseErr)
+		}
+		return fmt.Errorf("failed to save report: %w", err)
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) ReportByID(reportID gocql.UUID) (entity.Report, error) {
+	var report entity.Report
+	if err := m.session.Query(`SELECT report_id, message_id, reporter_id, sender_ref, reason, evidence, status, created_at
+	FROM reports WHERE report_id = ?`, reportID).Scan(
+		&report.ID, &report.MessageID, &report.ReporterID, &report.SenderRef, &report.Reason, &report.Evidence, &report.Status, &report.CreatedAt,
+	); err != nil {
+		return entity.Report{}, err
+	}
+	return report, nil
+}
+
+// ResolveReport moves a report from status from to status to. It returns
+// ErrReportResolved if another moderator got to it first.
+func (m *MessageCassandraRepository) ResolveReport(reportID gocql.UUID, from, to string, moderator int64, at time.Time) error {
+	applied, err := m.session.Query(`UPDATE reports SET status = ?, resolved_by = ?, resolved_at = ?
+	WHERE report_id = ? IF status = ?`,
+		to, moderator, at, reportID, from,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to resolve report: %w", err)
+	}
+	if !applied {
+		return ErrReportResolved
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) Ban(userID int64, reportID gocql.UUID, moderator int64, at time.Time) error {
+	if err := m.session.Query(`INSERT INTO banned_users (user_id, report_id, banned_by, banned_at) VALUES (?, ?, ?, ?)`,
+		userID, reportID, moderator, at,
+	).Exec(); err != nil {
+		return fmt.Errorf("failed to ban user: %w", err)
+	}
+	return nil
+}
+
+// Unban lifts userID's ban. Lifting a ban that isn't there does nothing.
+func (m *MessageCassandraRepository) Unban(userID int64) error {
+	if err := m.session.Query(`DELETE FROM banned_users WHERE user_id = ?`, userID).Exec(); err != nil {
+		return fmt.Errorf("failed to unban user: %w", err)
+	}
+	return nil
+}
+
+func (m *MessageCassandraRepository) IsBanned(userID int64) (bool, error) {
+	var bannedID int64
+	err := m.session.Query(`SELECT user_id FROM banned_users WHERE user_id = ?`, userID).Scan(&bannedID)
+	if err == gocql.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
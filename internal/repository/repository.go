@@ -2,7 +2,18 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrPrivateIDTaken = errors.New("private id is already taken")
+	ErrUserExists     = errors.New("user already exists")
+	ErrReportResolved = errors.New("report is already resolved")
+	ErrReportExists   = errors.New("message is already reported by this reporter")
 )
 
 type CommonBehaviourRepository interface {
@@ -15,16 +26,104 @@ type Account interface {
 	Save(user entity.User) error
 	Delete(user entity.User) error
 	SetPubKey(user entity.User) error
+	SetRetention(user entity.User) error
+	SetInbox(user entity.User) error
+	RotatePrivateID(user entity.User, newPrivateID string, grace time.Duration) error
+	CreateAlias(user entity.User, alias entity.Alias) error
+	AliasesByUserID(userID int64) ([]entity.Alias, error)
+	AliasByID(userID int64, privateID string) (entity.Alias, error)
+	UpdateAlias(userID int64, alias entity.Alias) error
+	DeleteAlias(userID int64, privateID string) error
 }
 
 type Message interface {
-	ByUserID(ID int64) ([]entity.Message, error)
+	ByUserID(ID int64, limit int, pageState []byte) ([]entity.Message, []byte, error)
+	ByID(ID int64, messageID gocql.UUID) (entity.Message, error)
+	SenderRef(ID int64, date int64, messageID gocql.UUID) (string, error)
+	Since(ID int64, date int64, limit int) ([]entity.Message, error)
+	Delete(message entity.Message) error
 	DeleteAllByUserID(ID int64) error
-	Send(message entity.Message) error
+	Send(message entity.Message, sent entity.SentMessage, attachments []entity.Attachment, ttl time.Duration) error
+	RepliesByUserID(ID int64, limit int, pageState []byte) ([]entity.Message, []byte, error)
+	ReplyByID(ID int64, replyID gocql.UUID) (entity.Message, error)
+	ReplySenderRef(ID int64, date int64, replyID gocql.UUID) (string, error)
+	SendReply(reply entity.Message, sent entity.SentMessage, ttl time.Duration) error
+	SentByOutboxTokens(tokens []string, limit int, pageState []byte) ([]entity.SentMessage, []byte, error)
+	DeleteSentByOutboxTokens(tokens []string) error
+	MarkRead(message entity.Message, at int64) error
+	MarkSentDelivered(sent entity.SentMessage, at int64) error
+	MarkSentRead(sent entity.SentMessage, at int64) error
+}
+
+type Block interface {
+	SaveBlock(userID int64, block entity.Block) error
+	BlocksByUserID(userID int64) ([]entity.Block, error)
+	BlocksBySenderTokens(userID int64, senderTokens []string) ([]entity.Block, error)
+	DeleteBlock(userID int64, blockID gocql.UUID) error
+}
+
+type Moderation interface {
+	SaveReport(report entity.Report) error
+	ReportByID(reportID gocql.UUID) (entity.Report, error)
+	ResolveReport(reportID gocql.UUID, from, to string, moderator int64, at time.Time) error
+	Ban(userID int64, reportID gocql.UUID, moderator int64, at time.Time) error
+	Unban(userID int64) error
+	IsBanned(userID int64) (bool, error)
+}
+
+type Attachment interface {
+	SaveAttachment(attachment entity.Attachment, ttl time.Duration) error
+	AttachmentByID(attachmentID gocql.UUID) (entity.Attachment, error)
+	ClaimAttachment(attachmentID, messageID gocql.UUID, ttl time.Duration) (bool, error)
+	UnclaimAttachment(attachmentID, messageID gocql.UUID) error
+	DeleteAttachment(attachment entity.Attachment) error
+}
+
+type AttachmentQuota interface {
+	ReserveQuota(ctx context.Context, ownerToken, attachmentID string, size, quota int64, expiresAt time.Time) (int64, error)
+	ReleaseQuota(ctx context.Context, ownerToken string, attachmentIDs ...string) error
+}
+
+// StreamEntry is a serialized event read from a user's event stream.
+type StreamEntry struct {
+	ID    string
+	Event string
+}
+
+type Events interface {
+	AppendEvent(ctx context.Context, userID int64, event string) (string, error)
+	PendingEvents(ctx context.Context, userID int64, deviceID, after string, count int64) ([]StreamEntry, error)
+	ReadEvents(ctx context.Context, userID int64, deviceID string, count int64, block time.Duration) ([]StreamEntry, error)
+	AckEvents(ctx context.Context, userID int64, deviceID, upTo string) error
+	RemoveMessage(ctx context.Context, userID int64, messageID string) error
+	ClearMessages(ctx context.Context, userID int64) error
+}
+
+type Unread interface {
+	AddUnread(ctx context.Context, userID int64, messageID string, expiresAt int64) error
+	RemoveUnread(ctx context.Context, userID int64, messageIDs ...string) error
+	ClearUnread(ctx context.Context, userID int64) error
+	UnreadCount(ctx context.Context, userID int64) (int64, error)
+}
+
+type Limiter interface {
+	Hit(ctx context.Context, limits []RateLimit, window time.Duration) (time.Duration, error)
+	Hits(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+type Challenge interface {
+	Limiter
+	ClaimChallenge(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
 }
 
-type RedisRepository interface {
-	PushMessage(ctx context.Context, userID int64, message string) error
-	GetMessages(ctx context.Context, userID, start, stop int64) ([]string, error)
-	WaitForNewMessage(ctx context.Context, userID int64, timeout float64) ([]string, error)
+type Auth interface {
+	ClaimInitData(ctx context.Context, hash string, ttl time.Duration) (bool, error)
+	SaveSession(ctx context.Context, session entity.Session, refreshHash string, ttl time.Duration) error
+	SessionByID(ctx context.Context, sessionID string) (entity.Session, string, error)
+	RotateRefresh(ctx context.Context, session entity.Session, oldHash, newHash string, ttl time.Duration) (bool, error)
+	RevokeSession(ctx context.Context, userID int64, sessionID string, ttl time.Duration) error
+	SessionIDsByUser(ctx context.Context, userID int64) ([]string, error)
+	IsSessionRevoked(ctx context.Context, sessionID string) (bool, error)
+	SaveTicket(ctx context.Context, ticketHash string, session entity.Session, ttl time.Duration) error
+	ClaimTicket(ctx context.Context, ticketHash string) (entity.Session, error)
 }
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The unread counter is a sorted set of unread message IDs scored by the
+// unix time they expire at, so messages that expire unread stop counting on
+// their own.
+
+func unreadKey(userID int64) string {
+	return fmt.Sprintf("user:%d:unread", userID)
+}
+
+// AddUnread counts messageID as unread until expiresAt, or forever if expiresAt is zero.
+func (r *RedisRepo) AddUnread(ctx context.Context, userID int64, messageID string, expiresAt int64) error {
+	score := math.Inf(1)
+	if expiresAt > 0 {
+		score = float64(expiresAt)
+	}
+
+	key := unreadKey(userID)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, result := range r.client.DoMulti(ctx,
+		r.client.B().Zadd().Key(key).ScoreMember().ScoreMember(score, messageID).Build(),
+		r.client.B().Zremrangebyscore().Key(key).Min("-inf").Max(now).Build(),
+	) {
+		if err := result.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RedisRepo) RemoveUnread(ctx context.Context, userID int64, messageIDs ...string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	cmd := r.client.B().Zrem().Key(unreadKey(userID)).Member(messageIDs...).Build()
+	return r.client.Do(ctx, cmd).Error()
+}
+
+func (r *RedisRepo) ClearUnread(ctx context.Context, userID int64) error {
+	cmd := r.client.B().Del().Key(unreadKey(userID)).Build()
+	return r.client.Do(ctx, cmd).Error()
+}
+
+func (r *RedisRepo) UnreadCount(ctx context.Context, userID int64) (int64, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	cmd := r.client.B().Zcount().Key(unreadKey(userID)).Min("(" + now).Max("+inf").Build()
+	return r.client.Do(ctx, cmd).AsInt64()
+}
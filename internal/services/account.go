@@ -1,28 +1,124 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"pipe/internal/entity"
 	"pipe/internal/repository"
+	"pipe/pkg/utils"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrPrivateIDExhausted = errors.New("could not allocate a free private id")
+	ErrRetentionInvalid   = errors.New("retention is not one of the supported values")
 )
 
+// privateIDAttempts bounds how many random private IDs are tried before giving up.
+const privateIDAttempts = 5
+
+type AccountOptions struct {
+	PrivateIDLength   int
+	PrivateIDAlphabet string
+	// PrivateIDGracePeriod is how long a rotated private ID keeps delivering
+	// to its owner when the old link is kept.
+	PrivateIDGracePeriod time.Duration
+	Vanity               VanityOptions
+}
+
 type AccountService struct {
 	repo repository.Account
+	opts AccountOptions
 }
 
-func NewAccountService(repo repository.Account) *AccountService {
-	return &AccountService{repo: repo}
+func NewAccountService(repo repository.Account, opts AccountOptions) *AccountService {
+	if opts.PrivateIDLength <= 0 {
+		opts.PrivateIDLength = utils.DefaultPrivateIDLength
+	}
+	if opts.PrivateIDAlphabet == "" {
+		opts.PrivateIDAlphabet = utils.DefaultPrivateIDAlphabet
+	}
+	return &AccountService{repo: repo, opts: opts}
 }
 
 func (s *AccountService) GetUserByID(ID int64) (entity.User, error) {
 	return s.repo.ByID(ID)
 }
 
+// GetUserByPrivateID resolves a private ID to its owner's current record.
+// The requested private ID is kept so retired links and aliases never reveal
+// the owner's other links. Disabled aliases are reported as not found.
 func (s *AccountService) GetUserByPrivateID(ID string) (entity.User, error) {
-	return s.repo.ByPrivateID(ID)
+	u, err := s.repo.ByPrivateID(ID)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if u.AliasDisabled {
+		return entity.User{}, gocql.ErrNotFound
+	}
+
+	owner, err := s.repo.ByID(u.ID)
+	if err != nil {
+		return entity.User{}, err
+	}
+	owner.PrivateID = ID
+	owner.Retired = u.Retired
+	owner.AliasLabel = u.AliasLabel
+	return owner, nil
+}
+
+// CreateUser allocates a fresh private ID for user and saves it, retrying
+// when the generated ID is already taken.
+func (s *AccountService) CreateUser(user entity.User) (entity.User, error) {
+	for attempt := 0; attempt < privateIDAttempts; attempt++ {
+		privateID, err := s.newPrivateID()
+		if err != nil {
+			return entity.User{}, err
+		}
+
+		user.PrivateID = privateID
+		err = s.repo.Save(user)
+		if errors.Is(err, repository.ErrPrivateIDTaken) {
+			continue
+		}
+		if err != nil {
+			return entity.User{}, err
+		}
+		return user, nil
+	}
+
+	return entity.User{}, ErrPrivateIDExhausted
 }
 
-func (s *AccountService) CreateUser(user entity.User) error {
-	return s.repo.Save(user)
+// RotatePrivateID gives user a fresh private ID. When keepOld is set the old
+// private ID keeps working for the configured grace period.
+func (s *AccountService) RotatePrivateID(user entity.User, keepOld bool) (entity.User, error) {
+	var grace time.Duration
+	if keepOld {
+		grace = s.opts.PrivateIDGracePeriod
+	}
+
+	for attempt := 0; attempt < privateIDAttempts; attempt++ {
+		privateID, err := s.newPrivateID()
+		if err != nil {
+			return entity.User{}, err
+		}
+
+		err = s.repo.RotatePrivateID(user, privateID, grace)
+		if errors.Is(err, repository.ErrPrivateIDTaken) {
+			continue
+		}
+		if err != nil {
+			return entity.User{}, err
+		}
+
+		user.PrivateID = privateID
+		return user, nil
+	}
+
+	return entity.User{}, ErrPrivateIDExhausted
 }
 
 func (s *AccountService) DeleteUser(user entity.User) error {
@@ -32,3 +128,25 @@ func (s *AccountService) DeleteUser(user entity.User) error {
 func (s *AccountService) SetPubKey(user entity.User) error {
 	return s.repo.SetPubKey(user)
 }
+
+// SetRetention changes how long user's incoming messages are kept. See
+// ValidRetention for the accepted values.
+func (s *AccountService) SetRetention(user entity.User, retention int) (entity.User, error) {
+	if !ValidRetention(retention) {
+		return entity.User{}, ErrRetentionInvalid
+	}
+
+	user.Retention = retention
+	if err := s.repo.SetRetention(user); err != nil {
+		return entity.User{}, err
+	}
+	return user, nil
+}
+
+func (s *AccountService) newPrivateID() (string, error) {
+	privateID, err := utils.GenerateRandomPrivateID(s.opts.PrivateIDLength, s.opts.PrivateIDAlphabet)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate private id: %w", err)
+	}
+	return privateID, nil
+}
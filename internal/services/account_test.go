@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// memoryAccounts is an in-memory repository.Account. Private IDs are claimed
+// the way the Cassandra repository claims them: first writer wins.
+type memoryAccounts struct {
+	repository.Account
+
+	mu         sync.Mutex
+	users      map[int64]entity.User
+	privateIDs map[string]entity.User
+	aliases    map[int64]map[string]entity.Alias
+	// collisions makes the next claims fail as if the ID were taken.
+	collisions int
+}
+
+// collide reports whether the claim being made should fail as taken.
+func (m *memoryAccounts) collide(privateID string) bool {
+	if m.collisions > 0 {
+		m.collisions--
+		return true
+	}
+	_, taken := m.privateIDs[privateID]
+	return taken
+}
+
+func newMemoryAccounts() *memoryAccounts {
+	return &memoryAccounts{
+		users:      map[int64]entity.User{},
+		privateIDs: map[string]entity.User{},
+		aliases:    map[int64]map[string]entity.Alias{},
+	}
+}
+
+func (m *memoryAccounts) ByID(ID int64) (entity.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[ID]
+	if !ok {
+		return entity.User{}, gocql.ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *memoryAccounts) ByPrivateID(privateID string) (entity.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.privateIDs[privateID]
+	if !ok {
+		return entity.User{}, gocql.ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *memoryAccounts) Save(user entity.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.collide(user.PrivateID) {
+		return repository.ErrPrivateIDTaken
+	}
+	if _, exists := m.users[user.ID]; exists {
+		return repository.ErrUserExists
+	}
+	m.privateIDs[user.PrivateID] = user
+	m.users[user.ID] = user
+	return nil
+}
+
+func (m *memoryAccounts) SetInbox(user entity.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[user.ID] = user
+	return nil
+}
+
+func (m *memoryAccounts) RotatePrivateID(user entity.User, newPrivateID string, grace time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.collide(newPrivateID) {
+		return repository.ErrPrivateIDTaken
+	}
+
+	current := m.users[user.ID]
+	m.privateIDs[newPrivateID] = entity.User{ID: user.ID, PrivateID: newPrivateID}
+	delete(m.privateIDs, current.PrivateID)
+	if grace > 0 {
+		m.privateIDs[current.PrivateID] = entity.User{ID: user.ID, PrivateID: current.PrivateID, Retired: true}
+	}
+
+	current.PrivateID = newPrivateID
+	current.PrivateIDChangedAt = time.Now()
+	current.VanityChangedAt = user.VanityChangedAt
+	m.users[user.ID] = current
+	return nil
+}
+
+func TestCreateUserRetriesTakenPrivateIDs(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := NewAccountService(accounts, AccountOptions{})
+
+	accounts.collisions = privateIDAttempts - 1
+	user, err := s.CreateUser(entity.User{ID: 1})
+	if err != nil {
+		t.Fatalf("CreateUser() after %d collisions = %v, want nil", privateIDAttempts-1, err)
+	}
+	if owner, err := accounts.ByPrivateID(user.PrivateID); err != nil || owner.ID != 1 {
+		t.Fatalf("private ID %q resolves to %+v, %v; want user 1", user.PrivateID, owner, err)
+	}
+
+	accounts.collisions = privateIDAttempts
+	if _, err := s.CreateUser(entity.User{ID: 2}); !errors.Is(err, ErrPrivateIDExhausted) {
+		t.Fatalf("CreateUser() with no free private IDs = %v, want %v", err, ErrPrivateIDExhausted)
+	}
+	if _, err := accounts.ByID(2); err != gocql.ErrNotFound {
+		t.Fatalf("user 2 was saved without a private ID")
+	}
+}
+
+func TestRotatePrivateID(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := NewAccountService(accounts, AccountOptions{PrivateIDGracePeriod: time.Hour})
+
+	user, err := s.CreateUser(entity.User{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := user.PrivateID
+
+	rotated, err := s.RotatePrivateID(user, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.PrivateID == old {
+		t.Fatal("RotatePrivateID() kept the old private ID")
+	}
+
+	retired, err := s.GetUserByPrivateID(old)
+	if err != nil {
+		t.Fatalf("retired link = %v, want it to resolve during the grace period", err)
+	}
+	if !retired.Retired || retired.PrivateID != old {
+		t.Fatalf("retired link resolved to %+v, want the old ID marked retired", retired)
+	}
+
+	current, err := s.GetUserByPrivateID(rotated.PrivateID)
+	if err != nil || current.ID != 1 || current.Retired {
+		t.Fatalf("new link = %+v, %v; want the active user", current, err)
+	}
+
+	if _, err := s.RotatePrivateID(rotated, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserByPrivateID(rotated.PrivateID); err != gocql.ErrNotFound {
+		t.Fatalf("link rotated without grace = %v, want not found", err)
+	}
+}
+
+func TestRotatePrivateIDExhausted(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := NewAccountService(accounts, AccountOptions{})
+
+	user, err := s.CreateUser(entity.User{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts.collisions = privateIDAttempts
+	if _, err := s.RotatePrivateID(user, false); !errors.Is(err, ErrPrivateIDExhausted) {
+		t.Fatalf("RotatePrivateID() with no free private IDs = %v, want %v", err, ErrPrivateIDExhausted)
+	}
+	if current, _ := accounts.ByID(1); current.PrivateID != user.PrivateID {
+		t.Fatalf("private ID changed to %q after a failed rotation", current.PrivateID)
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrAliasLabelInvalid = errors.New("alias label must be between 1 and 64 characters")
+	ErrAliasLimit        = errors.New("alias limit reached")
+)
+
+const (
+	maxAliasesPerUser   = 20
+	maxAliasLabelLength = 64
+)
+
+func (s *AccountService) GetAliases(user entity.User) ([]entity.Alias, error) {
+	return s.repo.AliasesByUserID(user.ID)
+}
+
+func (s *AccountService) GetAlias(user entity.User, privateID string) (entity.Alias, error) {
+	return s.repo.AliasByID(user.ID, privateID)
+}
+
+// CreateAlias allocates a new private ID that delivers to user's inbox and
+// tags its messages with label.
+func (s *AccountService) CreateAlias(user entity.User, label string) (entity.Alias, error) {
+	label, err := normalizeAliasLabel(label)
+	if err != nil {
+		return entity.Alias{}, err
+	}
+
+	aliases, err := s.repo.AliasesByUserID(user.ID)
+	if err != nil {
+		return entity.Alias{}, err
+	}
+	if len(aliases) >= maxAliasesPerUser {
+		return entity.Alias{}, ErrAliasLimit
+	}
+
+	for attempt := 0; attempt < privateIDAttempts; attempt++ {
+		privateID, err := s.newPrivateID()
+		if err != nil {
+			return entity.Alias{}, err
+		}
+
+		alias := entity.Alias{
+			PrivateID: privateID,
+			Label:     label,
+			Enabled:   true,
+			CreatedAt: time.Now(),
+		}
+
+		err = s.repo.CreateAlias(user, alias)
+		if errors.Is(err, repository.ErrPrivateIDTaken) {
+			continue
+		}
+		if err != nil {
+			return entity.Alias{}, err
+		}
+		return alias, nil
+	}
+
+	return entity.Alias{}, ErrPrivateIDExhausted
+}
+
+// UpdateAlias renames an alias and/or toggles it. Empty label and nil enabled
+// leave the current values untouched.
+func (s *AccountService) UpdateAlias(user entity.User, alias entity.Alias, label string, enabled *bool) (entity.Alias, error) {
+	if label != "" {
+		normalized, err := normalizeAliasLabel(label)
+		if err != nil {
+			return entity.Alias{}, err
+		}
+		alias.Label = normalized
+	}
+	if enabled != nil {
+		alias.Enabled = *enabled
+	}
+
+	if err := s.repo.UpdateAlias(user.ID, alias); err != nil {
+		return entity.Alias{}, err
+	}
+	return alias, nil
+}
+
+func (s *AccountService) DeleteAlias(user entity.User, alias entity.Alias) error {
+	return s.repo.DeleteAlias(user.ID, alias.PrivateID)
+}
+
+func normalizeAliasLabel(label string) (string, error) {
+	label = strings.TrimSpace(label)
+	if label == "" || utf8.RuneCountInString(label) > maxAliasLabelLength {
+		return "", ErrAliasLabelInvalid
+	}
+	return label, nil
+}
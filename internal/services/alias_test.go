@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func (m *memoryAccounts) CreateAlias(user entity.User, alias entity.Alias) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.collide(alias.PrivateID) {
+		return repository.ErrPrivateIDTaken
+	}
+	m.privateIDs[alias.PrivateID] = entity.User{ID: user.ID, PrivateID: alias.PrivateID, AliasLabel: alias.Label, AliasDisabled: !alias.Enabled}
+	if m.aliases[user.ID] == nil {
+		m.aliases[user.ID] = map[string]entity.Alias{}
+	}
+	m.aliases[user.ID][alias.PrivateID] = alias
+	return nil
+}
+
+func (m *memoryAccounts) AliasesByUserID(userID int64) ([]entity.Alias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	aliases := []entity.Alias{}
+	for _, alias := range m.aliases[userID] {
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+func (m *memoryAccounts) AliasByID(userID int64, privateID string) (entity.Alias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alias, ok := m.aliases[userID][privateID]
+	if !ok {
+		return entity.Alias{}, gocql.ErrNotFound
+	}
+	return alias, nil
+}
+
+func (m *memoryAccounts) UpdateAlias(userID int64, alias entity.Alias) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aliases[userID][alias.PrivateID] = alias
+	m.privateIDs[alias.PrivateID] = entity.User{ID: userID, PrivateID: alias.PrivateID, AliasLabel: alias.Label, AliasDisabled: !alias.Enabled}
+	return nil
+}
+
+func (m *memoryAccounts) DeleteAlias(userID int64, privateID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.aliases[userID], privateID)
+	delete(m.privateIDs, privateID)
+	return nil
+}
+
+func TestCreateAlias(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := NewAccountService(accounts, AccountOptions{})
+
+	user, err := s.CreateUser(entity.User{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias, err := s.CreateAlias(user, "  twitter bio ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Label != "twitter bio" || !alias.Enabled || alias.PrivateID == user.PrivateID {
+		t.Fatalf("CreateAlias() = %+v, want an enabled alias with the trimmed label and its own private ID", alias)
+	}
+
+	owner, err := s.GetUserByPrivateID(alias.PrivateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.ID != 1 || owner.PrivateID != alias.PrivateID || owner.AliasLabel != "twitter bio" {
+		t.Fatalf("alias resolved to %+v, want user 1 under the alias", owner)
+	}
+
+	for _, label := range []string{"", "   ", strings.Repeat("x", maxAliasLabelLength+1)} {
+		if _, err := s.CreateAlias(user, label); !errors.Is(err, ErrAliasLabelInvalid) {
+			t.Errorf("CreateAlias(%q) = %v, want %v", label, err, ErrAliasLabelInvalid)
+		}
+	}
+	if _, err := s.CreateAlias(user, strings.Repeat("ش", maxAliasLabelLength)); err != nil {
+		t.Errorf("label of %d runes = %v, want it accepted", maxAliasLabelLength, err)
+	}
+}
+
+func TestCreateAliasLimit(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := NewAccountService(accounts, AccountOptions{})
+	user := entity.User{ID: 1}
+
+	for i := 0; i < maxAliasesPerUser; i++ {
+		if _, err := s.CreateAlias(user, "alias"); err != nil {
+			t.Fatalf("alias %d = %v", i+1, err)
+		}
+	}
+	if _, err := s.CreateAlias(user, "alias"); !errors.Is(err, ErrAliasLimit) {
+		t.Fatalf("alias over the limit = %v, want %v", err, ErrAliasLimit)
+	}
+
+	accounts.collisions = privateIDAttempts
+	other := entity.User{ID: 2}
+	if _, err := s.CreateAlias(other, "alias"); !errors.Is(err, ErrPrivateIDExhausted) {
+		t.Fatalf("alias with no free private IDs = %v, want %v", err, ErrPrivateIDExhausted)
+	}
+}
+
+func TestDisableAlias(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := NewAccountService(accounts, AccountOptions{})
+	user := entity.User{ID: 1}
+	if err := accounts.Save(entity.User{ID: 1, PrivateID: "main"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alias, err := s.CreateAlias(user, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := false
+	alias, err = s.UpdateAlias(user, alias, "", &disabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Enabled || alias.Label != "work" {
+		t.Fatalf("UpdateAlias() = %+v, want it disabled with its label kept", alias)
+	}
+	if _, err := s.GetUserByPrivateID(alias.PrivateID); err != gocql.ErrNotFound {
+		t.Fatalf("disabled alias = %v, want %v", err, gocql.ErrNotFound)
+	}
+	if owner, err := s.GetUserByPrivateID("main"); err != nil || owner.ID != 1 {
+		t.Fatalf("main link = %+v, %v; want it unaffected", owner, err)
+	}
+
+	enabled := true
+	if alias, err = s.UpdateAlias(user, alias, "personal", &enabled); err != nil {
+		t.Fatal(err)
+	}
+	owner, err := s.GetUserByPrivateID(alias.PrivateID)
+	if err != nil || owner.AliasLabel != "personal" {
+		t.Fatalf("re-enabled alias = %+v, %v; want it resolving with the new label", owner, err)
+	}
+
+	if err := s.DeleteAlias(user, alias); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserByPrivateID(alias.PrivateID); err != gocql.ErrNotFound {
+		t.Fatalf("deleted alias = %v, want %v", err, gocql.ErrNotFound)
+	}
+	aliases, err := s.GetAliases(user)
+	if err != nil || slices.ContainsFunc(aliases, func(a entity.Alias) bool { return a.PrivateID == alias.PrivateID }) {
+		t.Fatalf("GetAliases() = %+v, %v; want the deleted alias gone", aliases, err)
+	}
+}
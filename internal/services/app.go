@@ -1,13 +1,28 @@
 package services
 
 type App struct {
-	Account *AccountService
-	Message *MessageService
+	Account    *AccountService
+	Message    *MessageService
+	Auth       *AuthService
+	RateLimit  *RateLimitService
+	Challenge  *ChallengeService
+	Attachment *AttachmentService
 }
 
 func NewApp(
 	Account *AccountService,
 	Message *MessageService,
+	Auth *AuthService,
+	RateLimit *RateLimitService,
+	Challenge *ChallengeService,
+	Attachment *AttachmentService,
 ) *App {
-	return &App{Account: Account, Message: Message}
+	return &App{
+		Account:    Account,
+		Message:    Message,
+		Auth:       Auth,
+		RateLimit:  RateLimit,
+		Challenge:  Challenge,
+		Attachment: Attachment,
+	}
 }
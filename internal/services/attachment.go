@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"pipe/internal/blob"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/pkg/sealed"
+	"slices"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentEmpty    = errors.New("attachment is empty")
+	ErrAttachmentQuota    = errors.New("attachment quota is used up")
+	ErrAttachmentInvalid  = errors.New("attachment doesn't exist or was already sent")
+	ErrTooManyAttachments = errors.New("message has too many attachments")
+)
+
+// maxMessageAttachments caps how many attachments one message can carry.
+const maxMessageAttachments = 10
+
+// sweepGrace keeps Sweep away from blobs whose upload is still being recorded.
+const sweepGrace = 10 * time.Minute
+
+// AttachmentOptions limit uploads. MaxSize is per attachment and Quota is
+// the total size of the attachments a user can have uploaded but not yet
+// sent, 10 MiB and 100 MiB unless set. Attachments that aren't sent within UploadTTL expire; blobs left
+// behind by expired or deleted attachments are swept every GCInterval.
+type AttachmentOptions struct {
+	MaxSize    int64
+	Quota      int64
+	UploadTTL  time.Duration
+	GCInterval time.Duration
+}
+
+// AttachmentRepositories are the stores AttachmentService works with.
+type AttachmentRepositories struct {
+	Attachments repository.Attachment
+	Messages    repository.Message
+	Quota       repository.AttachmentQuota
+}
+
+type AttachmentService struct {
+	repo     repository.Attachment
+	messages repository.Message
+	quota    repository.AttachmentQuota
+	store    blob.Store
+	sealer   *sealed.Sealer
+	opts     AttachmentOptions
+}
+
+func NewAttachmentService(repos AttachmentRepositories, store blob.Store, sealer *sealed.Sealer, opts AttachmentOptions) *AttachmentService {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10 << 20
+	}
+	if opts.Quota <= 0 {
+		opts.Quota = 100 << 20
+	}
+	if opts.UploadTTL <= 0 {
+		opts.UploadTTL = time.Hour
+	}
+	if opts.GCInterval <= 0 {
+		opts.GCInterval = time.Hour
+	}
+	return &AttachmentService{
+		repo:     repos.Attachments,
+		messages: repos.Messages,
+		quota:    repos.Quota,
+		store:    store,
+		sealer:   sealer,
+		opts:     opts,
+	}
+}
+
+// Upload stores an encrypted blob for owner to send with a message. Like the
+// outbox, it is kept under owner's outbox token rather than their ID. Its
+// share of the quota is reserved before the blob is read, as much as is left
+// up to MaxSize, and trimmed to the blob's size once it's stored.
+func (s *AttachmentService) Upload(ctx context.Context, owner int64, r io.Reader) (entity.Attachment, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.opts.UploadTTL)
+	attachment := entity.Attachment{
+		ID:         gocql.TimeUUID(),
+		CreatedAt:  now,
+		ExpiresAt:  expiresAt.Unix(),
+		OwnerToken: s.sealer.OutboxToken(owner),
+	}
+	key := attachment.ID.String()
+
+	limit, err := s.quota.ReserveQuota(ctx, attachment.OwnerToken, key, s.opts.MaxSize, s.opts.Quota, expiresAt)
+	if err != nil {
+		return entity.Attachment{}, err
+	}
+	if limit == 0 {
+		return entity.Attachment{}, ErrAttachmentQuota
+	}
+
+	size, err := s.store.Put(ctx, key, io.LimitReader(r, limit+1))
+	switch {
+	case err != nil:
+		// Cleaned up below like any other failure.
+	case size == 0:
+		err = ErrAttachmentEmpty
+	case size > s.opts.MaxSize:
+		err = ErrAttachmentTooLarge
+	case size > limit:
+		err = ErrAttachmentQuota
+	default:
+		attachment.Size = size
+		_, err = s.quota.ReserveQuota(ctx, attachment.OwnerToken, key, size, s.opts.Quota, expiresAt)
+		if err == nil {
+			err = s.repo.SaveAttachment(attachment, s.opts.UploadTTL)
+		}
+	}
+	if err != nil {
+		if deleteErr := s.store.Delete(ctx, key); deleteErr != nil {
+			log.Printf("Failed to delete blob %s, Error: %v\n", key, deleteErr)
+		}
+		s.release(ctx, attachment)
+		return entity.Attachment{}, err
+	}
+
+	return attachment, nil
+}
+
+// Open returns an attachment's blob to its uploader or, once it's sent, to
+// the recipient. Anyone else gets gocql.ErrNotFound.
+func (s *AttachmentService) Open(ctx context.Context, userID int64, attachmentID gocql.UUID) (entity.Attachment, io.ReadCloser, error) {
+	attachment, err := s.repo.AttachmentByID(attachmentID)
+	if err != nil {
+		return entity.Attachment{}, nil, err
+	}
+	if attachment.ToUser != userID && !slices.Contains(s.sealer.OutboxTokens(userID), attachment.OwnerToken) {
+		return entity.Attachment{}, nil, gocql.ErrNotFound
+	}
+
+	r, err := s.store.Get(ctx, attachment.ID.String())
+	if errors.Is(err, blob.ErrNotFound) {
+		return entity.Attachment{}, nil, gocql.ErrNotFound
+	}
+	if err != nil {
+		return entity.Attachment{}, nil, err
+	}
+	return attachment, r, nil
+}
+
+// linked checks the attachments of message were uploaded by sender and not
+// sent yet, and returns them handed over to the recipient for as long as the
+// message is kept. They are stored along with the message.
+func (s *AttachmentService) linked(sender int64, message entity.Message) ([]entity.Attachment, error) {
+	if len(message.Attachments) > maxMessageAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	tokens := s.sealer.OutboxTokens(sender)
+	attachments := make([]entity.Attachment, 0, len(message.Attachments))
+	for i, id := range message.Attachments {
+		if slices.Contains(message.Attachments[:i], id) {
+			return nil, ErrAttachmentInvalid
+		}
+
+		attachment, err := s.repo.AttachmentByID(id)
+		if err == gocql.ErrNotFound {
+			return nil, ErrAttachmentInvalid
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attachment.MessageID != nil || !slices.Contains(tokens, attachment.OwnerToken) {
+			return nil, ErrAttachmentInvalid
+		}
+
+		attachment.MessageID = &message.ID
+		attachment.ToUser = message.ToUser
+		attachment.ExpiresAt = message.ExpiresAt
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// claim takes attachments, which linked returned, for message so no other
+// message sent at the same time can take them too. If one is already taken,
+// the claims made so far are given up and ErrAttachmentInvalid is returned.
+func (s *AttachmentService) claim(message entity.Message, attachments []entity.Attachment) error {
+	for i, attachment := range attachments {
+		claimed, err := s.repo.ClaimAttachment(attachment.ID, message.ID, s.opts.UploadTTL)
+		if err == nil && !claimed {
+			err = ErrAttachmentInvalid
+		}
+		if err != nil {
+			s.unclaim(message, attachments[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// unclaim gives up the claims of message, which wasn't stored, on
+// attachments. A failure leaves an attachment unusable until its upload
+// expires, so it's logged.
+func (s *AttachmentService) unclaim(message entity.Message, attachments []entity.Attachment) {
+	for _, attachment := range attachments {
+		if err := s.repo.UnclaimAttachment(attachment.ID, message.ID); err != nil {
+			log.Printf("Failed to unclaim attachment %s, Error: %v\n", attachment.ID, err)
+		}
+	}
+}
+
+// sent gives the quota held by attachments, which were just sent, back to
+// their uploader.
+func (s *AttachmentService) sent(ctx context.Context, attachments []entity.Attachment) {
+	for _, attachment := range attachments {
+		s.release(ctx, attachment)
+	}
+}
+
+// release gives the quota reserved for attachment back to its owner. A
+// failure only holds the quota until the reservation expires, so it's logged.
+func (s *AttachmentService) release(ctx context.Context, attachment entity.Attachment) {
+	if err := s.quota.ReleaseQuota(ctx, attachment.OwnerToken, attachment.ID.String()); err != nil {
+		log.Printf("Failed to release quota of attachment %s, Error: %v\n", attachment.ID, err)
+	}
+}
+
+// deleteFor removes the attachments of messages and their blobs.
+func (s *AttachmentService) deleteFor(ctx context.Context, messages ...entity.Message) error {
+	for _, message := range messages {
+		for _, id := range message.Attachments {
+			attachment, err := s.repo.AttachmentByID(id)
+			if err == gocql.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := s.repo.DeleteAttachment(attachment); err != nil {
+				return err
+			}
+			if err := s.store.Delete(ctx, id.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteForUser removes the attachments of every message in userID's inbox,
+// ahead of the inbox itself being cleared.
+func (s *AttachmentService) DeleteForUser(ctx context.Context, userID int64) error {
+	var pageState []byte
+	for {
+		messages, next, err := s.messages.ByUserID(userID, 500, pageState)
+		if err != nil {
+			return err
+		}
+		if err := s.deleteFor(ctx, messages...); err != nil {
+			return err
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		pageState = next
+	}
+}
+
+// Sweep deletes blobs whose attachment is gone, because it expired with its
+// message or was never sent, and returns how many it deleted.
+func (s *AttachmentService) Sweep(ctx context.Context) (int, error) {
+	deleted := 0
+	err := s.store.Walk(ctx, func(key string, modTime time.Time) error {
+		if time.Since(modTime) < sweepGrace {
+			return nil
+		}
+
+		id, err := gocql.ParseUUID(key)
+		if err != nil {
+			return nil
+		}
+
+		_, err = s.repo.AttachmentByID(id)
+		if err != gocql.ErrNotFound {
+			return err
+		}
+
+		if err := s.store.Delete(ctx, key); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	return deleted, err
+}
+
+// Run sweeps blobs every GCInterval until ctx is done.
+func (s *AttachmentService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.GCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deleted, err := s.Sweep(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to sweep attachment blobs, Error: %v\n", err)
+		}
+		if deleted > 0 {
+			log.Printf("Swept %d attachment blobs\n", deleted)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pipe/internal/blob"
+	"pipe/internal/entity"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// memoryAttachments is an in-memory repository.Attachment.
+type memoryAttachments struct {
+	attachments map[gocql.UUID]entity.Attachment
+}
+
+func (a *memoryAttachments) SaveAttachment(attachment entity.Attachment, _ time.Duration) error {
+	a.attachments[attachment.ID] = attachment
+	return nil
+}
+
+func (a *memoryAttachments) AttachmentByID(attachmentID gocql.UUID) (entity.Attachment, error) {
+	attachment, ok := a.attachments[attachmentID]
+	if !ok {
+		return entity.Attachment{}, gocql.ErrNotFound
+	}
+	return attachment, nil
+}
+
+func (a *memoryAttachments) ClaimAttachment(attachmentID, messageID gocql.UUID, _ time.Duration) (bool, error) {
+	attachment, ok := a.attachments[attachmentID]
+	if !ok || attachment.MessageID != nil {
+		return false, nil
+	}
+	attachment.MessageID = &messageID
+	a.attachments[attachmentID] = attachment
+	return true, nil
+}
+
+func (a *memoryAttachments) UnclaimAttachment(attachmentID, messageID gocql.UUID) error {
+	attachment, ok := a.attachments[attachmentID]
+	if ok && attachment.MessageID != nil && *attachment.MessageID == messageID {
+		attachment.MessageID = nil
+		a.attachments[attachmentID] = attachment
+	}
+	return nil
+}
+
+func (a *memoryAttachments) DeleteAttachment(attachment entity.Attachment) error {
+	delete(a.attachments, attachment.ID)
+	return nil
+}
+
+// memoryQuota is an in-memory repository.AttachmentQuota whose reservations
+// never expire.
+type memoryQuota struct {
+	reserved map[string]map[string]int64
+}
+
+func (q *memoryQuota) ReserveQuota(_ context.Context, ownerToken, attachmentID string, size, quota int64, _ time.Time) (int64, error) {
+	if q.reserved[ownerToken] == nil {
+		q.reserved[ownerToken] = map[string]int64{}
+	}
+	free := quota
+	for id, reserved := range q.reserved[ownerToken] {
+		if id != attachmentID {
+			free -= reserved
+		}
+	}
+	if free <= 0 {
+		return 0, nil
+	}
+	free = min(free, size)
+	q.reserved[ownerToken][attachmentID] = free
+	return free, nil
+}
+
+func (q *memoryQuota) ReleaseQuota(_ context.Context, ownerToken string, attachmentIDs ...string) error {
+	for _, id := range attachmentIDs {
+		delete(q.reserved[ownerToken], id)
+	}
+	return nil
+}
+
+func (q *memoryQuota) used(ownerToken string) int64 {
+	var used int64
+	for _, reserved := range q.reserved[ownerToken] {
+		used += reserved
+	}
+	return used
+}
+
+func newTestAttachments(t *testing.T, opts AttachmentOptions) (*AttachmentService, *memoryAttachments, *memoryQuota) {
+	t.Helper()
+	store, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &memoryAttachments{attachments: map[gocql.UUID]entity.Attachment{}}
+	quota := &memoryQuota{reserved: map[string]map[string]int64{}}
+	s := NewAttachmentService(AttachmentRepositories{Attachments: repo, Quota: quota}, store, testSealer(t), opts)
+	return s, repo, quota
+}
+
+func TestUploadQuota(t *testing.T) {
+	s, repo, quota := newTestAttachments(t, AttachmentOptions{MaxSize: 8, Quota: 10})
+	ctx := context.Background()
+	const owner = 1
+	token := s.sealer.OutboxToken(owner)
+
+	first, err := s.Upload(ctx, owner, strings.NewReader("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := quota.used(token); used != 6 {
+		t.Fatalf("quota used = %d after a 6 byte upload, want the reservation trimmed to 6", used)
+	}
+
+	// Only 4 bytes are left, so a 5 byte upload is over the quota rather
+	// than over MaxSize.
+	if _, err := s.Upload(ctx, owner, strings.NewReader("12345")); !errors.Is(err, ErrAttachmentQuota) {
+		t.Fatalf("upload over the quota = %v, want %v", err, ErrAttachmentQuota)
+	}
+	if _, err := s.Upload(ctx, owner, strings.NewReader("")); !errors.Is(err, ErrAttachmentEmpty) {
+		t.Fatalf("empty upload = %v, want %v", err, ErrAttachmentEmpty)
+	}
+	if used := quota.used(token); used != 6 {
+		t.Fatalf("quota used = %d after failed uploads, want their reservations released", used)
+	}
+	if len(repo.attachments) != 1 {
+		t.Fatalf("stored %d attachments, want only the first", len(repo.attachments))
+	}
+
+	if _, err := s.Upload(ctx, owner, strings.NewReader("1234")); err != nil {
+		t.Fatalf("upload filling the quota = %v", err)
+	}
+	if _, err := s.Upload(ctx, owner, strings.NewReader("1")); !errors.Is(err, ErrAttachmentQuota) {
+		t.Fatalf("upload with the quota used up = %v, want %v", err, ErrAttachmentQuota)
+	}
+
+	// Sending an attachment gives its share of the quota back.
+	message := entity.Message{ID: gocql.TimeUUID(), ToUser: 2, Attachments: []gocql.UUID{first.ID}}
+	attachments, err := s.linked(owner, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sent(ctx, attachments)
+	if used := quota.used(token); used != 4 {
+		t.Fatalf("quota used = %d after sending, want 4", used)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	s, repo, _ := newTestAttachments(t, AttachmentOptions{MaxSize: 4})
+
+	if _, err := s.Upload(context.Background(), 1, strings.NewReader("12345")); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Fatalf("upload over MaxSize = %v, want %v", err, ErrAttachmentTooLarge)
+	}
+	if len(repo.attachments) != 0 {
+		t.Fatal("attachment over MaxSize was stored")
+	}
+}
+
+func TestLinked(t *testing.T) {
+	s, repo, _ := newTestAttachments(t, AttachmentOptions{})
+	ctx := context.Background()
+	const sender = 1
+
+	mine, err := s.Upload(ctx, sender, strings.NewReader("mine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := s.Upload(ctx, 3, strings.NewReader("theirs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsent, err := s.Upload(ctx, sender, strings.NewReader("unsent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := entity.Message{ID: gocql.TimeUUID(), ToUser: 2, ExpiresAt: 100, Attachments: []gocql.UUID{mine.ID}}
+	attachments, err := s.linked(sender, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || *attachments[0].MessageID != message.ID || attachments[0].ToUser != 2 || attachments[0].ExpiresAt != 100 {
+		t.Fatalf("linked() = %+v, want the attachment handed to the recipient", attachments)
+	}
+	if repo.attachments[mine.ID].MessageID != nil {
+		t.Fatal("linked() stored the attachment; it's stored along with the message")
+	}
+
+	sentAttachment := repo.attachments[mine.ID]
+	sentAttachment.MessageID = &message.ID
+	repo.attachments[mine.ID] = sentAttachment
+
+	tests := []struct {
+		name        string
+		attachments []gocql.UUID
+		want        error
+	}{
+		{"someone else's", []gocql.UUID{theirs.ID}, ErrAttachmentInvalid},
+		{"already sent", []gocql.UUID{mine.ID}, ErrAttachmentInvalid},
+		{"missing", []gocql.UUID{gocql.TimeUUID()}, ErrAttachmentInvalid},
+		{"repeated", []gocql.UUID{unsent.ID, unsent.ID}, ErrAttachmentInvalid},
+		{"too many", make([]gocql.UUID, maxMessageAttachments+1), ErrTooManyAttachments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := entity.Message{ID: gocql.TimeUUID(), ToUser: 2, Attachments: tt.attachments}
+			if _, err := s.linked(sender, message); !errors.Is(err, tt.want) {
+				t.Fatalf("linked() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaim(t *testing.T) {
+	s, repo, _ := newTestAttachments(t, AttachmentOptions{})
+	ctx := context.Background()
+	const sender = 1
+
+	var uploaded []gocql.UUID
+	for _, data := range []string{"first", "second"} {
+		attachment, err := s.Upload(ctx, sender, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploaded = append(uploaded, attachment.ID)
+	}
+
+	// Both messages pass linked() before either is stored.
+	first := entity.Message{ID: gocql.TimeUUID(), ToUser: 2, Attachments: uploaded[1:]}
+	second := entity.Message{ID: gocql.TimeUUID(), ToUser: 2, Attachments: uploaded}
+	firstAttachments, err := s.linked(sender, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondAttachments, err := s.linked(sender, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.claim(first, firstAttachments); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.claim(second, secondAttachments); !errors.Is(err, ErrAttachmentInvalid) {
+		t.Fatalf("claim() of a taken attachment = %v, want %v", err, ErrAttachmentInvalid)
+	}
+	if repo.attachments[uploaded[0]].MessageID != nil {
+		t.Fatal("failed claim() kept the attachments it took before the taken one")
+	}
+	if *repo.attachments[uploaded[1]].MessageID != first.ID {
+		t.Fatal("failed claim() took the attachment from the message that claimed it")
+	}
+
+	s.unclaim(first, firstAttachments)
+	if err := s.claim(second, secondAttachments); err != nil {
+		t.Fatalf("claim() after unclaim() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/pkg/token"
+	"strings"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+var (
+	ErrSessionsDisabled = errors.New("session tokens are not configured")
+	ErrSessionInvalid   = errors.New("session token is invalid")
+	ErrSessionExpired   = errors.New("session token is expired")
+	ErrSessionRevoked   = errors.New("session has been revoked")
+	ErrTicketInvalid    = errors.New("ticket is invalid or already used")
+)
+
+// TicketTTL is how long a realtime ticket can be redeemed for.
+const TicketTTL = 30 * time.Second
+
+type AuthService struct {
+	repo       repository.Auth
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	premiumTTL time.Duration
+}
+
+// NewAuthService returns an AuthService whose sessions vouch for the Premium
+// status they were created with for premiumTTL, or for as long as they last
+// if premiumTTL is zero.
+func NewAuthService(repo repository.Auth, secret string, accessTTL, refreshTTL, premiumTTL time.Duration) *AuthService {
+	return &AuthService{
+		repo:       repo,
+		secret:     []byte(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		premiumTTL: premiumTTL,
+	}
+}
+
+func (s *AuthService) ClaimInitData(ctx context.Context, hash string, ttl time.Duration) (bool, error) {
+	return s.repo.ClaimInitData(ctx, hash, ttl)
+}
+
+// CreateSession starts a new session and returns its first token pair.
+func (s *AuthService) CreateSession(ctx context.Context, session entity.Session) (entity.SessionTokens, error) {
+	if len(s.secret) == 0 {
+		return entity.SessionTokens{}, ErrSessionsDisabled
+	}
+
+	sessionID, err := randomString(16)
+	if err != nil {
+		return entity.SessionTokens{}, err
+	}
+	session.ID = sessionID
+	session.PremiumAt = time.Now().Unix()
+
+	return s.issueTokens(ctx, session)
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. The old
+// refresh token stops working.
+func (s *AuthService) RefreshSession(ctx context.Context, refreshToken string) (entity.SessionTokens, error) {
+	if len(s.secret) == 0 {
+		return entity.SessionTokens{}, ErrSessionsDisabled
+	}
+
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return entity.SessionTokens{}, ErrSessionInvalid
+	}
+
+	session, refreshHash, err := s.repo.SessionByID(ctx, sessionID)
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return entity.SessionTokens{}, ErrSessionRevoked
+		}
+		return entity.SessionTokens{}, err
+	}
+
+	oldHash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(oldHash), []byte(refreshHash)) != 1 {
+		return entity.SessionTokens{}, ErrSessionInvalid
+	}
+
+	refreshSecret, err := randomString(32)
+	if err != nil {
+		return entity.SessionTokens{}, err
+	}
+
+	// Another refresh with the same token may have won the race since the
+	// read above; only the one that swaps the hash gets new tokens.
+	rotated, err := s.repo.RotateRefresh(ctx, session, oldHash, hashSecret(refreshSecret), s.refreshTTL)
+	if err != nil {
+		return entity.SessionTokens{}, err
+	}
+	if !rotated {
+		return entity.SessionTokens{}, ErrSessionInvalid
+	}
+
+	return s.sessionTokens(session, refreshSecret)
+}
+
+// VerifyAccessToken validates the signature, expiry and revocation state of
+// an access token and returns the session it belongs to. Premium status
+// older than premiumTTL is dropped; users get it back by starting a new
+// session with fresh init data.
+func (s *AuthService) VerifyAccessToken(ctx context.Context, accessToken string) (entity.Session, error) {
+	if len(s.secret) == 0 {
+		return entity.Session{}, ErrSessionsDisabled
+	}
+
+	payload, err := token.Verify(accessToken, s.secret)
+	if err != nil {
+		return entity.Session{}, ErrSessionInvalid
+	}
+
+	var claims entity.AccessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" || claims.UserID == 0 {
+		return entity.Session{}, ErrSessionInvalid
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return entity.Session{}, ErrSessionExpired
+	}
+
+	revoked, err := s.repo.IsSessionRevoked(ctx, claims.ID)
+	if err != nil {
+		return entity.Session{}, err
+	}
+	if revoked {
+		return entity.Session{}, ErrSessionRevoked
+	}
+
+	session := claims.Session
+	if session.IsPremium && s.premiumTTL > 0 && time.Since(time.Unix(session.PremiumAt, 0)) > s.premiumTTL {
+		session.IsPremium = false
+	}
+	return session, nil
+}
+
+// IssueTicket returns a single-use ticket that stands for session on one
+// realtime connection. Only its hash is stored.
+func (s *AuthService) IssueTicket(ctx context.Context, session entity.Session) (entity.Ticket, error) {
+	ticket, err := randomString(32)
+	if err != nil {
+		return entity.Ticket{}, err
+	}
+
+	if err := s.repo.SaveTicket(ctx, hashSecret(ticket), session, TicketTTL); err != nil {
+		return entity.Ticket{}, err
+	}
+
+	return entity.Ticket{
+		Ticket:    ticket,
+		ExpiresIn: int64(TicketTTL.Seconds()),
+	}, nil
+}
+
+// RedeemTicket returns the session behind ticket and invalidates it.
+func (s *AuthService) RedeemTicket(ctx context.Context, ticket string) (entity.Session, error) {
+	session, err := s.repo.ClaimTicket(ctx, hashSecret(ticket))
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return entity.Session{}, ErrTicketInvalid
+		}
+		return entity.Session{}, err
+	}
+	return session, nil
+}
+
+func (s *AuthService) RevokeSession(ctx context.Context, session entity.Session) error {
+	return s.repo.RevokeSession(ctx, session.UserID, session.ID, s.accessTTL)
+}
+
+func (s *AuthService) RevokeUserSessions(ctx context.Context, userID int64) error {
+	sessionIDs, err := s.repo.SessionIDsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := s.repo.RevokeSession(ctx, userID, sessionID, s.accessTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, session entity.Session) (entity.SessionTokens, error) {
+	refreshSecret, err := randomString(32)
+	if err != nil {
+		return entity.SessionTokens{}, err
+	}
+
+	if err := s.repo.SaveSession(ctx, session, hashSecret(refreshSecret), s.refreshTTL); err != nil {
+		return entity.SessionTokens{}, err
+	}
+
+	return s.sessionTokens(session, refreshSecret)
+}
+
+// sessionTokens signs a fresh access token for session and pairs it with the
+// refresh secret already stored for it.
+func (s *AuthService) sessionTokens(session entity.Session, refreshSecret string) (entity.SessionTokens, error) {
+	payload, err := json.Marshal(entity.AccessClaims{
+		Session:   session,
+		ExpiresAt: time.Now().Add(s.accessTTL).Unix(),
+	})
+	if err != nil {
+		return entity.SessionTokens{}, fmt.Errorf("failed to encode access token: %w", err)
+	}
+
+	return entity.SessionTokens{
+		AccessToken:  token.Sign(payload, s.secret),
+		RefreshToken: session.ID + "." + refreshSecret,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// memoryAuth is an in-memory repository.Auth for service tests.
+type memoryAuth struct {
+	mu       sync.Mutex
+	sessions map[string]entity.Session
+	refresh  map[string]string
+	revoked  map[string]bool
+	tickets  map[string]entity.Session
+}
+
+var _ repository.Auth = &memoryAuth{}
+
+func newMemoryAuth() *memoryAuth {
+	return &memoryAuth{
+		sessions: map[string]entity.Session{},
+		refresh:  map[string]string{},
+		revoked:  map[string]bool{},
+		tickets:  map[string]entity.Session{},
+	}
+}
+
+func (m *memoryAuth) ClaimInitData(context.Context, string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (m *memoryAuth) SaveSession(_ context.Context, session entity.Session, refreshHash string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = session
+	m.refresh[session.ID] = refreshHash
+	return nil
+}
+
+func (m *memoryAuth) SessionByID(_ context.Context, sessionID string) (entity.Session, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return entity.Session{}, "", rueidis.Nil
+	}
+	return session, m.refresh[sessionID], nil
+}
+
+func (m *memoryAuth) RotateRefresh(_ context.Context, session entity.Session, oldHash, newHash string, _ time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.refresh[session.ID] != oldHash {
+		return false, nil
+	}
+	m.refresh[session.ID] = newHash
+	return true, nil
+}
+
+func (m *memoryAuth) RevokeSession(_ context.Context, _ int64, sessionID string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, sessionID)
+	delete(m.refresh, sessionID)
+	m.revoked[sessionID] = true
+	return nil
+}
+
+func (m *memoryAuth) SessionIDsByUser(_ context.Context, userID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memoryAuth) IsSessionRevoked(_ context.Context, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revoked[sessionID], nil
+}
+
+func (m *memoryAuth) SaveTicket(_ context.Context, ticketHash string, session entity.Session, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickets[ticketHash] = session
+	return nil
+}
+
+func (m *memoryAuth) ClaimTicket(_ context.Context, ticketHash string) (entity.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.tickets[ticketHash]
+	if !ok {
+		return entity.Session{}, rueidis.Nil
+	}
+	delete(m.tickets, ticketHash)
+	return session, nil
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	auth := NewAuthService(newMemoryAuth(), "secret", time.Minute, time.Hour, 0)
+
+	tokens, err := auth.CreateSession(ctx, entity.Session{UserID: 42, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := auth.VerifyAccessToken(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() = %v, want nil", err)
+	}
+	if session.UserID != 42 || session.Username != "alice" {
+		t.Fatalf("VerifyAccessToken() session = %+v", session)
+	}
+
+	refreshed, err := auth.RefreshSession(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshSession() = %v, want nil", err)
+	}
+	if _, err := auth.RefreshSession(ctx, tokens.RefreshToken); !errors.Is(err, ErrSessionInvalid) {
+		t.Fatalf("RefreshSession() with used token = %v, want %v", err, ErrSessionInvalid)
+	}
+
+	if err := auth.RevokeUserSessions(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.VerifyAccessToken(ctx, refreshed.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("VerifyAccessToken() after revoke = %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := auth.RefreshSession(ctx, refreshed.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("RefreshSession() after revoke = %v, want %v", err, ErrSessionRevoked)
+	}
+}
+
+func TestVerifyAccessTokenRejects(t *testing.T) {
+	ctx := context.Background()
+	auth := NewAuthService(newMemoryAuth(), "secret", -time.Second, time.Hour, 0)
+
+	tokens, err := auth.CreateSession(ctx, entity.Session{UserID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.VerifyAccessToken(ctx, tokens.AccessToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("VerifyAccessToken() expired = %v, want %v", err, ErrSessionExpired)
+	}
+
+	other := NewAuthService(newMemoryAuth(), "other", time.Minute, time.Hour, 0)
+	if _, err := other.VerifyAccessToken(ctx, tokens.AccessToken); !errors.Is(err, ErrSessionInvalid) {
+		t.Fatalf("VerifyAccessToken() foreign secret = %v, want %v", err, ErrSessionInvalid)
+	}
+
+	disabled := NewAuthService(newMemoryAuth(), "", time.Minute, time.Hour, 0)
+	if _, err := disabled.CreateSession(ctx, entity.Session{UserID: 42}); !errors.Is(err, ErrSessionsDisabled) {
+		t.Fatalf("CreateSession() without secret = %v, want %v", err, ErrSessionsDisabled)
+	}
+}
+
+func TestRefreshSessionConcurrent(t *testing.T) {
+	ctx := context.Background()
+	auth := NewAuthService(newMemoryAuth(), "secret", time.Minute, time.Hour, 0)
+
+	tokens, err := auth.CreateSession(ctx, entity.Session{UserID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 16
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := auth.RefreshSession(ctx, tokens.RefreshToken); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Fatalf("%d concurrent refreshes succeeded, want exactly 1", succeeded)
+	}
+}
+
+func TestTicketSingleUse(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryAuth()
+	// Tickets work without session tokens configured.
+	auth := NewAuthService(repo, "", time.Minute, time.Hour, 0)
+
+	ticket, err := auth.IssueTicket(ctx, entity.Session{UserID: 42, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stored := repo.tickets[ticket.Ticket]; stored {
+		t.Fatal("ticket was stored in the clear")
+	}
+
+	session, err := auth.RedeemTicket(ctx, ticket.Ticket)
+	if err != nil {
+		t.Fatalf("RedeemTicket() = %v, want nil", err)
+	}
+	if session.UserID != 42 || session.Username != "alice" {
+		t.Fatalf("RedeemTicket() session = %+v", session)
+	}
+
+	if _, err := auth.RedeemTicket(ctx, ticket.Ticket); !errors.Is(err, ErrTicketInvalid) {
+		t.Fatalf("RedeemTicket() a second time = %v, want %v", err, ErrTicketInvalid)
+	}
+	if _, err := auth.RedeemTicket(ctx, "made-up"); !errors.Is(err, ErrTicketInvalid) {
+		t.Fatalf("RedeemTicket() unknown ticket = %v, want %v", err, ErrTicketInvalid)
+	}
+}
+
+func TestSessionPremiumExpires(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryAuth()
+	auth := NewAuthService(repo, "secret", time.Minute, time.Hour, time.Hour)
+
+	tokens, err := auth.CreateSession(ctx, entity.Session{UserID: 42, IsPremium: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := auth.VerifyAccessToken(ctx, tokens.AccessToken); err != nil || !session.IsPremium {
+		t.Fatalf("VerifyAccessToken() = %+v, %v; want a premium session", session, err)
+	}
+
+	// The session was created with init data checked two hours ago; a refresh
+	// doesn't make its Premium status any fresher.
+	for id, session := range repo.sessions {
+		session.PremiumAt = time.Now().Add(-2 * time.Hour).Unix()
+		repo.sessions[id] = session
+	}
+	refreshed, err := auth.RefreshSession(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := auth.VerifyAccessToken(ctx, refreshed.AccessToken); err != nil || session.IsPremium {
+		t.Fatalf("VerifyAccessToken() = %+v, %v; want Premium dropped once stale", session, err)
+	}
+}
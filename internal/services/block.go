@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrSenderBlocked    = errors.New("sender is blocked by the recipient")
+	ErrBlockModeInvalid = errors.New("block mode is not valid")
+	ErrNotBlockable     = errors.New("message has no sender token to block")
+)
+
+// Block blocks or mutes the sender of one of ID's messages. Blocking the same
+// sender again replaces the earlier block.
+func (m *MessageService) Block(ID int64, messageID gocql.UUID, mode string) (entity.Block, error) {
+	if err := checkBlockMode(&mode); err != nil {
+		return entity.Block{}, err
+	}
+
+	message, err := m.messageRepository.ByID(ID, messageID)
+	if err != nil {
+		return entity.Block{}, err
+	}
+	return m.block(ID, message, mode)
+}
+
+// BlockReply blocks or mutes the sender of one of the replies ID received.
+// Replies carry the same sender tokens as messages, so the block also covers
+// messages from them.
+func (m *MessageService) BlockReply(ID int64, replyID gocql.UUID, mode string) (entity.Block, error) {
+	if err := checkBlockMode(&mode); err != nil {
+		return entity.Block{}, err
+	}
+
+	reply, err := m.messageRepository.ReplyByID(ID, replyID)
+	if err != nil {
+		return entity.Block{}, err
+	}
+	return m.block(ID, reply, mode)
+}
+
+// checkBlockMode defaults an empty mode to blocking and rejects unknown ones.
+func checkBlockMode(mode *string) error {
+	if *mode == "" {
+		*mode = entity.BlockModeBlock
+	}
+	if *mode != entity.BlockModeBlock && *mode != entity.BlockModeMute {
+		return ErrBlockModeInvalid
+	}
+	return nil
+}
+
+func (m *MessageService) block(ID int64, message entity.Message, mode string) (entity.Block, error) {
+	if message.SenderToken == "" {
+		return entity.Block{}, ErrNotBlockable
+	}
+
+	block := entity.Block{
+		ID:          gocql.TimeUUID(),
+		Mode:        mode,
+		CreatedAt:   time.Now(),
+		SenderToken: message.SenderToken,
+	}
+	if err := m.blockRepository.SaveBlock(ID, block); err != nil {
+		return entity.Block{}, err
+	}
+	return block, nil
+}
+
+func (m *MessageService) GetBlocks(ID int64) ([]entity.Block, error) {
+	return m.blockRepository.BlocksByUserID(ID)
+}
+
+func (m *MessageService) Unblock(ID int64, blockID gocql.UUID) error {
+	return m.blockRepository.DeleteBlock(ID, blockID)
+}
+
+// checkBlocked fails with ErrSenderBlocked if recipient blocked sender and
+// otherwise reports whether recipient muted them.
+func (m *MessageService) checkBlocked(recipient, sender int64) (bool, error) {
+	blocks, err := m.blockRepository.BlocksBySenderTokens(recipient, m.sealer.SenderTokens(recipient, sender))
+	if err != nil {
+		return false, err
+	}
+
+	muted := false
+	for _, block := range blocks {
+		if block.Mode == entity.BlockModeBlock {
+			return false, ErrSenderBlocked
+		}
+		muted = true
+	}
+	return muted, nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// memoryReplies is a repository.Message holding the replies one user received.
+type memoryReplies struct {
+	repository.Message
+	replies map[gocql.UUID]entity.Message
+}
+
+func (r *memoryReplies) ReplyByID(_ int64, replyID gocql.UUID) (entity.Message, error) {
+	reply, ok := r.replies[replyID]
+	if !ok {
+		return entity.Message{}, gocql.ErrNotFound
+	}
+	return reply, nil
+}
+
+// memoryBlocks is an in-memory repository.Block for one user.
+type memoryBlocks struct {
+	blocks map[string]entity.Block
+}
+
+func (b *memoryBlocks) SaveBlock(_ int64, block entity.Block) error {
+	b.blocks[block.SenderToken] = block
+	return nil
+}
+
+func (b *memoryBlocks) BlocksByUserID(int64) ([]entity.Block, error) {
+	var blocks []entity.Block
+	for _, block := range b.blocks {
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func (b *memoryBlocks) BlocksBySenderTokens(_ int64, senderTokens []string) ([]entity.Block, error) {
+	var blocks []entity.Block
+	for _, token := range senderTokens {
+		if block, ok := b.blocks[token]; ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks, nil
+}
+
+func (b *memoryBlocks) DeleteBlock(int64, gocql.UUID) error {
+	return nil
+}
+
+func TestBlockReply(t *testing.T) {
+	sealer := testSealer(t)
+	replies := &memoryReplies{replies: map[gocql.UUID]entity.Message{}}
+	blocks := &memoryBlocks{blocks: map[string]entity.Block{}}
+	m := NewMessageService(MessageRepositories{Messages: replies, Blocks: blocks}, nil, sealer, 0, 0, time.Time{}, nil)
+
+	const user, replier = 1, 2
+	reply := entity.Message{ID: gocql.TimeUUID(), ToUser: user, SenderToken: sealer.SenderToken(user, replier)}
+	replies.replies[reply.ID] = reply
+
+	if _, err := m.BlockReply(user, reply.ID, "forever"); !errors.Is(err, ErrBlockModeInvalid) {
+		t.Fatalf("BlockReply() with unknown mode = %v, want %v", err, ErrBlockModeInvalid)
+	}
+	if _, err := m.BlockReply(user, gocql.TimeUUID(), ""); err != gocql.ErrNotFound {
+		t.Fatalf("BlockReply() of unknown reply = %v, want %v", err, gocql.ErrNotFound)
+	}
+
+	block, err := m.BlockReply(user, reply.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Mode != entity.BlockModeBlock || block.SenderToken != reply.SenderToken {
+		t.Fatalf("block = %+v, want a block of the reply's sender", block)
+	}
+
+	if _, err := m.checkBlocked(user, replier); !errors.Is(err, ErrSenderBlocked) {
+		t.Fatalf("checkBlocked() = %v, want %v", err, ErrSenderBlocked)
+	}
+	if muted, err := m.checkBlocked(user, 3); muted || err != nil {
+		t.Fatalf("checkBlocked() for someone else = %v, %v; want false, nil", muted, err)
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/pkg/token"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrChallengeRequired = errors.New("proof of work is required")
+	ErrChallengeInvalid  = errors.New("challenge is invalid")
+	ErrChallengeExpired  = errors.New("challenge is expired")
+	ErrChallengeUsed     = errors.New("challenge was already used")
+	ErrSolutionInvalid   = errors.New("challenge solution is invalid")
+)
+
+// ChallengeOptions configure proof of work. Difficulty starts at
+// BaseDifficulty bits and grows by one for every VolumeStep messages the
+// inbox got within VolumeWindow, up to MaxDifficulty.
+type ChallengeOptions struct {
+	Enabled        bool
+	Secret         string
+	BaseDifficulty int
+	MaxDifficulty  int
+	TTL            time.Duration
+	VolumeWindow   time.Duration
+	VolumeStep     int64
+}
+
+type ChallengeService struct {
+	repo repository.Challenge
+	opts ChallengeOptions
+}
+
+func NewChallengeService(repo repository.Challenge, opts ChallengeOptions) *ChallengeService {
+	if opts.TTL <= 0 {
+		opts.TTL = 2 * time.Minute
+	}
+	if opts.VolumeWindow <= 0 {
+		opts.VolumeWindow = 10 * time.Minute
+	}
+	if opts.MaxDifficulty < opts.BaseDifficulty {
+		opts.MaxDifficulty = opts.BaseDifficulty
+	}
+	return &ChallengeService{repo: repo, opts: opts}
+}
+
+func (s *ChallengeService) Enabled() bool {
+	return s.opts.Enabled
+}
+
+// Issue creates a challenge for sender to message recipient, as hard as the
+// recipient's recent inbox volume calls for.
+func (s *ChallengeService) Issue(ctx context.Context, sender, recipient int64) (entity.Challenge, error) {
+	if !s.opts.Enabled {
+		return entity.Challenge{}, nil
+	}
+
+	difficulty, err := s.difficulty(ctx, recipient)
+	if err != nil {
+		return entity.Challenge{}, err
+	}
+
+	nonce, err := randomString(16)
+	if err != nil {
+		return entity.Challenge{}, err
+	}
+
+	claims := entity.ChallengeClaims{
+		Nonce:      nonce,
+		Difficulty: difficulty,
+		ExpiresAt:  time.Now().Add(s.opts.TTL).Unix(),
+		Binding:    s.binding(sender, recipient),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return entity.Challenge{}, fmt.Errorf("failed to encode challenge: %w", err)
+	}
+
+	return entity.Challenge{
+		Required:   true,
+		Challenge:  token.Sign(payload, []byte(s.opts.Secret)),
+		Difficulty: difficulty,
+		ExpiresAt:  claims.ExpiresAt,
+	}, nil
+}
+
+// Verify checks solution to a challenge issued for sender and recipient and
+// uses the challenge up. The message counts towards the recipient's volume
+// only once it's stored, through CountMessage.
+func (s *ChallengeService) Verify(ctx context.Context, challenge, solution string, sender, recipient int64) error {
+	if !s.opts.Enabled {
+		return nil
+	}
+	if challenge == "" || solution == "" {
+		return ErrChallengeRequired
+	}
+
+	payload, err := token.Verify(challenge, []byte(s.opts.Secret))
+	if err != nil {
+		return ErrChallengeInvalid
+	}
+
+	var claims entity.ChallengeClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Nonce == "" {
+		return ErrChallengeInvalid
+	}
+	if !hmac.Equal([]byte(claims.Binding), []byte(s.binding(sender, recipient))) {
+		return ErrChallengeInvalid
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return ErrChallengeExpired
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) < claims.Difficulty {
+		return ErrSolutionInvalid
+	}
+
+	claimed, err := s.repo.ClaimChallenge(ctx, claims.Nonce, expiresAt)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrChallengeUsed
+	}
+	return nil
+}
+
+// CountMessage counts a message stored in recipient's inbox towards the
+// volume that makes their challenges harder.
+func (s *ChallengeService) CountMessage(ctx context.Context, recipient int64) error {
+	if !s.opts.Enabled {
+		return nil
+	}
+
+	_, err := s.repo.Hit(ctx, []repository.RateLimit{{Key: volumeKey(recipient), Limit: math.MaxInt64}}, s.opts.VolumeWindow)
+	return err
+}
+
+func (s *ChallengeService) difficulty(ctx context.Context, recipient int64) (int, error) {
+	difficulty := s.opts.BaseDifficulty
+	if s.opts.VolumeStep <= 0 {
+		return difficulty, nil
+	}
+
+	volume, err := s.repo.Hits(ctx, volumeKey(recipient), s.opts.VolumeWindow)
+	if err != nil {
+		return 0, err
+	}
+
+	difficulty += int(volume / s.opts.VolumeStep)
+	return min(difficulty, s.opts.MaxDifficulty), nil
+}
+
+func (s *ChallengeService) binding(sender, recipient int64) string {
+	mac := hmac.New(sha256.New, []byte(s.opts.Secret))
+	mac.Write([]byte("challenge:" + strconv.FormatInt(sender, 10) + ":" + strconv.FormatInt(recipient, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func volumeKey(recipient int64) string {
+	return "pow:inbox:" + strconv.FormatInt(recipient, 10)
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"pipe/internal/repository"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// memoryChallenges is an in-memory repository.Challenge. Hits counts every
+// hit on a key, ignoring the window.
+type memoryChallenges struct {
+	claimed map[string]bool
+	hits    map[string]int64
+}
+
+func newMemoryChallenges() *memoryChallenges {
+	return &memoryChallenges{claimed: map[string]bool{}, hits: map[string]int64{}}
+}
+
+func (c *memoryChallenges) Hit(_ context.Context, limits []repository.RateLimit, _ time.Duration) (time.Duration, error) {
+	for _, limit := range limits {
+		c.hits[limit.Key]++
+	}
+	return 0, nil
+}
+
+func (c *memoryChallenges) Hits(_ context.Context, key string, _ time.Duration) (int64, error) {
+	return c.hits[key], nil
+}
+
+func (c *memoryChallenges) ClaimChallenge(_ context.Context, nonce string, _ time.Time) (bool, error) {
+	if c.claimed[nonce] {
+		return false, nil
+	}
+	c.claimed[nonce] = true
+	return true, nil
+}
+
+// solve finds a solution to challenge with at least, or with fail fewer
+// than, difficulty leading zero bits.
+func solve(challenge string, difficulty int, fail bool) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		solved := leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) >= difficulty
+		if solved != fail {
+			return solution
+		}
+	}
+}
+
+func testChallenges(repo repository.Challenge, opts ChallengeOptions) *ChallengeService {
+	opts.Enabled, opts.Secret = true, "pow-secret"
+	return NewChallengeService(repo, opts)
+}
+
+func TestChallengeVerify(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryChallenges()
+	s := testChallenges(repo, ChallengeOptions{BaseDifficulty: 8})
+
+	challenge, err := s.Issue(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.Required || challenge.Difficulty != 8 {
+		t.Fatalf("challenge = %+v, want a required challenge of difficulty 8", challenge)
+	}
+
+	solution := solve(challenge.Challenge, challenge.Difficulty, false)
+
+	tests := []struct {
+		name      string
+		challenge string
+		solution  string
+		sender    int64
+		recipient int64
+		want      error
+	}{
+		{"missing", "", "", 1, 2, ErrChallengeRequired},
+		{"forged", challenge.Challenge + "x", solution, 1, 2, ErrChallengeInvalid},
+		{"other sender", challenge.Challenge, solution, 3, 2, ErrChallengeInvalid},
+		{"other recipient", challenge.Challenge, solution, 1, 3, ErrChallengeInvalid},
+		{"wrong solution", challenge.Challenge, solve(challenge.Challenge, challenge.Difficulty, true), 1, 2, ErrSolutionInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Verify(ctx, tt.challenge, tt.solution, tt.sender, tt.recipient); !errors.Is(err, tt.want) {
+				t.Fatalf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := s.Verify(ctx, challenge.Challenge, solution, 1, 2); err != nil {
+		t.Fatalf("Verify() = %v, want nil", err)
+	}
+	if err := s.Verify(ctx, challenge.Challenge, solution, 1, 2); !errors.Is(err, ErrChallengeUsed) {
+		t.Fatalf("Verify() reusing the challenge = %v, want %v", err, ErrChallengeUsed)
+	}
+	if repo.hits[volumeKey(2)] != 0 {
+		t.Fatalf("inbox volume = %d after Verify(), want it counted only once the message is stored", repo.hits[volumeKey(2)])
+	}
+
+	if err := s.CountMessage(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if repo.hits[volumeKey(2)] != 1 {
+		t.Fatalf("inbox volume = %d, want 1", repo.hits[volumeKey(2)])
+	}
+}
+
+func TestChallengeExpired(t *testing.T) {
+	ctx := context.Background()
+	s := testChallenges(newMemoryChallenges(), ChallengeOptions{TTL: time.Nanosecond})
+
+	challenge, err := s.Issue(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(ctx, challenge.Challenge, "0", 1, 2); !errors.Is(err, ErrChallengeExpired) {
+		t.Fatalf("Verify() = %v, want %v", err, ErrChallengeExpired)
+	}
+}
+
+func TestChallengeDifficultyGrows(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryChallenges()
+	s := testChallenges(repo, ChallengeOptions{BaseDifficulty: 4, MaxDifficulty: 6, VolumeStep: 10})
+
+	for _, tt := range []struct {
+		volume int64
+		want   int
+	}{{0, 4}, {9, 4}, {10, 5}, {25, 6}, {1000, 6}} {
+		repo.hits[volumeKey(2)] = tt.volume
+		challenge, err := s.Issue(ctx, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge.Difficulty != tt.want {
+			t.Fatalf("difficulty at volume %d = %d, want %d", tt.volume, challenge.Difficulty, tt.want)
+		}
+	}
+}
+
+func TestChallengeDisabled(t *testing.T) {
+	s := NewChallengeService(newMemoryChallenges(), ChallengeOptions{})
+
+	if challenge, err := s.Issue(context.Background(), 1, 2); err != nil || challenge.Required {
+		t.Fatalf("Issue() = %+v, %v; want no challenge", challenge, err)
+	}
+	if err := s.Verify(context.Background(), "", "", 1, 2); err != nil {
+		t.Fatalf("Verify() = %v, want nil", err)
+	}
+}
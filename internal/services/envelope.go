@@ -0,0 +1,94 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pipe/internal/entity"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEnvelopeInvalid = errors.New("message is not a valid ciphertext envelope")
+	ErrMessageTooLarge = errors.New("message is too large")
+)
+
+// DefaultMaxMessageSize bounds an envelope, in bytes, when no limit is configured.
+const DefaultMaxMessageSize = 16 * 1024
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+	// minLegacySize is the smallest legacy ciphertext: an AES-GCM nonce and tag.
+	minLegacySize = nonceSize + tagSize
+)
+
+// ephemeralKeySizes is the encoded public key size of each algorithm's key
+// agreement, an uncompressed point for P-256.
+var ephemeralKeySizes = map[string]int{
+	entity.AlgorithmP256AESGCM:   65,
+	entity.AlgorithmX25519AESGCM: 32,
+}
+
+// EnvelopeVersion checks that text is a ciphertext envelope no larger than
+// maxSize bytes and returns its version. Legacy envelopes, which can't be told
+// apart from any other base64, are only accepted with allowLegacy.
+func EnvelopeVersion(text string, maxSize int, allowLegacy bool) (int, error) {
+	if len(text) > maxSize {
+		return 0, ErrMessageTooLarge
+	}
+
+	if !strings.HasPrefix(text, "{") {
+		if !allowLegacy {
+			return 0, fmt.Errorf("%w: legacy envelopes are no longer accepted", ErrEnvelopeInvalid)
+		}
+		if decodedSize(text) < minLegacySize {
+			return 0, ErrEnvelopeInvalid
+		}
+		return entity.EnvelopeLegacy, nil
+	}
+
+	var envelope entity.Envelope
+	if err := json.Unmarshal([]byte(text), &envelope); err != nil {
+		return 0, ErrEnvelopeInvalid
+	}
+
+	switch envelope.Version {
+	case entity.EnvelopeV1:
+		keySize, ok := ephemeralKeySizes[envelope.Algorithm]
+		if !ok ||
+			decodedSize(envelope.EphemeralKey) != keySize ||
+			decodedSize(envelope.Nonce) != nonceSize ||
+			decodedSize(envelope.Tag) != tagSize ||
+			decodedSize(envelope.Ciphertext) <= 0 {
+			return 0, ErrEnvelopeInvalid
+		}
+		return envelope.Version, nil
+	default:
+		return 0, ErrEnvelopeInvalid
+	}
+}
+
+// decodedSize returns the length of the standard base64 value, or -1 if
+// value isn't base64.
+func decodedSize(value string) int {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return -1
+	}
+	return len(decoded)
+}
+
+// checkEnvelope rejects message unless its text is a ciphertext envelope
+// and records the envelope's version on it. Legacy envelopes are accepted
+// until the configured cutoff.
+func (m *MessageService) checkEnvelope(message *entity.Message) error {
+	version, err := EnvelopeVersion(message.Text, m.maxMessageSize, time.Now().Before(m.legacyUntil))
+	if err != nil {
+		return err
+	}
+	message.EnvelopeVersion = version
+	return nil
+}
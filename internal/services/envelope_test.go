@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"pipe/internal/entity"
+	"strings"
+	"testing"
+)
+
+func b64(size int) string {
+	return base64.StdEncoding.EncodeToString(make([]byte, size))
+}
+
+// envelopeJSON returns a valid v1 envelope with change applied.
+func envelopeJSON(t *testing.T, change func(*entity.Envelope)) string {
+	t.Helper()
+	envelope := entity.Envelope{
+		Version:      entity.EnvelopeV1,
+		Algorithm:    entity.AlgorithmX25519AESGCM,
+		EphemeralKey: b64(32),
+		Nonce:        b64(nonceSize),
+		Ciphertext:   b64(40),
+		Tag:          b64(tagSize),
+	}
+	if change != nil {
+		change(&envelope)
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestEnvelopeVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		allowLegacy bool
+		want        int
+		wantErr     error
+	}{
+		{"x25519", envelopeJSON(t, nil), false, entity.EnvelopeV1, nil},
+		{"p256", envelopeJSON(t, func(e *entity.Envelope) {
+			e.Algorithm, e.EphemeralKey = entity.AlgorithmP256AESGCM, b64(65)
+		}), false, entity.EnvelopeV1, nil},
+		{"key size of the other algorithm", envelopeJSON(t, func(e *entity.Envelope) { e.EphemeralKey = b64(65) }), false, 0, ErrEnvelopeInvalid},
+		{"unknown algorithm", envelopeJSON(t, func(e *entity.Envelope) { e.Algorithm = "rsa-oaep" }), false, 0, ErrEnvelopeInvalid},
+		{"unknown version", envelopeJSON(t, func(e *entity.Envelope) { e.Version = 2 }), false, 0, ErrEnvelopeInvalid},
+		{"short nonce", envelopeJSON(t, func(e *entity.Envelope) { e.Nonce = b64(8) }), false, 0, ErrEnvelopeInvalid},
+		{"short tag", envelopeJSON(t, func(e *entity.Envelope) { e.Tag = b64(12) }), false, 0, ErrEnvelopeInvalid},
+		{"empty ciphertext", envelopeJSON(t, func(e *entity.Envelope) { e.Ciphertext = "" }), false, 0, ErrEnvelopeInvalid},
+		{"ciphertext not base64", envelopeJSON(t, func(e *entity.Envelope) { e.Ciphertext = "hello world" }), false, 0, ErrEnvelopeInvalid},
+		{"not json", "{hello", false, 0, ErrEnvelopeInvalid},
+		{"plaintext", "hello", true, 0, ErrEnvelopeInvalid},
+		{"legacy", b64(minLegacySize), true, entity.EnvelopeLegacy, nil},
+		{"legacy too short", b64(minLegacySize - 1), true, 0, ErrEnvelopeInvalid},
+		{"legacy after the cutoff", b64(minLegacySize), false, 0, ErrEnvelopeInvalid},
+		{"too large", b64(DefaultMaxMessageSize), true, 0, ErrMessageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EnvelopeVersion(tt.text, DefaultMaxMessageSize, tt.allowLegacy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnvelopeVersion() = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("EnvelopeVersion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelopeVersionSizeLimit(t *testing.T) {
+	text := envelopeJSON(t, nil)
+	if _, err := EnvelopeVersion(text, len(text), false); err != nil {
+		t.Fatalf("envelope of exactly the limit = %v, want nil", err)
+	}
+	if _, err := EnvelopeVersion(text+strings.Repeat(" ", 1), len(text), false); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("envelope over the limit = %v, want %v", err, ErrMessageTooLarge)
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var ErrInboxInvalid = errors.New("inbox settings are not valid")
+
+// maxInboxMinAgeDays bounds the account age an inbox can ask senders for.
+const maxInboxMinAgeDays = 365
+
+// SetInbox changes who can message user. Settings that don't apply to the
+// chosen mode are cleared.
+func (s *AccountService) SetInbox(user entity.User, req entity.InboxRequest) (entity.User, error) {
+	user.InboxMode, user.InboxMinAgeDays, user.InboxPausedUntil = req.Mode, 0, 0
+
+	switch req.Mode {
+	case entity.InboxOpen, entity.InboxPremium:
+	case entity.InboxPaused:
+		if req.PausedUntil != 0 && req.PausedUntil <= time.Now().Unix() {
+			return entity.User{}, ErrInboxInvalid
+		}
+		user.InboxPausedUntil = req.PausedUntil
+	case entity.InboxMinAge:
+		if req.MinAgeDays <= 0 || req.MinAgeDays > maxInboxMinAgeDays {
+			return entity.User{}, ErrInboxInvalid
+		}
+		user.InboxMinAgeDays = req.MinAgeDays
+	default:
+		return entity.User{}, ErrInboxInvalid
+	}
+
+	if err := s.repo.SetInbox(user); err != nil {
+		return entity.User{}, err
+	}
+	return user, nil
+}
+
+// InboxClosed returns why recipient's inbox turns sender away, which is the
+// inbox mode, or an empty string if the inbox is open to them. premium tells
+// whether sender has Telegram Premium.
+func (s *AccountService) InboxClosed(recipient entity.User, sender int64, premium bool) (string, error) {
+	switch recipient.InboxMode {
+	case entity.InboxPaused:
+		if recipient.InboxPausedUntil == 0 || time.Now().Unix() < recipient.InboxPausedUntil {
+			return entity.InboxPaused, nil
+		}
+	case entity.InboxPremium:
+		if !premium {
+			return entity.InboxPremium, nil
+		}
+	case entity.InboxMinAge:
+		u, err := s.repo.ByID(sender)
+		if err == gocql.ErrNotFound {
+			return entity.InboxMinAge, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if time.Since(u.CreatedAt) < time.Duration(recipient.InboxMinAgeDays)*24*time.Hour {
+			return entity.InboxMinAge, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"testing"
+	"time"
+)
+
+func TestSetInbox(t *testing.T) {
+	s := NewAccountService(newMemoryAccounts(), AccountOptions{})
+	user := entity.User{ID: 1, InboxMode: entity.InboxMinAge, InboxMinAgeDays: 30}
+
+	future := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name string
+		req  entity.InboxRequest
+		want error
+	}{
+		{"open", entity.InboxRequest{Mode: entity.InboxOpen, MinAgeDays: 7}, nil},
+		{"premium", entity.InboxRequest{Mode: entity.InboxPremium}, nil},
+		{"paused indefinitely", entity.InboxRequest{Mode: entity.InboxPaused}, nil},
+		{"paused until later", entity.InboxRequest{Mode: entity.InboxPaused, PausedUntil: future}, nil},
+		{"paused until the past", entity.InboxRequest{Mode: entity.InboxPaused, PausedUntil: 1}, ErrInboxInvalid},
+		{"min age", entity.InboxRequest{Mode: entity.InboxMinAge, MinAgeDays: 7}, nil},
+		{"min age missing", entity.InboxRequest{Mode: entity.InboxMinAge}, ErrInboxInvalid},
+		{"min age too long", entity.InboxRequest{Mode: entity.InboxMinAge, MinAgeDays: maxInboxMinAgeDays + 1}, ErrInboxInvalid},
+		{"unknown mode", entity.InboxRequest{Mode: "friends"}, ErrInboxInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.SetInbox(user, tt.req)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("SetInbox() = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if got.InboxMode != tt.req.Mode {
+				t.Fatalf("mode = %q, want %q", got.InboxMode, tt.req.Mode)
+			}
+			// Settings for other modes are cleared.
+			if tt.req.Mode != entity.InboxMinAge && got.InboxMinAgeDays != 0 {
+				t.Fatalf("min age = %d for mode %q, want 0", got.InboxMinAgeDays, got.InboxMode)
+			}
+			if tt.req.Mode != entity.InboxPaused && got.InboxPausedUntil != 0 {
+				t.Fatalf("paused until = %d for mode %q, want 0", got.InboxPausedUntil, got.InboxMode)
+			}
+		})
+	}
+}
+
+func TestInboxClosed(t *testing.T) {
+	accounts := newMemoryAccounts()
+	accounts.users[10] = entity.User{ID: 10, CreatedAt: time.Now().Add(-40 * 24 * time.Hour)}
+	accounts.users[11] = entity.User{ID: 11, CreatedAt: time.Now().Add(-time.Hour)}
+	s := NewAccountService(accounts, AccountOptions{})
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name      string
+		recipient entity.User
+		sender    int64
+		premium   bool
+		want      string
+	}{
+		{"open", entity.User{InboxMode: entity.InboxOpen}, 11, false, ""},
+		{"no mode", entity.User{}, 11, false, ""},
+		{"paused indefinitely", entity.User{InboxMode: entity.InboxPaused}, 10, true, entity.InboxPaused},
+		{"paused for now", entity.User{InboxMode: entity.InboxPaused, InboxPausedUntil: now + 60}, 10, true, entity.InboxPaused},
+		{"pause over", entity.User{InboxMode: entity.InboxPaused, InboxPausedUntil: now - 60}, 10, false, ""},
+		{"premium only, not premium", entity.User{InboxMode: entity.InboxPremium}, 10, false, entity.InboxPremium},
+		{"premium only, premium", entity.User{InboxMode: entity.InboxPremium}, 10, true, ""},
+		{"min age, old account", entity.User{InboxMode: entity.InboxMinAge, InboxMinAgeDays: 30}, 10, false, ""},
+		{"min age, new account", entity.User{InboxMode: entity.InboxMinAge, InboxMinAgeDays: 30}, 11, false, entity.InboxMinAge},
+		{"min age, no account", entity.User{InboxMode: entity.InboxMinAge, InboxMinAgeDays: 1}, 12, true, entity.InboxMinAge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := s.InboxClosed(tt.recipient, tt.sender, tt.premium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reason != tt.want {
+				t.Fatalf("InboxClosed() = %q, want %q", reason, tt.want)
+			}
+		})
+	}
+}
@@ -2,39 +2,346 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"pipe/internal/bus"
 	"pipe/internal/entity"
 	"pipe/internal/repository"
+	"pipe/pkg/sealed"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/redis/rueidis"
 )
 
+// MessageRepositories are the stores MessageService works with.
+type MessageRepositories struct {
+	Messages   repository.Message
+	Blocks     repository.Block
+	Moderation repository.Moderation
+	Events     repository.Events
+	Unread     repository.Unread
+}
+
 type MessageService struct {
-	messageRepository repository.Message
-	redisRepository   repository.RedisRepository
+	messageRepository    repository.Message
+	blockRepository      repository.Block
+	moderationRepository repository.Moderation
+	eventRepository      repository.Events
+	unreadRepository     repository.Unread
+	bus                  bus.Bus
+	sealer               *sealed.Sealer
+	defaultTTL           time.Duration
+	maxMessageSize       int
+	legacyUntil          time.Time
+	attachments          *AttachmentService
+	reads                *readQueue
 }
 
-func NewMessageService(messageRepository repository.Message, redisRepository repository.RedisRepository) *MessageService {
-	return &MessageService{messageRepository: messageRepository, redisRepository: redisRepository}
+func NewMessageService(
+	repos MessageRepositories,
+	eventBus bus.Bus,
+	sealer *sealed.Sealer,
+	defaultTTL time.Duration,
+	maxMessageSize int,
+	legacyUntil time.Time,
+	attachments *AttachmentService,
+) *MessageService {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	return &MessageService{
+		messageRepository:    repos.Messages,
+		blockRepository:      repos.Blocks,
+		moderationRepository: repos.Moderation,
+		eventRepository:      repos.Events,
+		unreadRepository:     repos.Unread,
+		bus:                  eventBus,
+		sealer:               sealer,
+		defaultTTL:           defaultTTL,
+		maxMessageSize:       maxMessageSize,
+		legacyUntil:          legacyUntil,
+		attachments:          attachments,
+		reads:                newReadQueue(),
+	}
 }
 
-func (m *MessageService) Send(message entity.Message) error {
-	return m.messageRepository.Send(message)
+// Send stores message from sender in recipient's inbox for the recipient's
+// retention period and returns it with its expiry set. Only a sealed sender
+// token is stored, never the sender's ID.
+func (m *MessageService) Send(ctx context.Context, message entity.Message, sender int64, recipient entity.User) (entity.Message, error) {
+	return m.send(ctx, message, sender, recipient, recipient.PrivateID)
+}
+
+// send stores message along with the sender's copy, which names the
+// recipient only by privateID, if at all, and counts it as unread. It fails
+// with ErrSenderBanned if sender is banned and ErrSenderBlocked if recipient
+// blocked them; messages from muted senders are stored but not counted.
+func (m *MessageService) send(ctx context.Context, message entity.Message, sender int64, recipient entity.User, privateID string) (entity.Message, error) {
+	if err := m.checkEnvelope(&message); err != nil {
+		return entity.Message{}, err
+	}
+
+	if err := m.checkBanned(sender); err != nil {
+		return entity.Message{}, err
+	}
+
+	muted, err := m.checkBlocked(recipient.ID, sender)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	message.Muted = muted
+
+	ttl, err := m.seal(&message, sender, recipient)
+	if err != nil {
+		return entity.Message{}, err
+	}
+
+	var attachments []entity.Attachment
+	if len(message.Attachments) > 0 {
+		if attachments, err = m.attachments.linked(sender, message); err != nil {
+			return entity.Message{}, err
+		}
+		if err := m.attachments.claim(message, attachments); err != nil {
+			return entity.Message{}, err
+		}
+	}
+
+	if err := m.messageRepository.Send(message, m.sentCopy(message, sender, privateID), attachments, ttl); err != nil {
+		if len(attachments) > 0 {
+			m.attachments.unclaim(message, attachments)
+		}
+		return entity.Message{}, err
+	}
+	if len(attachments) > 0 {
+		m.attachments.sent(ctx, attachments)
+	}
+
+	if muted {
+		return message, nil
+	}
+
+	if err := m.unreadRepository.AddUnread(ctx, recipient.ID, message.ID.String(), message.ExpiresAt); err != nil {
+		return entity.Message{}, err
+	}
+	return message, nil
 }
 
-func (m *MessageService) GetUserMessages(ID int64) ([]entity.Message, error) {
-	return m.messageRepository.ByUserID(ID)
+// seal replaces sender on message with its sealed token and reference, sets
+// the expiry from recipient's retention and returns the TTL to store it with.
+func (m *MessageService) seal(message *entity.Message, sender int64, recipient entity.User) (time.Duration, error) {
+	senderRef, err := m.sealer.SealSender(recipient.ID, sender)
+	if err != nil {
+		return 0, err
+	}
+	message.SenderToken = m.sealer.SenderToken(recipient.ID, sender)
+	message.SenderRef = senderRef
+
+	ttl := effectiveTTL(recipient.Retention, m.defaultTTL)
+	if ttl > 0 {
+		message.ExpiresAt = message.Date + int64(ttl.Seconds())
+	}
+	return ttl, nil
 }
 
-func (m *MessageService) DeleteAll(ID int64) error {
-	return m.messageRepository.DeleteAllByUserID(ID)
+func (m *MessageService) GetUserMessages(ID int64, limit int, cursor []byte) ([]entity.Message, []byte, error) {
+	return m.messageRepository.ByUserID(ID, limit, cursor)
 }
 
-func (m *MessageService) AddToRedis(ctx context.Context, userID int64, message string) error {
-	return m.redisRepository.PushMessage(ctx, userID, message)
+func (m *MessageService) GetUserMessage(ID int64, messageID gocql.UUID) (entity.Message, error) {
+	return m.messageRepository.ByID(ID, messageID)
 }
 
-func (m *MessageService) GetRedisMessages(ctx context.Context, userID, start, stop int64) ([]string, error) {
-	return m.redisRepository.GetMessages(ctx, userID, start, stop)
+// Delete removes one of ID's messages, including any copy still waiting for delivery.
+func (m *MessageService) Delete(ctx context.Context, ID int64, messageID gocql.UUID) error {
+	message, err := m.messageRepository.ByID(ID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if err := m.messageRepository.Delete(message); err != nil {
+		return err
+	}
+
+	if err := m.attachments.deleteFor(ctx, message); err != nil {
+		return err
+	}
+
+	if err := m.eventRepository.RemoveMessage(ctx, ID, messageID.String()); err != nil {
+		return err
+	}
+
+	if err := m.unreadRepository.RemoveUnread(ctx, ID, messageID.String()); err != nil {
+		return err
+	}
+
+	return m.PublishEvent(ctx, ID, entity.Event{
+		Type:      entity.EventMessageDeleted,
+		MessageID: messageID.String(),
+	})
+}
+
+// DeleteAll clears ID's inbox, including messages still waiting for delivery.
+func (m *MessageService) DeleteAll(ctx context.Context, ID int64) error {
+	if err := m.attachments.DeleteForUser(ctx, ID); err != nil {
+		return err
+	}
+
+	if err := m.messageRepository.DeleteAllByUserID(ID); err != nil {
+		return err
+	}
+
+	if err := m.eventRepository.ClearMessages(ctx, ID); err != nil {
+		return err
+	}
+
+	return m.unreadRepository.ClearUnread(ctx, ID)
+}
+
+// replayLimit caps how many missed messages are replayed on reconnect.
+const replayLimit = 500
+
+// EventsAfter returns new-message events for ID's messages newer than
+// messageID, oldest first.
+func (m *MessageService) EventsAfter(ID int64, messageID gocql.UUID) ([]entity.Event, error) {
+	after := messageID.Time()
+	messages, err := m.messageRepository.Since(ID, after.Unix(), replayLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []entity.Event{}
+	for _, message := range messages {
+		if message.ID == messageID || message.ID.Time().Before(after) {
+			continue
+		}
+		events = append(events, messageEvent(message))
+	}
+	return events, nil
 }
 
-func (m *MessageService) ListenForNewMessage(ctx context.Context, userID int64, timeout float64) ([]string, error) {
-	return m.redisRepository.WaitForNewMessage(ctx, userID, timeout)
+// Ack acknowledges every event delivered to deviceID up to and including streamID.
+func (m *MessageService) Ack(ctx context.Context, userID int64, deviceID, streamID string) error {
+	return m.eventRepository.AckEvents(ctx, userID, deviceID, streamID)
+}
+
+// DeliverMessage queues a new-message event for userID's realtime clients.
+func (m *MessageService) DeliverMessage(ctx context.Context, userID int64, message entity.Message) error {
+	return m.PublishEvent(ctx, userID, messageEvent(message))
+}
+
+func (m *MessageService) PublishEvent(ctx context.Context, userID int64, event entity.Event) error {
+	if event.ID == "" {
+		event.ID = gocql.TimeUUID().String()
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	if event.StreamID, err = m.eventRepository.AppendEvent(ctx, userID, string(eventJSON)); err != nil {
+		return err
+	}
+
+	if err := m.bus.Publish(ctx, userID, event); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// AccountDeleted announces that userID's account is gone. The event isn't
+// stored in the stream, which is deleted with the account.
+func (m *MessageService) AccountDeleted(ctx context.Context, userID int64) error {
+	return m.bus.Publish(ctx, userID, entity.Event{
+		ID:   gocql.TimeUUID().String(),
+		Type: entity.EventAccountDeleted,
+	})
+}
+
+// eventBatch caps how many events are read from the stream at once.
+const eventBatch = 100
+
+// PendingEvents returns the events delivered to deviceID that it hasn't
+// acknowledged yet, oldest first, starting after the stream entry after.
+func (m *MessageService) PendingEvents(ctx context.Context, userID int64, deviceID, after string) ([]entity.Event, error) {
+	if after == "" {
+		after = "0"
+	}
+
+	entries, err := m.eventRepository.PendingEvents(ctx, userID, deviceID, after, eventBatch)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEvents(entries)
+}
+
+// WaitForEvents waits for up to timeout seconds (forever if zero) until
+// events deviceID hasn't seen yet arrive for userID. It returns rueidis.Nil
+// on timeout.
+func (m *MessageService) WaitForEvents(ctx context.Context, userID int64, deviceID string, timeout float64) ([]entity.Event, error) {
+	// Subscribe before reading so nothing published in between is missed.
+	notify, unsubscribe := m.bus.Subscribe(userID)
+	defer unsubscribe()
+
+	events, err := m.newEvents(ctx, userID, deviceID)
+	if err != nil || len(events) > 0 {
+		return events, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout * float64(time.Second)))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-expired:
+		return nil, rueidis.Nil
+	case <-notify:
+	}
+
+	// Another connection of the same device may have taken the events.
+	if events, err = m.newEvents(ctx, userID, deviceID); err == nil && len(events) == 0 {
+		return nil, rueidis.Nil
+	}
+	return events, err
+}
+
+// newEvents reads the events deviceID hasn't seen yet without blocking.
+func (m *MessageService) newEvents(ctx context.Context, userID int64, deviceID string) ([]entity.Event, error) {
+	entries, err := m.eventRepository.ReadEvents(ctx, userID, deviceID, eventBatch, -1)
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeEvents(entries)
+}
+
+func messageEvent(message entity.Message) entity.Event {
+	return entity.Event{
+		ID:      message.ID.String(),
+		Type:    entity.EventNewMessage,
+		Message: &message,
+	}
+}
+
+// decodeEvents parses stream entries into events tagged with their stream ID.
+func decodeEvents(entries []repository.StreamEntry) ([]entity.Event, error) {
+	events := make([]entity.Event, 0, len(entries))
+	for _, entry := range entries {
+		var event entity.Event
+		if err := json.Unmarshal([]byte(entry.Event), &event); err != nil {
+			return nil, err
+		}
+
+		event.StreamID = entry.ID
+		events = append(events, event)
+	}
+	return events, nil
 }
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pipe/internal/bus"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// memoryInbox is a repository.Message holding each user's inbox.
+type memoryInbox struct {
+	repository.Message
+	inboxes map[int64][]entity.Message
+}
+
+func (i *memoryInbox) ByID(ID int64, messageID gocql.UUID) (entity.Message, error) {
+	for _, message := range i.inboxes[ID] {
+		if message.ID == messageID {
+			return message, nil
+		}
+	}
+	return entity.Message{}, gocql.ErrNotFound
+}
+
+func (i *memoryInbox) ByUserID(ID int64, _ int, _ []byte) ([]entity.Message, []byte, error) {
+	return i.inboxes[ID], nil, nil
+}
+
+func (i *memoryInbox) Delete(message entity.Message) error {
+	i.inboxes[message.ToUser] = slices.DeleteFunc(i.inboxes[message.ToUser], func(m entity.Message) bool {
+		return m.ID == message.ID
+	})
+	return nil
+}
+
+func (i *memoryInbox) DeleteAllByUserID(ID int64) error {
+	delete(i.inboxes, ID)
+	return nil
+}
+
+// memoryEvents is a repository.Events recording what was removed from the
+// event streams.
+type memoryEvents struct {
+	repository.Events
+	appended []string
+	removed  []string
+	cleared  []int64
+}
+
+func (e *memoryEvents) AppendEvent(_ context.Context, _ int64, event string) (string, error) {
+	e.appended = append(e.appended, event)
+	return "1-0", nil
+}
+
+func (e *memoryEvents) RemoveMessage(_ context.Context, _ int64, messageID string) error {
+	e.removed = append(e.removed, messageID)
+	return nil
+}
+
+func (e *memoryEvents) ClearMessages(_ context.Context, userID int64) error {
+	e.cleared = append(e.cleared, userID)
+	return nil
+}
+
+// memoryUnread is a repository.Unread recording what was marked read.
+type memoryUnread struct {
+	repository.Unread
+	removed []string
+	cleared []int64
+}
+
+func (u *memoryUnread) RemoveUnread(_ context.Context, _ int64, messageIDs ...string) error {
+	u.removed = append(u.removed, messageIDs...)
+	return nil
+}
+
+func (u *memoryUnread) ClearUnread(_ context.Context, userID int64) error {
+	u.cleared = append(u.cleared, userID)
+	return nil
+}
+
+func newDeleteTest(t *testing.T) (*MessageService, *memoryInbox, *memoryEvents, *memoryUnread, *memoryAttachments) {
+	t.Helper()
+	inbox := &memoryInbox{inboxes: map[int64][]entity.Message{}}
+	events := &memoryEvents{}
+	unread := &memoryUnread{}
+	attachments, attachmentRepo, _ := newTestAttachments(t, AttachmentOptions{})
+	attachments.messages = inbox
+
+	m := NewMessageService(MessageRepositories{
+		Messages: inbox,
+		Events:   events,
+		Unread:   unread,
+	}, bus.NewLocal(), testSealer(t), 0, 0, time.Time{}, attachments)
+	return m, inbox, events, unread, attachmentRepo
+}
+
+func TestDeleteOwnMessageOnly(t *testing.T) {
+	m, inbox, events, unread, attachments := newDeleteTest(t)
+	ctx := context.Background()
+
+	attachment, err := m.attachments.Upload(ctx, 3, strings.NewReader("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine := entity.Message{ID: gocql.TimeUUID(), ToUser: 1, Attachments: []gocql.UUID{attachment.ID}}
+	theirs := entity.Message{ID: gocql.TimeUUID(), ToUser: 2}
+	inbox.inboxes[1] = []entity.Message{mine}
+	inbox.inboxes[2] = []entity.Message{theirs}
+
+	if err := m.Delete(ctx, 1, theirs.ID); !errors.Is(err, gocql.ErrNotFound) {
+		t.Fatalf("deleting another user's message = %v, want %v", err, gocql.ErrNotFound)
+	}
+	if len(inbox.inboxes[2]) != 1 || len(events.removed) != 0 || len(unread.removed) != 0 {
+		t.Fatal("deleting another user's message changed something")
+	}
+
+	if err := m.Delete(ctx, 1, mine.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox.inboxes[1]) != 0 {
+		t.Fatal("message is still in the inbox")
+	}
+	if _, ok := attachments.attachments[attachment.ID]; ok {
+		t.Fatal("message's attachment wasn't deleted")
+	}
+	id := mine.ID.String()
+	if !slices.Contains(events.removed, id) || !slices.Contains(unread.removed, id) {
+		t.Fatalf("removed from the stream %v and unread %v, want %s in both", events.removed, unread.removed, id)
+	}
+	if len(events.appended) != 1 || !strings.Contains(events.appended[0], entity.EventMessageDeleted) {
+		t.Fatalf("appended events %v, want one %s", events.appended, entity.EventMessageDeleted)
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	m, inbox, events, unread, attachments := newDeleteTest(t)
+	ctx := context.Background()
+
+	attachment, err := m.attachments.Upload(ctx, 3, strings.NewReader("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox.inboxes[1] = []entity.Message{
+		{ID: gocql.TimeUUID(), ToUser: 1, Attachments: []gocql.UUID{attachment.ID}},
+		{ID: gocql.TimeUUID(), ToUser: 1},
+	}
+	inbox.inboxes[2] = []entity.Message{{ID: gocql.TimeUUID(), ToUser: 2}}
+
+	if err := m.DeleteAll(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox.inboxes[1]) != 0 || len(inbox.inboxes[2]) != 1 {
+		t.Fatalf("inboxes after DeleteAll = %v, want only user 1's cleared", inbox.inboxes)
+	}
+	if _, ok := attachments.attachments[attachment.ID]; ok {
+		t.Fatal("attachment of a cleared message wasn't deleted")
+	}
+	if !slices.Equal(events.cleared, []int64{1}) || !slices.Equal(unread.cleared, []int64{1}) {
+		t.Fatalf("cleared streams %v and unread %v, want user 1's", events.cleared, unread.cleared)
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"pipe/internal/entity"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// GetSentMessages returns one page of the messages and replies ID has sent.
+func (m *MessageService) GetSentMessages(ID int64, limit int, cursor []byte) ([]entity.SentMessage, []byte, error) {
+	sent, next, err := m.messageRepository.SentByOutboxTokens(m.sealer.OutboxTokens(ID), limit, cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range sent {
+		sent[i].Status = sentStatus(sent[i])
+	}
+	return sent, next, nil
+}
+
+// DeleteOutbox removes the copies of everything ID has sent, ahead of their
+// account being deleted. The recipients keep their messages.
+func (m *MessageService) DeleteOutbox(ID int64) error {
+	return m.messageRepository.DeleteSentByOutboxTokens(m.sealer.OutboxTokens(ID))
+}
+
+// MarkDelivered records that the messages and replies carried by events
+// reached one of userID's devices. Events carry each message's date, so only
+// the sealed sender is read back, by the message's full key.
+func (m *MessageService) MarkDelivered(userID int64, events []entity.Event) error {
+	now := time.Now().Unix()
+
+	for _, event := range events {
+		if event.Message == nil {
+			continue
+		}
+
+		var (
+			senderRef string
+			err       error
+		)
+		switch event.Type {
+		case entity.EventNewMessage:
+			senderRef, err = m.messageRepository.SenderRef(userID, event.Message.Date, event.Message.ID)
+		case entity.EventNewReply:
+			senderRef, err = m.messageRepository.ReplySenderRef(userID, event.Message.Date, event.Message.ID)
+		default:
+			continue
+		}
+		if err != nil {
+			if err == gocql.ErrNotFound {
+				continue
+			}
+			return err
+		}
+
+		sent, ok, err := m.sentCopyOf(entity.Message{
+			ID:        event.Message.ID,
+			ToUser:    userID,
+			Date:      event.Message.Date,
+			ExpiresAt: event.Message.ExpiresAt,
+			SenderRef: senderRef,
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := m.messageRepository.MarkSentDelivered(sent, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sentCopy builds the sender's copy of message.
+func (m *MessageService) sentCopy(message entity.Message, sender int64, privateID string) entity.SentMessage {
+	return entity.SentMessage{
+		OutboxToken:     m.sealer.OutboxToken(sender),
+		ID:              message.ID,
+		PrivateID:       privateID,
+		InReplyTo:       message.InReplyTo,
+		Text:            message.Text,
+		EnvelopeVersion: message.EnvelopeVersion,
+		Date:            message.Date,
+		ExpiresAt:       message.ExpiresAt,
+	}
+}
+
+// sentCopyOf locates the sender's copy of a stored message through its
+// sealed sender. ok is false for messages sent without one.
+func (m *MessageService) sentCopyOf(message entity.Message) (entity.SentMessage, bool, error) {
+	if message.SenderRef == "" {
+		return entity.SentMessage{}, false, nil
+	}
+
+	sender, err := m.openSender(message)
+	if err != nil {
+		if err == ErrNotReplyable {
+			return entity.SentMessage{}, false, nil
+		}
+		return entity.SentMessage{}, false, err
+	}
+
+	outboxToken, err := m.sealer.RefOutboxToken(sender, message.SenderRef)
+	if err != nil {
+		return entity.SentMessage{}, false, nil
+	}
+
+	return entity.SentMessage{
+		OutboxToken: outboxToken,
+		ID:          message.ID,
+		Date:        message.Date,
+		ExpiresAt:   message.ExpiresAt,
+	}, true, nil
+}
+
+func sentStatus(sent entity.SentMessage) string {
+	switch {
+	case sent.ReadAt > 0:
+		return entity.SentRead
+	case sent.DeliveredAt > 0:
+		return entity.SentDelivered
+	default:
+		return entity.SentStored
+	}
+}
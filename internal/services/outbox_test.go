@@ -0,0 +1,111 @@
+package services
+
+import (
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"pipe/pkg/sealed"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// memoryOutbox is a repository.Message holding sealed senders by message
+// key. Anything else, such as the filtering ByID lookup, isn't implemented.
+type memoryOutbox struct {
+	repository.Message
+	refs      map[gocql.UUID]string
+	delivered []entity.SentMessage
+	deleted   []string
+}
+
+func (o *memoryOutbox) DeleteSentByOutboxTokens(tokens []string) error {
+	o.deleted = append(o.deleted, tokens...)
+	return nil
+}
+
+func (o *memoryOutbox) SenderRef(_ int64, _ int64, messageID gocql.UUID) (string, error) {
+	ref, ok := o.refs[messageID]
+	if !ok {
+		return "", gocql.ErrNotFound
+	}
+	return ref, nil
+}
+
+func (o *memoryOutbox) ReplySenderRef(ID int64, date int64, replyID gocql.UUID) (string, error) {
+	return o.SenderRef(ID, date, replyID)
+}
+
+func (o *memoryOutbox) MarkSentDelivered(sent entity.SentMessage, _ int64) error {
+	o.delivered = append(o.delivered, sent)
+	return nil
+}
+
+func testSealer(t *testing.T) *sealed.Sealer {
+	t.Helper()
+	sealer, err := sealed.NewSealer([]sealed.Key{{Version: "1", Secret: []byte("secret")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer
+}
+
+func TestMarkDelivered(t *testing.T) {
+	sealer := testSealer(t)
+	outbox := &memoryOutbox{refs: map[gocql.UUID]string{}}
+	m := NewMessageService(MessageRepositories{Messages: outbox}, nil, sealer, 0, 0, time.Time{}, nil)
+
+	const recipient, sender = 1, 2
+	ref, err := sealer.SealSender(recipient, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	message := entity.Message{ID: gocql.TimeUUID(), Date: now, ExpiresAt: now + 60}
+	reply := entity.Message{ID: gocql.TimeUUID(), Date: now}
+	gone := entity.Message{ID: gocql.TimeUUID(), Date: now}
+	legacy := entity.Message{ID: gocql.TimeUUID(), Date: now}
+	outbox.refs[message.ID] = ref
+	outbox.refs[reply.ID] = ref
+	outbox.refs[legacy.ID] = ""
+
+	err = m.MarkDelivered(recipient, []entity.Event{
+		{Type: entity.EventNewMessage, Message: &message},
+		{Type: entity.EventNewReply, Message: &reply},
+		{Type: entity.EventNewMessage, Message: &gone},
+		{Type: entity.EventNewMessage, Message: &legacy},
+		{Type: entity.EventMessageDeleted, MessageID: message.ID.String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outbox.delivered) != 2 {
+		t.Fatalf("marked %d sent copies delivered, want 2", len(outbox.delivered))
+	}
+	first := outbox.delivered[0]
+	if first.ID != message.ID || first.OutboxToken != sealer.OutboxToken(sender) || first.Date != message.Date || first.ExpiresAt != message.ExpiresAt {
+		t.Fatalf("sent copy = %+v, want the message's key and expiry in the sender's outbox", first)
+	}
+	if outbox.delivered[1].ID != reply.ID {
+		t.Fatalf("second sent copy = %+v, want the reply", outbox.delivered[1])
+	}
+}
+
+func TestDeleteOutbox(t *testing.T) {
+	old := testSealer(t)
+	sealer, err := sealed.NewSealer([]sealed.Key{{Version: "2", Secret: []byte("newer")}, {Version: "1", Secret: []byte("secret")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox := &memoryOutbox{}
+	m := NewMessageService(MessageRepositories{Messages: outbox}, nil, sealer, 0, 0, time.Time{}, nil)
+
+	if err := m.DeleteOutbox(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox.deleted) != 2 || outbox.deleted[0] != sealer.OutboxToken(2) || outbox.deleted[1] != old.OutboxToken(2) {
+		t.Fatalf("deleted outboxes %v, want the ones under the current and the rotated key", outbox.deleted)
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"pipe/internal/repository"
+	"pipe/pkg/sealed"
+	"strconv"
+	"time"
+)
+
+// RateLimitOptions caps how many messages, reports and attachment uploads
+// fit in one sliding Window. A zero limit disables that check.
+type RateLimitOptions struct {
+	Window    time.Duration
+	Sender    int64
+	IP        int64
+	Recipient int64
+	Pair      int64
+	Reporter  int64
+	Uploader  int64
+}
+
+type RateLimitService struct {
+	repo   repository.Limiter
+	sealer *sealed.Sealer
+	opts   RateLimitOptions
+}
+
+func NewRateLimitService(repo repository.Limiter, sealer *sealed.Sealer, opts RateLimitOptions) *RateLimitService {
+	if opts.Window <= 0 {
+		opts.Window = time.Minute
+	}
+	return &RateLimitService{repo: repo, sealer: sealer, opts: opts}
+}
+
+// AllowSend counts a message from sender, connecting from ip, to recipient
+// and returns how long the sender has to wait if any limit is exceeded. The
+// pair is keyed by the sender token so Redis never links the two users.
+func (s *RateLimitService) AllowSend(ctx context.Context, ip string, sender, recipient int64) (time.Duration, error) {
+	var limits []repository.RateLimit
+	add := func(key string, limit int64) {
+		if limit > 0 {
+			limits = append(limits, repository.RateLimit{Key: "ratelimit:send:" + key, Limit: limit})
+		}
+	}
+
+	add("sender:"+strconv.FormatInt(sender, 10), s.opts.Sender)
+	add("ip:"+ip, s.opts.IP)
+	add("recipient:"+strconv.FormatInt(recipient, 10), s.opts.Recipient)
+	add("pair:"+s.sealer.SenderToken(recipient, sender), s.opts.Pair)
+
+	return s.repo.Hit(ctx, limits, s.opts.Window)
+}
+
+// AllowReport counts a report by reporter and returns how long they have to
+// wait if they're over the limit.
+func (s *RateLimitService) AllowReport(ctx context.Context, reporter int64) (time.Duration, error) {
+	if s.opts.Reporter <= 0 {
+		return 0, nil
+	}
+	return s.repo.Hit(ctx, []repository.RateLimit{{
+		Key:   "ratelimit:report:" + strconv.FormatInt(reporter, 10),
+		Limit: s.opts.Reporter,
+	}}, s.opts.Window)
+}
+
+// AllowUpload counts an attachment upload by uploader and returns how long
+// they have to wait if they're over the limit.
+func (s *RateLimitService) AllowUpload(ctx context.Context, uploader int64) (time.Duration, error) {
+	if s.opts.Uploader <= 0 {
+		return 0, nil
+	}
+	return s.repo.Hit(ctx, []repository.RateLimit{{
+		Key:   "ratelimit:upload:" + strconv.FormatInt(uploader, 10),
+		Limit: s.opts.Uploader,
+	}}, s.opts.Window)
+}
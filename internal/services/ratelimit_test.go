@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"pipe/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingLimiter is a repository.Limiter that records the limits of each hit.
+type recordingLimiter struct {
+	repository.Limiter
+	limits []repository.RateLimit
+	window time.Duration
+}
+
+func (l *recordingLimiter) Hit(_ context.Context, limits []repository.RateLimit, window time.Duration) (time.Duration, error) {
+	l.limits, l.window = limits, window
+	return 0, nil
+}
+
+func TestAllowSendLimits(t *testing.T) {
+	sealer := testSealer(t)
+	limiter := &recordingLimiter{}
+	s := NewRateLimitService(limiter, sealer, RateLimitOptions{Sender: 30, IP: 60, Pair: 10})
+
+	if _, err := s.AllowSend(context.Background(), "203.0.113.7", 1001, 2002); err != nil {
+		t.Fatal(err)
+	}
+
+	if limiter.window != time.Minute {
+		t.Fatalf("window = %v, want the one minute default", limiter.window)
+	}
+
+	want := map[string]int64{
+		"ratelimit:send:sender:1001":                            30,
+		"ratelimit:send:ip:203.0.113.7":                         60,
+		"ratelimit:send:pair:" + sealer.SenderToken(2002, 1001): 10,
+	}
+	if len(limiter.limits) != len(want) {
+		t.Fatalf("limits = %+v, want %v; a zero limit must be left out", limiter.limits, want)
+	}
+	for _, limit := range limiter.limits {
+		if want[limit.Key] != limit.Limit {
+			t.Fatalf("limit %s = %d, want %d", limit.Key, limit.Limit, want[limit.Key])
+		}
+		if strings.Contains(limit.Key, "1001") && strings.Contains(limit.Key, "2002") {
+			t.Fatalf("key %s links sender and recipient", limit.Key)
+		}
+	}
+}
+
+func TestAllowReport(t *testing.T) {
+	limiter := &recordingLimiter{}
+	s := NewRateLimitService(limiter, testSealer(t), RateLimitOptions{})
+
+	if wait, err := s.AllowReport(context.Background(), 1); wait != 0 || err != nil || limiter.limits != nil {
+		t.Fatalf("AllowReport() with no limit = %v, %v and hit %v; want no hit", wait, err, limiter.limits)
+	}
+
+	s = NewRateLimitService(limiter, testSealer(t), RateLimitOptions{Reporter: 5})
+	if _, err := s.AllowReport(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(limiter.limits) != 1 || limiter.limits[0].Key != "ratelimit:report:1" || limiter.limits[0].Limit != 5 {
+		t.Fatalf("limits = %+v, want one report limit of 5", limiter.limits)
+	}
+}
+
+func TestAllowUpload(t *testing.T) {
+	limiter := &recordingLimiter{}
+	s := NewRateLimitService(limiter, testSealer(t), RateLimitOptions{})
+
+	if wait, err := s.AllowUpload(context.Background(), 1); wait != 0 || err != nil || limiter.limits != nil {
+		t.Fatalf("AllowUpload() with no limit = %v, %v and hit %v; want no hit", wait, err, limiter.limits)
+	}
+
+	s = NewRateLimitService(limiter, testSealer(t), RateLimitOptions{Uploader: 20})
+	if _, err := s.AllowUpload(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(limiter.limits) != 1 || limiter.limits[0].Key != "ratelimit:upload:1" || limiter.limits[0].Limit != 20 {
+		t.Fatalf("limits = %+v, want one upload limit of 20", limiter.limits)
+	}
+}
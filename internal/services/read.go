@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"log"
+	"pipe/internal/entity"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// readPageSize is how many messages MarkAllRead loads at a time.
+const readPageSize = 100
+
+// MarkRead marks one of ID's messages read, which its sender sees in their
+// outbox.
+func (m *MessageService) MarkRead(ctx context.Context, ID int64, messageID gocql.UUID) error {
+	message, err := m.messageRepository.ByID(ID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if message.ReadAt > 0 {
+		return nil
+	}
+	return m.markRead(ctx, message, time.Now().Unix())
+}
+
+// MarkAllRead clears ID's unread count and returns what it was. The
+// messages themselves, and their senders' outboxes, are marked read in the
+// background so the request doesn't wait on a write per message.
+func (m *MessageService) MarkAllRead(ctx context.Context, ID int64) (int, error) {
+	unread, err := m.unreadRepository.UnreadCount(ctx, ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Messages dated after now arrived after the count was cleared and stay
+	// unread.
+	now := time.Now().Unix()
+	if err := m.unreadRepository.ClearUnread(ctx, ID); err != nil {
+		return 0, err
+	}
+
+	m.reads.start(ID, now, m.markAllRead)
+	return int(unread), nil
+}
+
+// Shutdown waits for background MarkAllRead passes to finish and stops new
+// ones from starting. Passes still running when ctx is done stop after
+// their current page.
+func (m *MessageService) Shutdown(ctx context.Context) error {
+	return m.reads.shutdown(ctx)
+}
+
+// markAllRead marks every unread message in ID's inbox dated at or before
+// at. Unread entries are removed again once per page, for messages that
+// arrived in the same second as the request but after the count was cleared.
+func (m *MessageService) markAllRead(ctx context.Context, ID int64, at int64) {
+	var (
+		marked int
+		cursor []byte
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopped marking messages read for UserID: %d after %d, Error: %v\n", ID, marked, err)
+			return
+		}
+
+		messages, next, err := m.messageRepository.ByUserID(ID, readPageSize, cursor)
+		if err != nil {
+			log.Printf("Failed to load messages to mark read for UserID: %d after %d, Error: %v\n", ID, marked, err)
+			return
+		}
+
+		var read []string
+		for _, message := range messages {
+			if message.ReadAt > 0 || message.Date > at {
+				continue
+			}
+			message.ToUser = ID
+			if err := m.markMessageRead(message, at); err != nil {
+				log.Printf("Failed to mark message %s read for UserID: %d, Error: %v\n", message.ID, ID, err)
+				continue
+			}
+			read = append(read, message.ID.String())
+		}
+
+		if len(read) > 0 {
+			if err := m.unreadRepository.RemoveUnread(ctx, ID, read...); err != nil {
+				log.Printf("Failed to remove unread messages for UserID: %d, Error: %v\n", ID, err)
+			}
+			marked += len(read)
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	log.Printf("%d messages marked read in the background for UserID: %d\n", marked, ID)
+}
+
+func (m *MessageService) UnreadCount(ctx context.Context, ID int64) (int64, error) {
+	return m.unreadRepository.UnreadCount(ctx, ID)
+}
+
+func (m *MessageService) markRead(ctx context.Context, message entity.Message, at int64) error {
+	if err := m.markMessageRead(message, at); err != nil {
+		return err
+	}
+	return m.unreadRepository.RemoveUnread(ctx, message.ToUser, message.ID.String())
+}
+
+// markMessageRead marks message read in the inbox and in its sender's outbox.
+func (m *MessageService) markMessageRead(message entity.Message, at int64) error {
+	if err := m.messageRepository.MarkRead(message, at); err != nil {
+		return err
+	}
+
+	sent, ok, err := m.sentCopyOf(message)
+	if err != nil || !ok {
+		return err
+	}
+	return m.messageRepository.MarkSentRead(sent, at)
+}
+
+// readQueue runs at most one background MarkAllRead pass per user. Requests
+// made while a pass runs are folded into one more pass afterwards.
+type readQueue struct {
+	mu      sync.Mutex
+	pending map[int64]int64
+	closed  bool
+	running sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newReadQueue() *readQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &readQueue{pending: map[int64]int64{}, ctx: ctx, cancel: cancel}
+}
+
+// start runs mark for ID in the background with at, unless a pass for ID is
+// already running, in which case that pass runs again with at when done.
+// Nothing is started once the queue is shut down.
+func (q *readQueue) start(ID int64, at int64, mark func(ctx context.Context, ID int64, at int64)) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		log.Printf("Not marking messages read for UserID: %d, shutting down\n", ID)
+		return
+	}
+	_, running := q.pending[ID]
+	q.pending[ID] = at
+	if !running {
+		q.running.Add(1)
+	}
+	q.mu.Unlock()
+	if running {
+		return
+	}
+
+	go func() {
+		defer q.running.Done()
+		for {
+			mark(q.ctx, ID, at)
+
+			q.mu.Lock()
+			if q.pending[ID] == at {
+				delete(q.pending, ID)
+				q.mu.Unlock()
+				return
+			}
+			at = q.pending[ID]
+			q.mu.Unlock()
+		}
+	}()
+}
+
+// shutdown stops new passes and waits for the running ones until ctx is
+// done, when it cancels them and waits for them to stop.
+func (q *readQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadQueueFoldsRequests(t *testing.T) {
+	q := newReadQueue()
+
+	release := make(chan struct{})
+	runs := make(chan int64, 10)
+	mark := func(_ context.Context, _ int64, at int64) {
+		runs <- at
+		<-release
+	}
+
+	q.start(1, 10, mark)
+	if at := <-runs; at != 10 {
+		t.Fatalf("first pass at %d, want 10", at)
+	}
+
+	// Both arrive while the first pass runs and fold into one more pass.
+	q.start(1, 11, mark)
+	q.start(1, 12, mark)
+	release <- struct{}{}
+
+	if at := <-runs; at != 12 {
+		t.Fatalf("second pass at %d, want 12", at)
+	}
+	release <- struct{}{}
+
+	select {
+	case at := <-runs:
+		t.Fatalf("unexpected third pass at %d", at)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) != 0 {
+		t.Fatalf("pending = %v after the passes finished, want empty", q.pending)
+	}
+}
+
+func TestReadQueueShutdownWaits(t *testing.T) {
+	q := newReadQueue()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.start(1, 10, func(context.Context, int64, int64) {
+		close(started)
+		<-release
+	})
+	<-started
+
+	done := make(chan error)
+	go func() { done <- q.shutdown(context.Background()) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned %v while a pass was running", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	q.start(2, 10, func(context.Context, int64, int64) {
+		t.Error("pass started after shutdown")
+	})
+}
+
+func TestReadQueueShutdownCancels(t *testing.T) {
+	q := newReadQueue()
+
+	started := make(chan struct{})
+	q.start(1, 10, func(ctx context.Context, _ int64, _ int64) {
+		close(started)
+		<-ctx.Done()
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown() = %v, want %v once the pass was cancelled", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pipe/internal/entity"
+	"pipe/pkg/sealed"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// ErrNotReplyable is returned for messages stored without a sealed sender,
+// such as those sent before replies existed.
+var ErrNotReplyable = errors.New("message can't be replied to")
+
+func (m *MessageService) GetUserReplies(ID int64, limit int, cursor []byte) ([]entity.Message, []byte, error) {
+	return m.messageRepository.RepliesByUserID(ID, limit, cursor)
+}
+
+// MessageSender returns the sender of one of ID's messages.
+func (m *MessageService) MessageSender(ID int64, messageID gocql.UUID) (int64, error) {
+	message, err := m.messageRepository.ByID(ID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	return m.openSender(message)
+}
+
+// ReplySender returns the sender of one of the replies ID received.
+func (m *MessageService) ReplySender(ID int64, replyID gocql.UUID) (int64, error) {
+	reply, err := m.messageRepository.ReplyByID(ID, replyID)
+	if err != nil {
+		return 0, err
+	}
+	return m.openSender(reply)
+}
+
+func (m *MessageService) openSender(message entity.Message) (int64, error) {
+	if message.SenderRef == "" {
+		return 0, ErrNotReplyable
+	}
+
+	sender, err := m.sealer.OpenSender(message.ToUser, message.SenderRef)
+	if err != nil {
+		if errors.Is(err, sealed.ErrInvalidRef) {
+			return 0, ErrNotReplyable
+		}
+		return 0, err
+	}
+	return sender, nil
+}
+
+// ReplyToMessage answers one of replier's messages. The reply goes to the
+// message's sender, who finds it among their replies; neither side learns who
+// the other is.
+func (m *MessageService) ReplyToMessage(ctx context.Context, replier int64, messageID gocql.UUID, text string, sender entity.User) (entity.Message, error) {
+	if err := m.checkBanned(replier); err != nil {
+		return entity.Message{}, err
+	}
+
+	muted, err := m.checkBlocked(sender.ID, replier)
+	if err != nil {
+		return entity.Message{}, err
+	}
+
+	reply := newReply(messageID, text, sender)
+	reply.Muted = muted
+
+	if err := m.checkEnvelope(&reply); err != nil {
+		return entity.Message{}, err
+	}
+
+	ttl, err := m.seal(&reply, replier, sender)
+	if err != nil {
+		return entity.Message{}, err
+	}
+
+	if err := m.messageRepository.SendReply(reply, m.sentCopy(reply, replier, ""), ttl); err != nil {
+		return entity.Message{}, err
+	}
+
+	return reply, m.PublishEvent(ctx, sender.ID, entity.Event{
+		ID:      reply.ID.String(),
+		Type:    entity.EventNewReply,
+		Message: outgoing(reply),
+	})
+}
+
+// ReplyToReply continues a thread: it answers one of the replies replier
+// received, and the answer lands in the inbox of whoever sent that reply.
+func (m *MessageService) ReplyToReply(ctx context.Context, replier int64, replyID gocql.UUID, text string, recipient entity.User) (entity.Message, error) {
+	message := newReply(replyID, text, recipient)
+
+	message, err := m.send(ctx, message, replier, recipient, "")
+	if err != nil {
+		return entity.Message{}, err
+	}
+
+	return message, m.DeliverMessage(ctx, recipient.ID, *outgoing(message))
+}
+
+func newReply(inReplyTo gocql.UUID, text string, recipient entity.User) entity.Message {
+	return entity.Message{
+		ID:        gocql.TimeUUID(),
+		ToUser:    recipient.ID,
+		Text:      text,
+		Date:      time.Now().Unix(),
+		InReplyTo: &inReplyTo,
+	}
+}
+
+// outgoing strips what the recipient's clients don't need to see.
+func outgoing(message entity.Message) *entity.Message {
+	return &entity.Message{
+		ID:              message.ID,
+		Text:            message.Text,
+		EnvelopeVersion: message.EnvelopeVersion,
+		Attachments:     message.Attachments,
+		Date:            message.Date,
+		Alias:           message.Alias,
+		ExpiresAt:       message.ExpiresAt,
+		InReplyTo:       message.InReplyTo,
+		Muted:           message.Muted,
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrSenderBanned     = errors.New("sender is banned")
+	ErrNotReportable    = errors.New("message has no sender to report")
+	ErrReportInvalid    = errors.New("report reason is missing or too long")
+	ErrReportNotPending = errors.New("report is already resolved")
+	ErrAlreadyReported  = errors.New("message is already reported")
+	ErrReportNotBanned  = errors.New("report didn't lead to a ban")
+)
+
+const (
+	maxReportReasonLength   = 500
+	maxReportEvidenceLength = 3000
+)
+
+// Report files a complaint by ID about one of their messages and lets the
+// moderators know. evidence is optional. Each message can be reported once
+// by the same user.
+func (m *MessageService) Report(ctx context.Context, ID int64, messageID gocql.UUID, reason, evidence string) (entity.Report, error) {
+	if err := checkReport(reason, evidence); err != nil {
+		return entity.Report{}, err
+	}
+
+	message, err := m.messageRepository.ByID(ID, messageID)
+	if err != nil {
+		return entity.Report{}, err
+	}
+	return m.report(ctx, ID, message, reason, evidence)
+}
+
+// ReportReply files a complaint by ID about one of the replies they received.
+func (m *MessageService) ReportReply(ctx context.Context, ID int64, replyID gocql.UUID, reason, evidence string) (entity.Report, error) {
+	if err := checkReport(reason, evidence); err != nil {
+		return entity.Report{}, err
+	}
+
+	reply, err := m.messageRepository.ReplyByID(ID, replyID)
+	if err != nil {
+		return entity.Report{}, err
+	}
+	return m.report(ctx, ID, reply, reason, evidence)
+}
+
+func checkReport(reason, evidence string) error {
+	if reason == "" || len(reason) > maxReportReasonLength || len(evidence) > maxReportEvidenceLength {
+		return ErrReportInvalid
+	}
+	return nil
+}
+
+func (m *MessageService) report(ctx context.Context, ID int64, message entity.Message, reason, evidence string) (entity.Report, error) {
+	if message.SenderRef == "" {
+		return entity.Report{}, ErrNotReportable
+	}
+
+	report := entity.Report{
+		ID:         gocql.TimeUUID(),
+		MessageID:  message.ID,
+		Reason:     reason,
+		Evidence:   evidence,
+		Status:     entity.ReportOpen,
+		CreatedAt:  time.Now(),
+		ReporterID: ID,
+		SenderRef:  message.SenderRef,
+	}
+	if err := m.moderationRepository.SaveReport(report); err != nil {
+		if errors.Is(err, repository.ErrReportExists) {
+			return entity.Report{}, ErrAlreadyReported
+		}
+		return entity.Report{}, err
+	}
+
+	return report, m.bus.Publish(ctx, entity.ModeratorsID, entity.Event{
+		ID:       gocql.TimeUUID().String(),
+		Type:     entity.EventReport,
+		ReportID: report.ID.String(),
+	})
+}
+
+func (m *MessageService) GetReport(reportID gocql.UUID) (entity.Report, error) {
+	return m.moderationRepository.ReportByID(reportID)
+}
+
+// BanReportedSender bans whoever sent the reported message from sending
+// anything else. The sender is unsealed here and never leaves the server.
+func (m *MessageService) BanReportedSender(reportID gocql.UUID, moderator int64) error {
+	report, err := m.moderationRepository.ReportByID(reportID)
+	if err != nil {
+		return err
+	}
+	if report.Status != entity.ReportOpen {
+		return ErrReportNotPending
+	}
+
+	sender, err := m.sealer.OpenSender(report.ReporterID, report.SenderRef)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := m.moderationRepository.Ban(sender, report.ID, moderator, now); err != nil {
+		return err
+	}
+	return m.resolveReport(report.ID, entity.ReportOpen, entity.ReportBanned, moderator, now)
+}
+
+// UnbanReportedSender lifts the ban a report led to, for bans made by
+// mistake. Like banning, it never reveals the sender.
+func (m *MessageService) UnbanReportedSender(reportID gocql.UUID, moderator int64) error {
+	report, err := m.moderationRepository.ReportByID(reportID)
+	if err != nil {
+		return err
+	}
+	if report.Status != entity.ReportBanned {
+		return ErrReportNotBanned
+	}
+
+	sender, err := m.sealer.OpenSender(report.ReporterID, report.SenderRef)
+	if err != nil {
+		return err
+	}
+
+	// Unbanning twice is harmless, so a failed status update can be retried.
+	if err := m.moderationRepository.Unban(sender); err != nil {
+		return err
+	}
+	err = m.moderationRepository.ResolveReport(report.ID, entity.ReportBanned, entity.ReportUnbanned, moderator, time.Now())
+	if errors.Is(err, repository.ErrReportResolved) {
+		return ErrReportNotBanned
+	}
+	return err
+}
+
+func (m *MessageService) DismissReport(reportID gocql.UUID, moderator int64) error {
+	return m.resolveReport(reportID, entity.ReportOpen, entity.ReportDismissed, moderator, time.Now())
+}
+
+func (m *MessageService) resolveReport(reportID gocql.UUID, from, to string, moderator int64, at time.Time) error {
+	err := m.moderationRepository.ResolveReport(reportID, from, to, moderator, at)
+	if errors.Is(err, repository.ErrReportResolved) {
+		return ErrReportNotPending
+	}
+	return err
+}
+
+func (m *MessageService) checkBanned(sender int64) error {
+	banned, err := m.moderationRepository.IsBanned(sender)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrSenderBanned
+	}
+	return nil
+}
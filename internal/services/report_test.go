@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"pipe/internal/bus"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// reportKey is what reports are deduplicated on.
+type reportKey struct {
+	reporter int64
+	message  gocql.UUID
+}
+
+// memoryModeration is an in-memory repository.Moderation.
+type memoryModeration struct {
+	reports  map[gocql.UUID]entity.Report
+	reported map[reportKey]bool
+	banned   map[int64]bool
+}
+
+func newMemoryModeration() *memoryModeration {
+	return &memoryModeration{
+		reports:  map[gocql.UUID]entity.Report{},
+		reported: map[reportKey]bool{},
+		banned:   map[int64]bool{},
+	}
+}
+
+func (m *memoryModeration) SaveReport(report entity.Report) error {
+	key := reportKey{report.ReporterID, report.MessageID}
+	if m.reported[key] {
+		return repository.ErrReportExists
+	}
+	m.reported[key] = true
+	m.reports[report.ID] = report
+	return nil
+}
+
+func (m *memoryModeration) ReportByID(reportID gocql.UUID) (entity.Report, error) {
+	report, ok := m.reports[reportID]
+	if !ok {
+		return entity.Report{}, gocql.ErrNotFound
+	}
+	return report, nil
+}
+
+func (m *memoryModeration) ResolveReport(reportID gocql.UUID, from, to string, _ int64, _ time.Time) error {
+	report, ok := m.reports[reportID]
+	if !ok || report.Status != from {
+		return repository.ErrReportResolved
+	}
+	report.Status = to
+	m.reports[reportID] = report
+	return nil
+}
+
+func (m *memoryModeration) Ban(userID int64, _ gocql.UUID, _ int64, _ time.Time) error {
+	m.banned[userID] = true
+	return nil
+}
+
+func (m *memoryModeration) Unban(userID int64) error {
+	delete(m.banned, userID)
+	return nil
+}
+
+func (m *memoryModeration) IsBanned(userID int64) (bool, error) {
+	return m.banned[userID], nil
+}
+
+func TestReportBanUnban(t *testing.T) {
+	sealer := testSealer(t)
+	replies := &memoryReplies{replies: map[gocql.UUID]entity.Message{}}
+	moderation := newMemoryModeration()
+	m := NewMessageService(MessageRepositories{Messages: replies, Moderation: moderation}, bus.NewLocal(), sealer, 0, 0, time.Time{}, nil)
+
+	const reporter, replier = 1, 2
+	ref, err := sealer.SealSender(reporter, replier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := entity.Message{ID: gocql.TimeUUID(), ToUser: reporter, SenderRef: ref}
+	replies.replies[reply.ID] = reply
+
+	ctx := context.Background()
+	if _, err := m.ReportReply(ctx, reporter, reply.ID, "", ""); !errors.Is(err, ErrReportInvalid) {
+		t.Fatalf("ReportReply() without a reason = %v, want %v", err, ErrReportInvalid)
+	}
+
+	report, err := m.ReportReply(ctx, reporter, reply.ID, "spam", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReportReply(ctx, reporter, reply.ID, "spam again", ""); !errors.Is(err, ErrAlreadyReported) {
+		t.Fatalf("second ReportReply() = %v, want %v", err, ErrAlreadyReported)
+	}
+
+	if err := m.UnbanReportedSender(report.ID, 99); !errors.Is(err, ErrReportNotBanned) {
+		t.Fatalf("UnbanReportedSender() of an open report = %v, want %v", err, ErrReportNotBanned)
+	}
+
+	if err := m.BanReportedSender(report.ID, 99); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.checkBanned(replier); !errors.Is(err, ErrSenderBanned) {
+		t.Fatalf("checkBanned() after ban = %v, want %v", err, ErrSenderBanned)
+	}
+	if err := m.BanReportedSender(report.ID, 99); !errors.Is(err, ErrReportNotPending) {
+		t.Fatalf("second BanReportedSender() = %v, want %v", err, ErrReportNotPending)
+	}
+
+	if err := m.UnbanReportedSender(report.ID, 99); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.checkBanned(replier); err != nil {
+		t.Fatalf("checkBanned() after unban = %v, want nil", err)
+	}
+	if status := moderation.reports[report.ID].Status; status != entity.ReportUnbanned {
+		t.Fatalf("report status = %q, want %q", status, entity.ReportUnbanned)
+	}
+	if err := m.UnbanReportedSender(report.ID, 99); !errors.Is(err, ErrReportNotBanned) {
+		t.Fatalf("second UnbanReportedSender() = %v, want %v", err, ErrReportNotBanned)
+	}
+}
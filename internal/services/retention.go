@@ -0,0 +1,38 @@
+package services
+
+import (
+	"slices"
+	"time"
+)
+
+// Retention values stored on a user, in seconds. RetentionDefault falls back
+// to the server's message TTL.
+const (
+	RetentionDefault = 0
+	RetentionForever = -1
+)
+
+var retentionChoices = []int{
+	RetentionDefault,
+	RetentionForever,
+	int((30 * time.Minute).Seconds()),
+	int((24 * time.Hour).Seconds()),
+	int((7 * 24 * time.Hour).Seconds()),
+}
+
+func ValidRetention(retention int) bool {
+	return slices.Contains(retentionChoices, retention)
+}
+
+// effectiveTTL resolves a user's retention setting to a TTL. Zero means the
+// message never expires.
+func effectiveTTL(retention int, defaultTTL time.Duration) time.Duration {
+	switch retention {
+	case RetentionDefault:
+		return defaultTTL
+	case RetentionForever:
+		return 0
+	default:
+		return time.Duration(retention) * time.Second
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"testing"
+	"time"
+)
+
+func TestValidRetention(t *testing.T) {
+	for _, retention := range []int{RetentionDefault, RetentionForever, 1800, 86400, 604800} {
+		if !ValidRetention(retention) {
+			t.Errorf("ValidRetention(%d) = false, want true", retention)
+		}
+	}
+	for _, retention := range []int{-2, 1, 60, 1799, 3600, 31536000} {
+		if ValidRetention(retention) {
+			t.Errorf("ValidRetention(%d) = true, want false", retention)
+		}
+	}
+}
+
+func TestEffectiveTTL(t *testing.T) {
+	tests := []struct {
+		retention int
+		want      time.Duration
+	}{
+		{RetentionDefault, 30 * time.Minute},
+		{RetentionForever, 0},
+		{86400, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := effectiveTTL(tt.retention, 30*time.Minute); got != tt.want {
+			t.Errorf("effectiveTTL(%d) = %v, want %v", tt.retention, got, tt.want)
+		}
+	}
+}
+
+func TestSealSetsExpiry(t *testing.T) {
+	m := NewMessageService(MessageRepositories{}, nil, testSealer(t), time.Hour, 0, time.Time{}, nil)
+
+	tests := []struct {
+		retention int
+		ttl       time.Duration
+		expiresAt int64
+	}{
+		{RetentionDefault, time.Hour, 1000 + 3600},
+		{86400, 24 * time.Hour, 1000 + 86400},
+		{RetentionForever, 0, 0},
+	}
+	for _, tt := range tests {
+		message := entity.Message{Date: 1000}
+		ttl, err := m.seal(&message, 2, entity.User{ID: 1, Retention: tt.retention})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl != tt.ttl || message.ExpiresAt != tt.expiresAt {
+			t.Errorf("retention %d: ttl %v, expires at %d; want %v and %d", tt.retention, ttl, message.ExpiresAt, tt.ttl, tt.expiresAt)
+		}
+	}
+}
+
+func TestSetRetentionRejectsInvalid(t *testing.T) {
+	s := NewAccountService(newMemoryAccounts(), AccountOptions{})
+	if _, err := s.SetRetention(entity.User{ID: 1}, 42); !errors.Is(err, ErrRetentionInvalid) {
+		t.Fatalf("SetRetention(42) = %v, want %v", err, ErrRetentionInvalid)
+	}
+}
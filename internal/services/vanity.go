@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"pipe/internal/entity"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrVanityIDInvalid   = errors.New("vanity id is not valid")
+	ErrVanityIDReserved  = errors.New("vanity id is reserved")
+	ErrVanityIDUnchanged = errors.New("vanity id is already in use by this user")
+)
+
+// VanityCooldownError is returned when a user changes their vanity ID again
+// before the cooldown has passed.
+type VanityCooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *VanityCooldownError) Error() string {
+	return fmt.Sprintf("vanity id can be changed again in %s", e.RetryAfter.Truncate(time.Second))
+}
+
+type VanityOptions struct {
+	MinLength int
+	MaxLength int
+	Alphabet  string
+	// Reserved IDs can't be claimed at all; Blocked words can't appear anywhere in an ID.
+	Reserved []string
+	Blocked  []string
+	Cooldown time.Duration
+}
+
+// ClaimVanityID replaces the user's private ID with a chosen one. When
+// keepOld is set the old private ID keeps working for the grace period.
+func (s *AccountService) ClaimVanityID(user entity.User, vanityID string, keepOld bool) (entity.User, error) {
+	vanityID = strings.ToLower(strings.TrimSpace(vanityID))
+
+	if err := s.validateVanityID(vanityID); err != nil {
+		return entity.User{}, err
+	}
+
+	if vanityID == user.PrivateID {
+		return entity.User{}, ErrVanityIDUnchanged
+	}
+
+	// Only vanity claims count towards the cooldown; rotating to a random ID
+	// must stay available at any time.
+	if cooldown := s.opts.Vanity.Cooldown; cooldown > 0 && !user.VanityChangedAt.IsZero() {
+		if wait := time.Until(user.VanityChangedAt.Add(cooldown)); wait > 0 {
+			return entity.User{}, &VanityCooldownError{RetryAfter: wait}
+		}
+	}
+
+	var grace time.Duration
+	if keepOld {
+		grace = s.opts.PrivateIDGracePeriod
+	}
+
+	changedAt := time.Now()
+	claim := user
+	claim.VanityChangedAt = changedAt
+	if err := s.repo.RotatePrivateID(claim, vanityID, grace); err != nil {
+		return entity.User{}, err
+	}
+
+	user.PrivateID = vanityID
+	user.PrivateIDChangedAt = changedAt
+	user.VanityChangedAt = changedAt
+	return user, nil
+}
+
+func (s *AccountService) validateVanityID(vanityID string) error {
+	rules := s.opts.Vanity
+
+	if len(vanityID) < rules.MinLength || len(vanityID) > rules.MaxLength {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrVanityIDInvalid, rules.MinLength, rules.MaxLength)
+	}
+
+	for _, r := range vanityID {
+		if !strings.ContainsRune(rules.Alphabet, r) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrVanityIDInvalid, r)
+		}
+	}
+
+	if slices.Contains(rules.Reserved, vanityID) {
+		return ErrVanityIDReserved
+	}
+
+	for _, word := range rules.Blocked {
+		if word != "" && strings.Contains(vanityID, word) {
+			return ErrVanityIDReserved
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"pipe/internal/entity"
+	"pipe/internal/repository"
+	"testing"
+	"time"
+)
+
+func newVanityService(accounts *memoryAccounts) *AccountService {
+	return NewAccountService(accounts, AccountOptions{
+		PrivateIDGracePeriod: time.Hour,
+		Vanity: VanityOptions{
+			MinLength: 4,
+			MaxLength: 12,
+			Alphabet:  "abcdefghijklmnopqrstuvwxyz0123456789_",
+			Reserved:  []string{"admin"},
+			Blocked:   []string{"spam"},
+			Cooldown:  24 * time.Hour,
+		},
+	})
+}
+
+func TestValidateVanityID(t *testing.T) {
+	s := newVanityService(newMemoryAccounts())
+
+	tests := map[string]error{
+		"alice_42":      nil,
+		"abc":           ErrVanityIDInvalid,
+		"abcdefghijklm": ErrVanityIDInvalid,
+		"ali-ce":        ErrVanityIDInvalid,
+		"admin":         ErrVanityIDReserved,
+		"nospamplease":  ErrVanityIDReserved,
+	}
+	for id, want := range tests {
+		if err := s.validateVanityID(id); !errors.Is(err, want) {
+			t.Errorf("validateVanityID(%q) = %v, want %v", id, err, want)
+		}
+	}
+}
+
+func TestClaimVanityID(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := newVanityService(accounts)
+
+	user, err := s.CreateUser(entity.User{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := s.ClaimVanityID(user, "  Alice ", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed.PrivateID != "alice" {
+		t.Fatalf("claimed private ID = %q, want it normalized to %q", claimed.PrivateID, "alice")
+	}
+	if _, err := s.ClaimVanityID(claimed, "alice", false); !errors.Is(err, ErrVanityIDUnchanged) {
+		t.Fatalf("claiming the current ID again = %v, want %v", err, ErrVanityIDUnchanged)
+	}
+
+	other, err := s.CreateUser(entity.User{ID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimVanityID(other, "ALICE", false); !errors.Is(err, repository.ErrPrivateIDTaken) {
+		t.Fatalf("claiming a taken ID = %v, want %v", err, repository.ErrPrivateIDTaken)
+	}
+}
+
+func TestVanityCooldown(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s := newVanityService(accounts)
+
+	user, err := s.CreateUser(entity.User{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating to a random ID doesn't start the vanity cooldown.
+	if _, err := s.RotatePrivateID(user, false); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = accounts.ByID(1)
+	if _, err := s.ClaimVanityID(user, "alice", false); err != nil {
+		t.Fatalf("ClaimVanityID() after a random rotation = %v, want nil", err)
+	}
+
+	// A vanity claim does, and survives a random rotation in between.
+	user, _ = accounts.ByID(1)
+	if _, err := s.RotatePrivateID(user, false); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = accounts.ByID(1)
+
+	var cooldown *VanityCooldownError
+	if _, err := s.ClaimVanityID(user, "bob_1", false); !errors.As(err, &cooldown) {
+		t.Fatalf("ClaimVanityID() within the cooldown = %v, want a cooldown error", err)
+	}
+	if cooldown.RetryAfter <= 23*time.Hour {
+		t.Fatalf("RetryAfter = %s, want about 24h", cooldown.RetryAfter)
+	}
+
+	user.VanityChangedAt = time.Now().Add(-25 * time.Hour)
+	if _, err := s.ClaimVanityID(user, "bob_1", false); err != nil {
+		t.Fatalf("ClaimVanityID() after the cooldown = %v, want nil", err)
+	}
+}
@@ -1,7 +1,15 @@
 package main
 
-import "pipe/cmd"
+import (
+	"os"
+	"pipe/cmd"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "seal-senders" {
+		cmd.SealSenders()
+		return
+	}
+
 	cmd.Serve()
 }
@@ -0,0 +1,197 @@
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoKeys     = errors.New("no sender token keys configured")
+	ErrInvalidRef = errors.New("sender reference is invalid")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Key is a versioned secret for sender tokens.
+type Key struct {
+	Version string
+	Secret  []byte
+}
+
+// Sealer derives opaque sender tokens. The first key signs new tokens; the
+// rest are kept so tokens issued before a rotation can still be matched.
+type Sealer struct {
+	keys []Key
+}
+
+func NewSealer(keys []Key) (*Sealer, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	for _, key := range keys {
+		if key.Version == "" || strings.Contains(key.Version, ".") || len(key.Secret) == 0 {
+			return nil, fmt.Errorf("invalid sender token key %q", key.Version)
+		}
+	}
+	return &Sealer{keys: keys}, nil
+}
+
+// ParseKeys parses a comma separated "version:secret" list, newest key first.
+func ParseKeys(value string) ([]Key, error) {
+	var keys []Key
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		version, secret, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("sender token key %q is not in version:secret form", item)
+		}
+		keys = append(keys, Key{Version: version, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// SenderToken identifies sender to recipient without revealing sender. The
+// same sender gets a different token for every recipient.
+func (s *Sealer) SenderToken(recipient, sender int64) string {
+	return senderToken(s.keys[0], recipient, sender)
+}
+
+// SenderTokens returns sender's token for recipient under every key, newest
+// first, for matching tokens stored before a rotation.
+func (s *Sealer) SenderTokens(recipient, sender int64) []string {
+	tokens := make([]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		tokens = append(tokens, senderToken(key, recipient, sender))
+	}
+	return tokens
+}
+
+func senderToken(key Key, recipient, sender int64) string {
+	return keyedToken(key, strconv.FormatInt(recipient, 10)+":"+strconv.FormatInt(sender, 10))
+}
+
+// OutboxToken keys the list of messages sender has sent.
+func (s *Sealer) OutboxToken(sender int64) string {
+	return outboxToken(s.keys[0], sender)
+}
+
+// OutboxTokens returns sender's outbox token under every key, newest first.
+func (s *Sealer) OutboxTokens(sender int64) []string {
+	tokens := make([]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		tokens = append(tokens, outboxToken(key, sender))
+	}
+	return tokens
+}
+
+// RefOutboxToken returns the outbox token sender had when ref was sealed, so
+// outbox entries written alongside ref can be found after a rotation.
+func (s *Sealer) RefOutboxToken(sender int64, ref string) (string, error) {
+	version, _, _ := strings.Cut(ref, ".")
+	for _, key := range s.keys {
+		if key.Version == version {
+			return outboxToken(key, sender), nil
+		}
+	}
+	return "", ErrInvalidRef
+}
+
+func outboxToken(key Key, sender int64) string {
+	return keyedToken(key, "outbox:"+strconv.FormatInt(sender, 10))
+}
+
+func keyedToken(key Key, data string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(data))
+	return key.Version + "." + encoding.EncodeToString(mac.Sum(nil))
+}
+
+// SealSender encrypts sender for recipient so that only the server can
+// recover it later, for example to route a reply. The reference is bound to
+// recipient and can't be opened for anyone else.
+func (s *Sealer) SealSender(recipient, sender int64) (string, error) {
+	key := s.keys[0]
+
+	aead, err := refCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	plaintext := binary.BigEndian.AppendUint64(nil, uint64(sender))
+	sealed := aead.Seal(nonce, nonce, plaintext, refData(recipient))
+	return key.Version + "." + encoding.EncodeToString(sealed), nil
+}
+
+// OpenSender recovers the sender sealed for recipient by SealSender.
+func (s *Sealer) OpenSender(recipient int64, ref string) (int64, error) {
+	version, encoded, ok := strings.Cut(ref, ".")
+	if !ok {
+		return 0, ErrInvalidRef
+	}
+
+	var key *Key
+	for i := range s.keys {
+		if s.keys[i].Version == version {
+			key = &s.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return 0, ErrInvalidRef
+	}
+
+	sealed, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return 0, ErrInvalidRef
+	}
+
+	aead, err := refCipher(*key)
+	if err != nil {
+		return 0, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return 0, ErrInvalidRef
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, refData(recipient))
+	if err != nil || len(plaintext) != 8 {
+		return 0, ErrInvalidRef
+	}
+	return int64(binary.BigEndian.Uint64(plaintext)), nil
+}
+
+// refCipher derives the AES-256-GCM cipher for sender references from key,
+// keeping it separate from the key used for sender tokens.
+func refCipher(key Key) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte("sender-ref"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func refData(recipient int64) []byte {
+	return []byte(strconv.FormatInt(recipient, 10))
+}
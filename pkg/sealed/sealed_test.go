@@ -0,0 +1,150 @@
+package sealed
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestSealer(t *testing.T, keys string) *Sealer {
+	t.Helper()
+	parsed, err := ParseKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer, err := NewSealer(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(" 2:newer , 1:older,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Version != "2" || string(keys[0].Secret) != "newer" || keys[1].Version != "1" {
+		t.Fatalf("ParseKeys() = %+v, want versions 2 and 1", keys)
+	}
+
+	if _, err := ParseKeys("no-version"); err == nil {
+		t.Fatal("key without a version was accepted")
+	}
+}
+
+func TestNewSealerRejects(t *testing.T) {
+	if _, err := NewSealer(nil); !errors.Is(err, ErrNoKeys) {
+		t.Fatalf("NewSealer(nil) = %v, want %v", err, ErrNoKeys)
+	}
+	if _, err := NewSealer([]Key{{Version: "1.2", Secret: []byte("secret")}}); err == nil {
+		t.Fatal("version containing a dot was accepted")
+	}
+	if _, err := NewSealer([]Key{{Version: "1"}}); err == nil {
+		t.Fatal("empty secret was accepted")
+	}
+}
+
+func TestSenderToken(t *testing.T) {
+	sealer := newTestSealer(t, "1:secret")
+
+	if sealer.SenderToken(1, 2) != sealer.SenderToken(1, 2) {
+		t.Fatal("sender token is not stable for the same pair")
+	}
+	if sealer.SenderToken(1, 2) == sealer.SenderToken(3, 2) {
+		t.Fatal("sender token is the same for different recipients")
+	}
+	if sealer.SenderToken(1, 2) == sealer.OutboxToken(2) {
+		t.Fatal("sender token collides with the outbox token")
+	}
+}
+
+func TestTokensAcrossRotation(t *testing.T) {
+	old := newTestSealer(t, "1:older")
+	rotated := newTestSealer(t, "2:newer,1:older")
+
+	tokens := rotated.SenderTokens(1, 2)
+	if len(tokens) != 2 || tokens[0] != rotated.SenderToken(1, 2) || tokens[1] != old.SenderToken(1, 2) {
+		t.Fatalf("SenderTokens() = %v, want the new token then the old one", tokens)
+	}
+
+	outbox := rotated.OutboxTokens(2)
+	if len(outbox) != 2 || outbox[1] != old.OutboxToken(2) {
+		t.Fatalf("OutboxTokens() = %v, want the old token last", outbox)
+	}
+
+	ref, err := old.SealSender(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := rotated.RefOutboxToken(2, ref)
+	if err != nil || token != old.OutboxToken(2) {
+		t.Fatalf("RefOutboxToken() = %q, %v; want the outbox token of the sealing key", token, err)
+	}
+}
+
+func TestSealOpenSender(t *testing.T) {
+	sealer := newTestSealer(t, "1:secret")
+
+	ref, err := sealer.SealSender(1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sealer.SealSender(1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref == other {
+		t.Fatal("sealing the same sender twice gave the same reference")
+	}
+
+	sender, err := sealer.OpenSender(1, ref)
+	if err != nil || sender != 42 {
+		t.Fatalf("OpenSender() = %d, %v; want 42", sender, err)
+	}
+
+	rotated := newTestSealer(t, "2:newer,1:secret")
+	if sender, err := rotated.OpenSender(1, ref); err != nil || sender != 42 {
+		t.Fatalf("OpenSender() after rotation = %d, %v; want 42", sender, err)
+	}
+}
+
+func TestOpenSenderRejects(t *testing.T) {
+	sealer := newTestSealer(t, "1:secret")
+
+	ref, err := sealer.SealSender(1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := ref[:len(ref)-2] + "AA"
+	if tampered == ref {
+		tampered = ref[:len(ref)-2] + "BB"
+	}
+
+	tests := []struct {
+		name      string
+		sealer    *Sealer
+		recipient int64
+		ref       string
+	}{
+		{"wrong recipient", sealer, 2, ref},
+		{"unknown key", newTestSealer(t, "2:newer"), 1, ref},
+		{"same version, other secret", newTestSealer(t, "1:other"), 1, ref},
+		{"no version", sealer, 1, "garbage"},
+		{"bad encoding", sealer, 1, "1.!!!"},
+		{"too short", sealer, 1, "1.AAAA"},
+		{"tampered", sealer, 1, tampered},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.sealer.OpenSender(tt.recipient, tt.ref); !errors.Is(err, ErrInvalidRef) {
+				t.Fatalf("OpenSender() = %v, want %v", err, ErrInvalidRef)
+			}
+		})
+	}
+
+	if _, err := sealer.RefOutboxToken(42, "9.unknown"); !errors.Is(err, ErrInvalidRef) {
+		t.Fatalf("RefOutboxToken() with unknown key = %v, want %v", err, ErrInvalidRef)
+	}
+}
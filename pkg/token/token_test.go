@@ -0,0 +1,46 @@
+package token
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	payload := []byte(`{"id":"abc","user_id":42}`)
+
+	got, err := Verify(Sign(payload, secret), secret)
+	if err != nil {
+		t.Fatalf("Verify() = %v, want nil", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("Verify() payload = %q, want %q", got, payload)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	secret := []byte("secret")
+	signed := Sign([]byte("payload"), secret)
+	encodedPayload, encodedSig, _ := strings.Cut(signed, ".")
+
+	tests := map[string]string{
+		"wrong secret":    Sign([]byte("payload"), []byte("other")),
+		"swapped payload": encoding.EncodeToString([]byte("payloae")) + "." + encodedSig,
+		"missing sig":     encodedPayload,
+		"empty sig":       encodedPayload + ".",
+		"bad payload b64": "!!!." + encodedSig,
+		"bad sig b64":     encodedPayload + ".!!!",
+		"truncated sig":   encodedPayload + "." + encodedSig[:len(encodedSig)-2],
+		"empty token":     "",
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Verify(token, secret); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("Verify(%q) = %v, want %v", token, err, ErrInvalid)
+			}
+		})
+	}
+}
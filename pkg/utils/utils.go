@@ -1,25 +1,37 @@
 package utils
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"errors"
+	"math/big"
 	"strings"
-	"time"
 )
 
-func init() {
-	rand.NewSource(time.Now().UnixNano())
-}
-
 const (
-	letters = "abcdefghigklnmopqrsyuvwxyz"
+	DefaultPrivateIDAlphabet = "abcdefghijklmnopqrstuvwxyz"
+	DefaultPrivateIDLength   = 6
 )
 
-func GenerateRandomPrivateID() string {
+// GenerateRandomPrivateID returns a private ID of the given length drawn
+// uniformly from alphabet using crypto/rand.
+func GenerateRandomPrivateID(length int, alphabet string) (string, error) {
+	if length <= 0 {
+		return "", errors.New("private id length must be positive")
+	}
+	if len(alphabet) < 2 {
+		return "", errors.New("private id alphabet must have at least two characters")
+	}
+
 	var privateID strings.Builder
+	max := big.NewInt(int64(len(alphabet)))
 
-	for i := 0; i < 6; i++ {
-		privateID.WriteByte(letters[rand.Intn(len(letters))])
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		privateID.WriteByte(alphabet[n.Int64()])
 	}
 
-	return privateID.String()
+	return privateID.String(), nil
 }